@@ -2,10 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/calobozan/jb-serve/internal/broker"
 	"github.com/calobozan/jb-serve/internal/client"
@@ -13,6 +17,7 @@ import (
 	"github.com/calobozan/jb-serve/internal/server"
 	"github.com/calobozan/jb-serve/internal/tools"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -37,8 +42,9 @@ Uses github.com/richinsley/jumpboot for Python environment management.
 Most commands communicate with a running jb-serve server (like ollama).
 Start the server with: jb-serve serve
 
-Commands that require the server: list, info, start, stop, call
-Commands that work standalone: install, serve`,
+Commands that require the server: install, uninstall, upgrade, list, info,
+start, stop, call
+Commands that work standalone: serve`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		if cmd.Name() == "help" {
 			return nil
@@ -55,8 +61,7 @@ func initApp(cmd *cobra.Command) error {
 
 	// Commands that need direct manager access (standalone)
 	standaloneCommands := map[string]bool{
-		"install": true,
-		"serve":   true,
+		"serve": true,
 	}
 
 	// For standalone commands, initialize manager directly
@@ -101,6 +106,10 @@ func init() {
 	rootCmd.PersistentFlags().IntVarP(&serverPort, "port", "p", 9800, "Server port to connect to")
 
 	rootCmd.AddCommand(installCmd)
+	rootCmd.AddCommand(uninstallCmd)
+	rootCmd.AddCommand(upgradeCmd)
+	rootCmd.AddCommand(lockCmd)
+	rootCmd.AddCommand(bundleCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(infoCmd)
 	rootCmd.AddCommand(schemaCmd)
@@ -108,16 +117,266 @@ func init() {
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(stopCmd)
 	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(reloadCmd)
 }
 
-// install - standalone, doesn't need server
+// install - uses HTTP client
+var installFromLock string
 var installCmd = &cobra.Command{
-	Use:   "install <git-url-or-path>",
-	Short: "Install a tool from git or local path",
-	Args:  cobra.ExactArgs(1),
+	Use:   "install <git-url[@ref]|path|bundle>",
+	Short: "Install a tool from git, a local path or a bundle",
+	Long: `Install a tool from a git URL or local path. Append @<tag|branch|commit>
+to a git URL to install that ref instead of the default branch. A .tar.gz made by
+"jb-serve bundle" installs the same versions using only the wheels inside it.
+
+With --from-lock, install every tool in a lockfile (see "jb-serve lock") at its
+recorded commit and package versions.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		_, err := manager.Install(args[0])
+		if installFromLock != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("--from-lock can't be combined with a source")
+			}
+			return installLockfile(installFromLock)
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("requires a git URL or path, or --from-lock")
+		}
+		source := args[0]
+
+		// The server resolves paths itself, so send local directories and bundles as
+		// absolute paths
+		if _, err := os.Stat(expandHome(source)); err == nil {
+			abs, err := filepath.Abs(expandHome(source))
+			if err != nil {
+				return err
+			}
+			source = abs
+		}
+
+		info, err := apiClient.Install(source, printLogLine)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s v%s\n", info.Name, info.Version)
+		return nil
+	},
+}
+
+func init() {
+	installCmd.Flags().StringVar(&installFromLock, "from-lock", "", "Install every tool in a lockfile at its locked versions")
+}
+
+// installLockfile installs each tool in a lockfile that isn't installed yet
+func installLockfile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
 		return err
+	}
+	var lock client.Lockfile
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(lock.Tools))
+	for name := range lock.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		entry := lock.Tools[name]
+		fmt.Printf("==> %s %s\n", name, shortCommit(entry.Commit))
+
+		if installed, err := apiClient.Info(name); err == nil {
+			if entry.Commit != "" && installed.Commit != entry.Commit {
+				fmt.Fprintf(os.Stderr, "%s is already installed at %s; uninstall it to match the lockfile\n",
+					name, shortCommit(installed.Commit))
+				failed++
+			} else {
+				fmt.Printf("%s is already installed\n", name)
+			}
+			continue
+		}
+
+		if _, err := apiClient.InstallLocked(entry, printLogLine); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to install %s: %v\n", name, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tools don't match the lockfile", failed, len(names))
+	}
+	fmt.Printf("All %d tools match %s\n", len(names), path)
+	return nil
+}
+
+// shortCommit abbreviates a commit SHA for display
+func shortCommit(sha string) string {
+	if sha == "" {
+		return "(no commit)"
+	}
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
+
+// lock - uses HTTP client
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Print the server's lockfile: where each tool came from and its package versions",
+	Long: `Print the server's lockfile as YAML. Save it and run
+"jb-serve install --from-lock <file>" on another machine to reproduce the same tools.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lock, err := apiClient.Lock()
+		if err != nil {
+			return err
+		}
+		data, err := yaml.Marshal(lock)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	},
+}
+
+// uninstall - uses HTTP client
+var uninstallRemoveEnv bool
+var uninstallCmd = &cobra.Command{
+	Use:   "uninstall <tool-name>",
+	Short: "Stop and remove an installed tool",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := apiClient.Uninstall(args[0], uninstallRemoveEnv); err != nil {
+			return err
+		}
+		fmt.Printf("Uninstalled %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	uninstallCmd.Flags().BoolVar(&uninstallRemoveEnv, "remove-env", false, "Also delete the tool's Python environment if no other tool shares it")
+}
+
+// upgrade - uses HTTP client
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade <tool-name>",
+	Short: "Pull and reinstall the latest version of a tool",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info, err := apiClient.Upgrade(args[0], printLogLine)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s is now at v%s (%s)\n", info.Name, info.Version, info.Status)
+		return nil
+	},
+}
+
+// bundle - uses HTTP client
+var bundleOutput string
+var bundleCmd = &cobra.Command{
+	Use:   "bundle <tool-name>",
+	Short: "Export a tool and its wheels to a tarball for offline installs",
+	Long: `Write a tool, its exact package versions and wheels for all of its pip
+packages to a .tar.gz. Copy it to another host and run "jb-serve install <file>"
+there to install without network access. The server builds the bundle in its own
+bundles directory and the CLI downloads it to the output path.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := bundleOutput
+		if path == "" {
+			path = args[0] + ".tar.gz"
+		}
+		path, err := filepath.Abs(expandHome(path))
+		if err != nil {
+			return err
+		}
+
+		result, err := apiClient.Bundle(args[0], printLogLine)
+		if err != nil {
+			return err
+		}
+
+		// Download next to the target and rename, so a failed copy never leaves
+		// a truncated bundle at path
+		f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		size, err := apiClient.DownloadBundle(args[0], f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(f.Name(), path); err != nil {
+			return err
+		}
+
+		fmt.Printf("Bundled %s v%s with %d wheels to %s (%s)\n",
+			result.Tool, result.Version, result.Wheels, path, formatSize(size))
+		return nil
+	},
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&bundleOutput, "output", "o", "", "Bundle path (default: <tool>.tar.gz)")
+}
+
+// printLogLine prints one line of server-side install output
+func printLogLine(line string) {
+	fmt.Println(line)
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// reload - uses HTTP client
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Rescan the tools directory on the running server",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := apiClient.Reload()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range result.Added {
+			fmt.Printf("added      %s\n", name)
+		}
+		for _, name := range result.Updated {
+			fmt.Printf("updated    %s\n", name)
+		}
+		for _, name := range result.Removed {
+			fmt.Printf("removed    %s\n", name)
+		}
+		for _, name := range result.Restarted {
+			fmt.Printf("restarted  %s\n", name)
+		}
+		for dir, problem := range result.Errors {
+			fmt.Fprintf(os.Stderr, "error      %s: %s\n", dir, problem)
+		}
+		if len(result.Added)+len(result.Updated)+len(result.Removed) == 0 {
+			fmt.Println("No changes.")
+		}
+		return nil
 	},
 }
 
@@ -149,8 +408,12 @@ var listCmd = &cobra.Command{
 			if mode == "" {
 				mode = "-"
 			}
+			status := t.Status
+			if t.NeedsRebuild {
+				status += " (needs rebuild)"
+			}
 			fmt.Printf("%-20s %-8s %-10s %-12s %s\n",
-				t.Name, t.Type, t.Version, mode, t.Status)
+				t.Name, t.Type, t.Version, mode, status)
 		}
 		return nil
 	},
@@ -176,6 +439,35 @@ var infoCmd = &cobra.Command{
 		fmt.Printf("Description:  %s\n", strings.TrimSpace(info.Description))
 		fmt.Printf("Mode:         %s\n", info.Mode)
 		fmt.Printf("Status:       %s\n", info.Status)
+		if info.RestartCount > 0 {
+			fmt.Printf("Restarts:     %d\n", info.RestartCount)
+		}
+		if info.LastExit != "" {
+			fmt.Printf("Last exit:    %s\n", info.LastExit)
+		}
+		if info.Source != "" {
+			fmt.Printf("Source:       %s\n", info.Source)
+		}
+		if info.Commit != "" {
+			fmt.Printf("Commit:       %s\n", info.Commit)
+		}
+		if len(info.Env) > 0 {
+			keys := make([]string, 0, len(info.Env))
+			for key := range info.Env {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			fmt.Println("Environment:")
+			for _, key := range keys {
+				fmt.Printf("  %s=%s\n", key, info.Env[key])
+			}
+		}
+		if sb := info.Sandbox; sb != nil {
+			fmt.Printf("Sandbox:      %s\n", formatSandbox(sb))
+		}
+		if info.NeedsRebuild {
+			fmt.Printf("Environment:  needs rebuild (dependencies changed; run: jb-serve env rebuild %s)\n", info.Name)
+		}
 
 		if len(info.Capabilities) > 0 {
 			fmt.Println("\nCapabilities:")
@@ -232,8 +524,33 @@ var schemaCmd = &cobra.Command{
 	},
 }
 
+// methodStringFields returns the input fields a method declares as strings or
+// files, from the server's schema. Nil if the schema isn't available.
+func methodStringFields(toolName, methodName string) map[string]bool {
+	info, err := apiClient.Info(toolName)
+	if err != nil {
+		return nil
+	}
+	methods, _ := info.Methods.(map[string]interface{})
+	method, _ := methods[methodName].(map[string]interface{})
+	input, _ := method["input"].(map[string]interface{})
+	props, _ := input["properties"].(map[string]interface{})
+
+	fields := make(map[string]bool)
+	for name, prop := range props {
+		p, _ := prop.(map[string]interface{})
+		if t, _ := p["type"].(string); t == "string" || t == "file" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
 // call - uses HTTP client
 var callJSON string
+var callTimeout time.Duration
+var callStream bool
+var callAsync bool
 var callCmd = &cobra.Command{
 	Use:   "call <tool.method> [key=value ...]",
 	Short: "Call a tool method",
@@ -245,6 +562,13 @@ Parameters can be passed as key=value pairs:
 Or as JSON with --json:
   jb-serve call calculator.add --json '{"a": 2, "b": 3}'
 
+With --async, the call runs in the background and its job ID is printed.
+Follow it with "jb-serve jobs wait <id>".
+
+With --stream, output is printed as the tool produces it. Progress and logs go
+to stderr:
+  jb-serve call llm.generate prompt="Hello" --stream
+
 Requires the jb-serve server to be running.`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -265,15 +589,20 @@ Requires the jb-serve server to be running.`,
 				return fmt.Errorf("invalid JSON: %w", err)
 			}
 		} else {
-			// key=value pairs from remaining args
-			// Note: Without schema access, we treat everything as strings
-			// The server will handle type conversion based on method schema
+			// key=value pairs from remaining args. Fields the method's schema
+			// declares as strings are sent as given; the server converts the rest
+			// by schema, so guessing only matters for undeclared fields.
+			stringFields := methodStringFields(toolName, methodName)
 			for _, arg := range args[1:] {
 				kv := strings.SplitN(arg, "=", 2)
 				if len(kv) != 2 {
 					return fmt.Errorf("invalid parameter format %q, expected key=value", arg)
 				}
 				key, val := kv[0], kv[1]
+				if stringFields[key] {
+					params[key] = val // e.g. prompt=2024 stays a string
+					continue
+				}
 
 				// Try to parse as number or boolean
 				var parsed interface{} = val
@@ -289,8 +618,25 @@ Requires the jb-serve server to be running.`,
 			}
 		}
 
-		result, err := apiClient.Call(toolName, methodName, params)
+		opts := client.CallOptions{Timeout: callTimeout}
+		if callAsync {
+			job, err := apiClient.CallAsync(toolName, methodName, params, opts)
+			if err != nil {
+				return err
+			}
+			fmt.Println(job.ID)
+			return nil
+		}
+		if callStream {
+			return streamCall(toolName, methodName, params, opts)
+		}
+
+		result, err := apiClient.CallWithOptions(toolName, methodName, params, opts)
 		if err != nil {
+			var apiErr *client.APIError
+			if errors.As(err, &apiErr) && apiErr.Traceback != "" {
+				fmt.Fprintln(os.Stderr, apiErr.Traceback)
+			}
 			return err
 		}
 
@@ -302,6 +648,57 @@ Requires the jb-serve server to be running.`,
 
 func init() {
 	callCmd.Flags().StringVar(&callJSON, "json", "", "Parameters as JSON object")
+	callCmd.Flags().DurationVar(&callTimeout, "timeout", 0, "Abandon the call after this long (e.g. 30s, 10m)")
+	callCmd.Flags().BoolVar(&callStream, "stream", false, "Print output incrementally as the tool streams it")
+	callCmd.Flags().BoolVar(&callAsync, "async", false, "Run in the background and print the job ID")
+}
+
+// streamCall prints a streaming call's chunks as they arrive, then the final result
+func streamCall(toolName, methodName string, params map[string]interface{}, opts client.CallOptions) error {
+	stream, err := apiClient.CallStream(toolName, methodName, params, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	chunked := false
+	for stream.Next() {
+		ev := stream.Event()
+		switch ev.Type {
+		case "chunk":
+			chunked = true
+			if text, ok := ev.Chunk.(string); ok {
+				fmt.Print(text)
+			} else {
+				out, _ := json.Marshal(ev.Chunk)
+				fmt.Println(string(out))
+			}
+		case "progress":
+			out, _ := json.Marshal(ev.Progress)
+			fmt.Fprintf(os.Stderr, "progress: %s\n", out)
+		case "log":
+			if rec, ok := ev.Log.(map[string]interface{}); ok {
+				fmt.Fprintf(os.Stderr, "[%v] %v\n", rec["level"], rec["message"])
+			}
+		case "result":
+			if chunked {
+				fmt.Println()
+			}
+			if ev.Result != nil {
+				out, _ := json.MarshalIndent(ev.Result, "", "  ")
+				fmt.Println(string(out))
+			}
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.Traceback != "" {
+			fmt.Fprintln(os.Stderr, apiErr.Traceback)
+		}
+		return err
+	}
+	return nil
 }
 
 // start - uses HTTP client
@@ -419,6 +816,291 @@ func init() {
 	rootCmd.AddCommand(filesCmd)
 }
 
+// envs - shared Python environments
+var envsCmd = &cobra.Command{
+	Use:     "envs",
+	Aliases: []string{"env"},
+	Short:   "Manage Python environments",
+}
+
+var envsListCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List environments and the tools using them",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		envs, err := apiClient.Envs()
+		if err != nil {
+			return err
+		}
+
+		if len(envs) == 0 {
+			fmt.Println("No environments.")
+			return nil
+		}
+
+		var total int64
+		fmt.Printf("%-24s  %-8s  %-10s  %s\n", "ENV", "PYTHON", "SIZE", "TOOLS")
+		for _, env := range envs {
+			users := strings.Join(env.Tools, ", ")
+			if users == "" {
+				users = "(orphaned)"
+			}
+			fmt.Printf("%-24s  %-8s  %-10s  %s\n", env.Name, env.Python, formatSize(env.SizeBytes), users)
+			total += env.SizeBytes
+		}
+		fmt.Printf("\n%d environments, %s total\n", len(envs), formatSize(total))
+		return nil
+	},
+}
+
+var envsPruneDryRun bool
+var envsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove environments no installed tool uses",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := apiClient.PruneEnvs(envsPruneDryRun)
+		if err != nil {
+			return err
+		}
+
+		if len(result.Removed) == 0 {
+			fmt.Println("No orphaned environments.")
+			return nil
+		}
+
+		verb := "Removed"
+		if result.DryRun {
+			verb = "Would remove"
+		}
+		for _, env := range result.Removed {
+			fmt.Printf("%s %s (%s)\n", verb, env.Name, formatSize(env.SizeBytes))
+		}
+		fmt.Printf("%s %d environments, %s\n", verb, len(result.Removed), formatSize(result.FreedBytes))
+		return nil
+	},
+}
+
+var envsRebuildCmd = &cobra.Command{
+	Use:   "rebuild <tool-name>",
+	Short: "Recreate a tool's environment from its manifest and rerun setup",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info, err := apiClient.Rebuild(args[0], printLogLine)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rebuilt environment for %s (%s)\n", info.Name, info.Status)
+		return nil
+	},
+}
+
+// formatSize renders a byte count like 1.2 GB
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatSandbox summarizes a sandbox policy for tool info
+func formatSandbox(sb *client.SandboxInfo) string {
+	parts := []string{"network off"}
+	if sb.Network {
+		parts[0] = "network on"
+	}
+	if sb.MemoryMB > 0 {
+		parts = append(parts, fmt.Sprintf("memory %d MB", sb.MemoryMB))
+	}
+	if sb.CPUSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("cpu %ds", sb.CPUSeconds))
+	}
+	if sb.OpenFiles > 0 {
+		parts = append(parts, fmt.Sprintf("%d open files", sb.OpenFiles))
+	}
+	for _, path := range sb.Paths {
+		parts = append(parts, path)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func init() {
+	envsPruneCmd.Flags().BoolVar(&envsPruneDryRun, "dry-run", false, "Only list what would be removed")
+
+	envsCmd.AddCommand(envsListCmd)
+	envsCmd.AddCommand(envsPruneCmd)
+	envsCmd.AddCommand(envsRebuildCmd)
+	rootCmd.AddCommand(envsCmd)
+}
+
+// jobs - background calls
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manage background calls",
+}
+
+var jobsListStatus string
+var jobsListTool string
+var jobsListJSON bool
+var jobsListCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List recent jobs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobs, err := apiClient.Jobs(jobsListStatus, jobsListTool)
+		if err != nil {
+			return err
+		}
+
+		if jobsListJSON {
+			data, _ := json.MarshalIndent(jobs, "", "  ")
+			fmt.Println(string(data))
+			return nil
+		}
+
+		if len(jobs) == 0 {
+			fmt.Println("No jobs.")
+			return nil
+		}
+
+		fmt.Printf("%-36s  %-10s  %-20s  %s\n", "ID", "STATUS", "CREATED", "CALL")
+		for _, j := range jobs {
+			created := time.Unix(j.CreatedAt, 0).Format("2006-01-02 15:04:05")
+			fmt.Printf("%-36s  %-10s  %-20s  %s.%s\n", j.ID, j.Status, created, j.Tool, j.Method)
+		}
+		return nil
+	},
+}
+
+var jobsGetCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "Show a job's status and result",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		job, err := apiClient.Job(args[0])
+		if err != nil {
+			return err
+		}
+		out, _ := json.MarshalIndent(job, "", "  ")
+		fmt.Println(string(out))
+		return nil
+	},
+}
+
+var jobsWaitTimeout time.Duration
+var jobsWaitCmd = &cobra.Command{
+	Use:   "wait <id>",
+	Short: "Wait for a job to finish and print its result",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		job, err := apiClient.WaitJob(args[0], time.Second, jobsWaitTimeout, func(p interface{}) {
+			out, _ := json.Marshal(p)
+			fmt.Fprintf(os.Stderr, "progress: %s\n", out)
+		})
+		if err != nil {
+			return err
+		}
+
+		switch job.Status {
+		case "succeeded":
+			out, _ := json.MarshalIndent(job.Result, "", "  ")
+			fmt.Println(string(out))
+			return nil
+		case "canceled":
+			return fmt.Errorf("job %s was canceled", job.ID)
+		default:
+			if job.Error != nil {
+				if job.Error.Traceback != "" {
+					fmt.Fprintln(os.Stderr, job.Error.Traceback)
+				}
+				return job.Error
+			}
+			return fmt.Errorf("job %s %s", job.ID, job.Status)
+		}
+	},
+}
+
+var jobsCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a running job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := apiClient.CancelJob(args[0]); err != nil {
+			return err
+		}
+		fmt.Println("Canceled")
+		return nil
+	},
+}
+
+func init() {
+	jobsListCmd.Flags().StringVar(&jobsListStatus, "status", "", "Only show jobs in this state (queued, running, succeeded, failed, canceled)")
+	jobsListCmd.Flags().StringVar(&jobsListTool, "tool", "", "Only show jobs for this tool")
+	jobsListCmd.Flags().BoolVar(&jobsListJSON, "json", false, "Output as JSON")
+	jobsWaitCmd.Flags().DurationVar(&jobsWaitTimeout, "timeout", 0, "Give up waiting after this long (the job keeps running)")
+
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsGetCmd)
+	jobsCmd.AddCommand(jobsWaitCmd)
+	jobsCmd.AddCommand(jobsCancelCmd)
+	rootCmd.AddCommand(jobsCmd)
+}
+
+// history - call log
+var historyOpts client.HistoryOptions
+var historyJSON bool
+var historyCmd = &cobra.Command{
+	Use:   "history [tool[.method]]",
+	Short: "Show recent calls",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := historyOpts
+		if len(args) == 1 {
+			opts.Tool, opts.Method, _ = strings.Cut(args[0], ".")
+		}
+
+		calls, err := apiClient.History(opts)
+		if err != nil {
+			return err
+		}
+
+		if historyJSON {
+			data, _ := json.MarshalIndent(calls, "", "  ")
+			fmt.Println(string(data))
+			return nil
+		}
+
+		if len(calls) == 0 {
+			fmt.Println("No calls.")
+			return nil
+		}
+
+		fmt.Printf("%-20s  %-30s  %-6s  %-6s  %10s  %s\n", "TIME", "CALL", "MODE", "STATUS", "DURATION", "CALLER")
+		for _, c := range calls {
+			started := time.UnixMilli(c.StartedAt).Format("2006-01-02 15:04:05")
+			status := c.Status
+			if c.ErrorCode != "" {
+				status = c.ErrorCode
+			}
+			duration := (time.Duration(c.DurationMS) * time.Millisecond).String()
+			fmt.Printf("%-20s  %-30s  %-6s  %-6s  %10s  %s\n", started, c.Tool+"."+c.Method, c.Mode, status, duration, c.Caller)
+		}
+		return nil
+	},
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyOpts.Status, "status", "", "Only show calls with this outcome (ok, error)")
+	historyCmd.Flags().StringVar(&historyOpts.Since, "since", "", "Only show calls after this time (RFC 3339, Unix seconds, or a duration like 1h)")
+	historyCmd.Flags().StringVar(&historyOpts.Until, "until", "", "Only show calls before this time")
+	historyCmd.Flags().IntVarP(&historyOpts.Limit, "limit", "n", 50, "Maximum number of calls to show")
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "Output as JSON")
+	rootCmd.AddCommand(historyCmd)
+}
+
 // serve - standalone, starts the server
 var (
 	servePort         int
@@ -428,26 +1110,59 @@ var (
 	serveSelfURL      string
 	serveNodeName     string
 	serveAgentDoc     string
+	serveNoWatch      bool
+	serveCapacity     int
+	serveTLSPort      int
 )
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start the HTTP API server",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		// Keep tool secrets out of the server log
+		log.SetOutput(manager.Redactor().Writer(os.Stderr))
+
+		serverTLS, peerTLS, err := cfg.Broker.TLSConfigs()
+		if err != nil {
+			return err
+		}
+		if serverTLS != nil && serveTLSPort == 0 {
+			return fmt.Errorf("broker.ca_file enables mTLS, which needs --tls-port")
+		}
+
 		opts := server.Options{
 			FileStorePath:    serveStorePath,
 			FileStoreDisable: serveStoreDisable,
+			TLS:              serverTLS,
+			TLSPort:          serveTLSPort,
+			PeerTLS:          peerTLS,
 		}
 		srv := server.NewWithOptions(cfg, manager, executor, opts)
+		defer srv.Close()
+
+		// Pick up installed, edited and removed tools without a restart
+		if !serveNoWatch {
+			stopWatch := executor.WatchTools(2 * time.Second)
+			defer stopWatch()
+		}
 
 		// If broker URL specified, register with broker
 		if serveBrokerURL != "" {
 			selfURL := serveSelfURL
-			if selfURL == "" {
+			if selfURL == "" && serverTLS != nil {
+				selfURL = fmt.Sprintf("https://localhost:%d", serveTLSPort)
+			} else if selfURL == "" {
 				selfURL = fmt.Sprintf("http://localhost:%d", servePort)
 			}
 
 			childClient := broker.NewChildClient(serveBrokerURL, selfURL, serveNodeName)
+			if cfg.Broker != nil {
+				childClient.SetSecret(cfg.Broker.Secret)
+			}
+			if peerTLS != nil {
+				childClient.SetTLS(peerTLS)
+			}
+			srv.AcceptBrokerToken(childClient.Token)
 
 			// Get tool names
 			toolList := manager.List()
@@ -456,6 +1171,18 @@ var serveCmd = &cobra.Command{
 				toolNames[i] = t.Name
 			}
 			childClient.SetTools(toolNames)
+			childClient.SetCapacity(serveCapacity)
+			childClient.SetRunningFunc(executor.Running)
+
+			// Keep the broker's view current as tools come and go
+			executor.OnReload(func(*tools.ReloadResult) {
+				toolList := manager.List()
+				toolNames := make([]string, len(toolList))
+				for i, t := range toolList {
+					toolNames[i] = t.Name
+				}
+				childClient.SetTools(toolNames)
+			})
 
 			// Load agent doc if specified
 			if serveAgentDoc != "" {
@@ -490,10 +1217,26 @@ func init() {
 	serveCmd.Flags().StringVar(&serveSelfURL, "self-url", "", "This server's URL for broker callbacks (default: http://localhost:PORT)")
 	serveCmd.Flags().StringVar(&serveNodeName, "name", "", "Node name for broker registration (default: hostname)")
 	serveCmd.Flags().StringVar(&serveAgentDoc, "agent-doc", "", "Path to agent documentation file (default: ~/.jb-serve/AGENT.md)")
+	serveCmd.Flags().BoolVar(&serveNoWatch, "no-watch", false, "Don't reload tools when the tools directory changes")
+	serveCmd.Flags().IntVar(&serveCapacity, "capacity", 1, "This server's share of traffic when the broker routes by weight")
+	serveCmd.Flags().IntVar(&serveTLSPort, "tls-port", 0, "Port for mTLS connections from the broker and other servers (needs broker.ca_file in config)")
 }
 
 // broker - standalone, starts the broker server
-var brokerPort int
+var (
+	brokerPort        int
+	brokerStrategy    string
+	brokerMaxRetries  int
+	brokerRetryBudget float64
+	brokerEjectAfter  int
+	brokerEjectFor    time.Duration
+	brokerMaxBodyMB   int64
+	brokerSecret      string
+	brokerAuthToken   string
+	brokerTLSCA       string
+	brokerTLSCert     string
+	brokerTLSKey      string
+)
 
 var brokerCmd = &cobra.Command{
 	Use:   "broker",
@@ -513,9 +1256,68 @@ Example:
 
   # On GPU server 2:
   jb-serve serve --port 9801 --broker http://broker:9800 --self-url http://gpu2:9801
+
+When several servers host the same tool, --strategy picks how calls are spread:
+round-robin (default), least-outstanding, or weighted by each server's --capacity.
+Servers with the tool already running are preferred over ones that would have to
+start it.
+
+A call that fails before its response starts (the server is unreachable, or
+answers 503 without a tool error code) is retried on another server. Other 5xx
+answers are retried only for idempotent requests: GET, PUT and DELETE, or calls sent with an
+Idempotency-Key header. A server that fails --eject-after times in a row gets no
+requests for --eject-for.
+
+Servers register with --secret when one is set, or a certificate signed by
+--tls-ca when mTLS is on; both default to the broker section of the config file.
+--auth-token makes callers present a token too.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		srv := broker.NewServer()
+		strategy, err := broker.ParseStrategy(brokerStrategy)
+		if err != nil {
+			return err
+		}
+		// Options treats zero as "use the default"
+		if brokerMaxRetries == 0 {
+			brokerMaxRetries = -1
+		}
+
+		// Flags override the config file's broker section
+		brokerCfg := &config.BrokerConfig{}
+		if fileCfg, err := config.Load(); err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		} else if fileCfg.Broker != nil {
+			brokerCfg = fileCfg.Broker
+		}
+		if cmd.Flags().Changed("secret") {
+			brokerCfg.Secret = brokerSecret
+		}
+		if cmd.Flags().Changed("tls-ca") {
+			brokerCfg.CAFile = brokerTLSCA
+		}
+		if cmd.Flags().Changed("tls-cert") {
+			brokerCfg.CertFile = brokerTLSCert
+		}
+		if cmd.Flags().Changed("tls-key") {
+			brokerCfg.KeyFile = brokerTLSKey
+		}
+		serverTLS, clientTLS, err := brokerCfg.TLSConfigs()
+		if err != nil {
+			return err
+		}
+
+		srv := broker.NewServerWithOptions(broker.Options{
+			Strategy:         strategy,
+			MaxRetries:       brokerMaxRetries,
+			RetryBudget:      brokerRetryBudget,
+			FailureThreshold: brokerEjectAfter,
+			EjectionTime:     brokerEjectFor,
+			MaxBodyBytes:     brokerMaxBodyMB << 20,
+			Secret:           brokerCfg.Secret,
+			AuthToken:        brokerAuthToken,
+			TLS:              serverTLS,
+			ClientTLS:        clientTLS,
+		})
 		defer srv.Close()
 		return srv.ListenAndServe(brokerPort)
 	},
@@ -523,5 +1325,16 @@ Example:
 
 func init() {
 	brokerCmd.Flags().IntVar(&brokerPort, "port", 9800, "Port to listen on")
+	brokerCmd.Flags().StringVar(&brokerStrategy, "strategy", "round-robin", "How to spread calls across servers hosting the same tool: round-robin, least-outstanding or weighted")
+	brokerCmd.Flags().IntVar(&brokerMaxRetries, "max-retries", broker.DefaultMaxRetries, "Other servers to try after a failed attempt (0 disables retries)")
+	brokerCmd.Flags().Float64Var(&brokerRetryBudget, "retry-budget", broker.DefaultRetryBudget, "Retries in flight as a share of all requests in flight")
+	brokerCmd.Flags().IntVar(&brokerEjectAfter, "eject-after", broker.DefaultFailureThreshold, "Consecutive failures before a server is ejected")
+	brokerCmd.Flags().DurationVar(&brokerEjectFor, "eject-for", broker.DefaultEjectionTime, "How long an ejected server gets no requests")
+	brokerCmd.Flags().Int64Var(&brokerMaxBodyMB, "max-body-mb", broker.DefaultMaxBodyBytes>>20, "Largest request body, in MB, the broker buffers for a call")
+	brokerCmd.Flags().StringVar(&brokerSecret, "secret", "", "Shared secret servers must present to register (default: broker.secret in config)")
+	brokerCmd.Flags().StringVar(&brokerAuthToken, "auth-token", "", "Token callers must present (default: none)")
+	brokerCmd.Flags().StringVar(&brokerTLSCA, "tls-ca", "", "CA certificate for mTLS with servers (default: broker.ca_file in config)")
+	brokerCmd.Flags().StringVar(&brokerTLSCert, "tls-cert", "", "Broker certificate for mTLS (default: broker.cert_file in config)")
+	brokerCmd.Flags().StringVar(&brokerTLSKey, "tls-key", "", "Broker private key for mTLS (default: broker.key_file in config)")
 	rootCmd.AddCommand(brokerCmd)
 }
@@ -0,0 +1,172 @@
+package broker
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Children register with the shared secret, or a client certificate under mTLS,
+// and get back a token the broker mints for them. Heartbeats must carry it, so
+// one child can't speak for another, and the broker presents it when it calls
+// the child on its own authority.
+
+// newToken mints a random credential
+func newToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic("broker: no randomness for tokens: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// bearer returns the token a request carries, in its Authorization header or,
+// like child servers accept, a token query parameter
+func bearer(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// forwardQuery returns a caller's query string without its token parameter, to
+// send on to a child. The token is the caller's credential for the broker; the
+// child gets credentials from authorize instead.
+func forwardQuery(u *url.URL) string {
+	if !u.Query().Has("token") {
+		return u.RawQuery
+	}
+	query := u.Query()
+	query.Del("token")
+	return query.Encode()
+}
+
+// tokenMatches compares a presented token with the expected one in constant time
+func tokenMatches(got, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// verifiedPeer reports whether a request came with a client certificate the
+// listener verified against the cluster CA
+func verifiedPeer(r *http.Request) bool {
+	return r != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// mayRegister reports whether a request may register a child: it carries the
+// shared secret or a verified certificate. Registration is open only when
+// neither is configured.
+func (b *Broker) mayRegister(r *http.Request) bool {
+	if tokenMatches(bearer(r), b.opts.Secret) || verifiedPeer(r) {
+		return true
+	}
+	return b.opts.Secret == "" && b.opts.TLS == nil
+}
+
+// peerIdentity returns the fingerprint of a request's verified client
+// certificate, or "" if it has none
+func peerIdentity(r *http.Request) string {
+	if !verifiedPeer(r) {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.VerifiedChains[0][0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// mayReplace reports whether a registration may take over a child ID. A live
+// child can only be replaced by itself, proving it with its current token or the
+// client certificate it registered with, so the shared secret alone can't redirect
+// another child's traffic. An ID that has stopped heartbeating is free.
+func (b *Broker) mayReplace(r *http.Request, childID, token string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	child, ok := b.children[childID]
+	if !ok || time.Since(child.LastHeartbeat) > b.heartbeatTimeout {
+		return true
+	}
+	if tokenMatches(token, child.token) {
+		return true
+	}
+	return child.peer != "" && child.peer == peerIdentity(r)
+}
+
+// mayCall reports whether a caller may use the broker's API
+func (b *Broker) mayCall(r *http.Request) bool {
+	if b.opts.AuthToken == "" {
+		return true
+	}
+	return tokenMatches(bearer(r), b.opts.AuthToken) || verifiedPeer(r)
+}
+
+// checkToken reports whether token is the one minted for a child at registration
+func (b *Broker) checkToken(childID, token string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	child, ok := b.children[childID]
+	return ok && tokenMatches(token, child.token)
+}
+
+// credentials returns the Authorization header for a request to a child made for
+// a caller. A caller the broker authenticated itself is represented by the
+// child's minted token; anyone else's credentials are passed on as they are, so
+// going through the broker never grants access the caller doesn't have.
+func (b *Broker) credentials(caller *http.Request, child *ChildServer) string {
+	if b.opts.AuthToken != "" || verifiedPeer(caller) {
+		return "Bearer " + child.token
+	}
+	if token := bearer(caller); token != "" && caller.Header.Get("Authorization") == "" {
+		return "Bearer " + token // Moved out of the query, which isn't forwarded
+	}
+	return caller.Header.Get("Authorization")
+}
+
+// authorize sets the credentials on a request to a child made for a caller
+func (b *Broker) authorize(req *http.Request, caller *http.Request, child *ChildServer) {
+	if auth := b.credentials(caller, child); auth != "" {
+		req.Header.Set("Authorization", auth)
+	} else {
+		req.Header.Del("Authorization")
+	}
+}
+
+// dialTimeout bounds connecting to a child. Calls themselves have no overall
+// timeout, since a tool can legitimately run for a long time.
+const dialTimeout = 10 * time.Second
+
+// transport returns the transport for connecting to children: a copy of the
+// default one with a dial timeout, using the mutual TLS config if there is one
+func transport(cfg *tls.Config) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	if cfg != nil {
+		t.TLSClientConfig = cfg.Clone()
+	}
+	return t
+}
+
+// authMiddleware checks registrations and API calls. Heartbeats are checked by
+// their handler, which knows the child they're for.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health", "/v1/broker/heartbeat":
+		case "/v1/broker/register":
+			if !s.broker.mayRegister(r) {
+				s.jsonError(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		default:
+			if !s.broker.mayCall(r) {
+				s.jsonError(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
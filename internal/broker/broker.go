@@ -6,11 +6,14 @@ package broker
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -22,9 +25,14 @@ type ChildServer struct {
 	Name          string    `json:"name"`           // Human-readable name
 	Tools         []string  `json:"tools"`          // List of tool names available
 	AgentDoc      string    `json:"agent_doc,omitempty"` // Markdown describing server purpose
+	Running       []string  `json:"running,omitempty"`  // Tools with a warm persistent instance, from the last heartbeat
+	Capacity      int       `json:"capacity,omitempty"` // Relative share of traffic under weighted routing, default: 1
 	RegisteredAt  time.Time `json:"registered_at"`
 	LastHeartbeat time.Time `json:"last_heartbeat"`
 	Status        string    `json:"status"`         // "healthy", "unhealthy", "dead"
+
+	token string // Minted at registration; heartbeats carry it and the broker presents it to the child
+	peer  string // Fingerprint of the client certificate it registered with, if any
 }
 
 // ToolInfo represents aggregated tool information from a child
@@ -43,12 +51,40 @@ type ToolInfo struct {
 	ServerName   string   `json:"server_name"`
 }
 
+// Options configures a broker
+type Options struct {
+	Strategy Strategy // How requests are spread across replicas of a tool, default: round-robin
+
+	MaxRetries       int           // Other children to try after a failed attempt, default: 2; negative disables retries
+	RetryBudget      float64       // Retries in flight as a share of all requests in flight, default: 0.2
+	FailureThreshold int           // Consecutive failures that eject a child, default: 5
+	EjectionTime     time.Duration // How long an ejected child gets no requests, default: 30s
+	MaxBodyBytes     int64         // Largest request body buffered for proxying, default: 256MB
+
+	Secret    string      // Shared secret children register with; empty allows any child unless TLS is set
+	AuthToken string      // Token callers must present, default: none
+	TLS       *tls.Config // Listener config for mutual TLS; nil serves plain HTTP
+	ClientTLS *tls.Config // Config for connecting to children over TLS
+}
+
 // Broker manages child server connections and request routing
 type Broker struct {
-	children map[string]*ChildServer // ID -> ChildServer
-	toolMap  map[string]string       // tool name -> child ID
-	mu       sync.RWMutex
-	client   *http.Client
+	children    map[string]*ChildServer   // ID -> ChildServer
+	replicas    map[string][]string       // tool name -> child IDs, in registration order
+	jobMap      map[string]string         // async job ID -> child ID
+	storeMap    map[string]string         // stored file ID -> child ID
+	outputMap   map[string]string         // output file ref -> child ID
+	outstanding map[string]int            // child ID -> proxied requests in flight
+	retrying    int                       // Retry attempts in flight, limited by the retry budget
+	breakers    map[string]*breaker       // child ID -> circuit breaker, for children that have failed
+	turns       map[string]int            // tool name -> round-robin counter
+	weights     map[string]map[string]int // tool name -> child ID -> current weight (weighted routing)
+	strategy    Strategy
+	opts        Options
+	mu          sync.RWMutex
+	client      *http.Client
+	stream      *http.Client // No overall timeout, for tool calls and streaming responses
+	metrics     *brokerMetrics
 
 	// Settings
 	heartbeatTimeout time.Duration
@@ -57,19 +93,55 @@ type Broker struct {
 	wg               sync.WaitGroup
 }
 
-// New creates a new broker
+// New creates a new broker with default options
 func New() *Broker {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions creates a new broker
+func NewWithOptions(opts Options) *Broker {
+	if opts.Strategy == "" {
+		opts.Strategy = RoundRobin
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.RetryBudget <= 0 {
+		opts.RetryBudget = DefaultRetryBudget
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultFailureThreshold
+	}
+	if opts.EjectionTime <= 0 {
+		opts.EjectionTime = DefaultEjectionTime
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = DefaultMaxBodyBytes
+	}
 	b := &Broker{
-		children: make(map[string]*ChildServer),
-		toolMap:  make(map[string]string),
+		children:    make(map[string]*ChildServer),
+		replicas:    make(map[string][]string),
+		jobMap:      make(map[string]string),
+		storeMap:    make(map[string]string),
+		outputMap:   make(map[string]string),
+		outstanding: make(map[string]int),
+		turns:       make(map[string]int),
+		weights:     make(map[string]map[string]int),
+		breakers:    make(map[string]*breaker),
+		strategy:    opts.Strategy,
+		opts:        opts,
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport(opts.ClientTLS),
 		},
+		stream:           &http.Client{Transport: transport(opts.ClientTLS)},
 		heartbeatTimeout: 60 * time.Second,
 		cleanupInterval:  30 * time.Second,
 		stopCh:           make(chan struct{}),
 	}
 
+	b.metrics = newBrokerMetrics(b)
+
 	// Start cleanup goroutine
 	b.wg.Add(1)
 	go b.cleanupLoop()
@@ -89,17 +161,23 @@ func (b *Broker) Register(child *ChildServer) error {
 
 	b.children[child.ID] = child
 
-	// Update tool mapping
-	for _, tool := range child.Tools {
-		b.toolMap[tool] = child.ID
-	}
+	// Update replica sets, dropping tools the child no longer has
+	b.indexChild(child)
 
 	log.Printf("Registered child server: %s (%s) with %d tools", child.Name, child.URL, len(child.Tools))
 	return nil
 }
 
-// Heartbeat updates the last heartbeat time for a child
-func (b *Broker) Heartbeat(childID string) error {
+// ChildReport is the state a child sends with each heartbeat
+type ChildReport struct {
+	Tools    []string `json:"tools,omitempty"`    // Optional: replaces the tool list
+	Running  []string `json:"running,omitempty"`  // Tools running persistently
+	Capacity int      `json:"capacity,omitempty"` // Optional: replaces the capacity
+}
+
+// Heartbeat updates the last heartbeat time for a child, along with the state it
+// reported if report is set
+func (b *Broker) Heartbeat(childID string, report *ChildReport) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -109,7 +187,19 @@ func (b *Broker) Heartbeat(childID string) error {
 	}
 
 	child.LastHeartbeat = time.Now()
-	child.Status = "healthy"
+	if !b.breakers[childID].ejected(child.LastHeartbeat) {
+		child.Status = "healthy"
+	}
+	if report != nil {
+		if len(report.Tools) > 0 {
+			child.Tools = report.Tools
+			b.indexChild(child)
+		}
+		if report.Capacity > 0 {
+			child.Capacity = report.Capacity
+		}
+		child.Running = report.Running
+	}
 	return nil
 }
 
@@ -118,15 +208,19 @@ func (b *Broker) Unregister(childID string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	child, ok := b.children[childID]
-	if !ok {
+	if _, ok := b.children[childID]; !ok {
 		return
 	}
 
-	// Remove tool mappings
-	for _, tool := range child.Tools {
-		if b.toolMap[tool] == childID {
-			delete(b.toolMap, tool)
+	// Remove from replica sets
+	b.unindexChild(childID)
+
+	// Forget jobs and files the child owned
+	for _, index := range []map[string]string{b.jobMap, b.storeMap, b.outputMap} {
+		for key, owner := range index {
+			if owner == childID {
+				delete(index, key)
+			}
 		}
 	}
 
@@ -142,26 +236,17 @@ func (b *Broker) GetChild(childID string) (*ChildServer, bool) {
 	return child, ok
 }
 
-// GetChildForTool returns the child server that has a specific tool
+// GetChildForTool returns a healthy child server that has a specific tool,
+// preferring one with the tool running. It doesn't count as an outstanding request.
 func (b *Broker) GetChildForTool(toolName string) (*ChildServer, bool) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	childID, ok := b.toolMap[toolName]
-	if !ok {
-		return nil, false
-	}
-
-	child, ok := b.children[childID]
-	if !ok {
-		return nil, false
-	}
-
-	if child.Status != "healthy" {
+	candidates := b.candidates(toolName, nil)
+	if len(candidates) == 0 {
 		return nil, false
 	}
-
-	return child, true
+	return candidates[0], true
 }
 
 // ListChildren returns all registered children
@@ -183,6 +268,8 @@ type ServerDescription struct {
 	URL      string   `json:"url"`
 	Status   string   `json:"status"`
 	Tools    []string `json:"tools"`
+	Running  []string `json:"running,omitempty"`
+	Capacity int      `json:"capacity"`
 	AgentDoc string   `json:"agent_doc,omitempty"`
 }
 
@@ -199,14 +286,17 @@ func (b *Broker) DescribeServers() []ServerDescription {
 			URL:      child.URL,
 			Status:   child.Status,
 			Tools:    child.Tools,
+			Running:  child.Running,
+			Capacity: child.weight(),
 			AgentDoc: child.AgentDoc,
 		})
 	}
 	return descriptions
 }
 
-// ListTools aggregates tools from all healthy children
-func (b *Broker) ListTools() ([]ToolInfo, error) {
+// ListTools aggregates tools from all healthy children, asking with the caller's
+// credentials
+func (b *Broker) ListTools(caller *http.Request) ([]ToolInfo, error) {
 	b.mu.RLock()
 	children := make([]*ChildServer, 0, len(b.children))
 	for _, child := range b.children {
@@ -219,8 +309,8 @@ func (b *Broker) ListTools() ([]ToolInfo, error) {
 	var allTools []ToolInfo
 
 	for _, child := range children {
-		tools, err := b.fetchToolsFromChild(child)
-		if err != nil {
+		var tools []ToolInfo
+		if err := b.fetchFromChild(caller, child, "/v1/tools", "", &tools); err != nil {
 			log.Printf("Failed to fetch tools from %s: %v", child.Name, err)
 			continue
 		}
@@ -237,72 +327,95 @@ func (b *Broker) ListTools() ([]ToolInfo, error) {
 	return allTools, nil
 }
 
-// fetchToolsFromChild gets the tool list from a child server
-func (b *Broker) fetchToolsFromChild(child *ChildServer) ([]ToolInfo, error) {
-	resp, err := b.client.Get(child.URL + "/v1/tools")
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("child returned status %d", resp.StatusCode)
-	}
-
-	var tools []ToolInfo
-	if err := json.NewDecoder(resp.Body).Decode(&tools); err != nil {
-		return nil, err
-	}
-
-	return tools, nil
-}
-
-// ProxyRequest forwards a request to the appropriate child server
+// ProxyRequest forwards a request to a child server hosting the tool. If the
+// attempt fails before any response reaches the caller, it's retried on another
+// replica when that's safe (see shouldRetry) and the retry budget allows.
 func (b *Broker) ProxyRequest(w http.ResponseWriter, r *http.Request, toolName string) {
-	child, ok := b.GetChildForTool(toolName)
-	if !ok {
-		http.Error(w, fmt.Sprintf("No server available for tool: %s", toolName), http.StatusServiceUnavailable)
-		return
+	// Buffer the body, multipart uploads included, so it can be replayed
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, b.opts.MaxBodyBytes)); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			writeError(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Build target URL
-	targetURL := child.URL + r.URL.Path
-	if r.URL.RawQuery != "" {
-		targetURL += "?" + r.URL.RawQuery
-	}
+	tried := make(map[string]bool)
+	var child *ChildServer
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		next, release, ok := b.pickChild(toolName, tried)
+		if !ok {
+			if attempt == 0 {
+				b.metrics.unroutable.Inc("tool")
+				writeError(w, fmt.Sprintf("No server available for tool: %s", toolName), http.StatusServiceUnavailable)
+				return
+			}
+			break // Nowhere left to retry; report the last failure
+		}
+		if attempt > 0 {
+			if !b.startRetry() {
+				release()
+				b.metrics.retriesDenied.Inc()
+				break
+			}
+			release = chain(release, b.endRetry)
+			log.Printf("Retrying %s %s on %s after a failure on %s", r.Method, r.URL.Path, next.Name, child.Name)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
 
-	// Read request body
-	var bodyBytes []byte
-	if r.Body != nil {
-		bodyBytes, _ = io.ReadAll(r.Body)
-	}
+		child = next
+		tried[child.ID] = true
 
-	// Create proxied request
-	proxyReq, err := http.NewRequest(r.Method, targetURL, bytes.NewReader(bodyBytes))
-	if err != nil {
-		http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
-		return
-	}
+		// Copy input files held elsewhere to the child first
+		sendBody, stageErr := b.stageFiles(r, child, body)
+		if stageErr != nil {
+			release()
+			writeError(w, fmt.Sprintf("Failed to stage input files: %v", stageErr), http.StatusBadGateway)
+			return
+		}
 
-	// Copy headers
-	for key, values := range r.Header {
-		for _, value := range values {
-			proxyReq.Header.Add(key, value)
+		var sent bool
+		resp, sent, err = b.forward(b.stream, r, child, sendBody)
+		if attempt < b.opts.MaxRetries && shouldRetry(r, resp, err, sent) {
+			b.metrics.retries.Inc(child.Name)
+			release()
+			continue
 		}
+		defer release()
+		break
 	}
-
-	// Add broker headers
-	proxyReq.Header.Set("X-Forwarded-For", r.RemoteAddr)
-	proxyReq.Header.Set("X-Broker-Request", "true")
-
-	// Execute request
-	resp, err := b.client.Do(proxyReq)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to reach child server: %v", err), http.StatusBadGateway)
+		writeError(w, fmt.Sprintf("Failed to reach child server: %v", err), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
 
+	// Remember which child owns a new async job, and the files a result mentions
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusAccepted && r.URL.Query().Get("async") == "true" {
+			var job struct {
+				ID string `json:"id"`
+			}
+			if json.Unmarshal(body, &job) == nil && job.ID != "" {
+				b.recordJob(job.ID, child.ID)
+			}
+		} else if resp.StatusCode == http.StatusOK {
+			b.indexFiles(body, child.ID)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
 	// Copy response headers
 	for key, values := range resp.Header {
 		for _, value := range values {
@@ -312,9 +425,40 @@ func (b *Broker) ProxyRequest(w http.ResponseWriter, r *http.Request, toolName s
 
 	// Copy status and body
 	w.WriteHeader(resp.StatusCode)
+	if flusher, ok := w.(http.Flusher); ok && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		copyFlushing(w, flusher, resp.Body)
+		return
+	}
 	io.Copy(w, resp.Body)
 }
 
+// chain returns a function calling each of fns in order
+func chain(fns ...func()) func() {
+	return func() {
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
+
+// copyFlushing copies a Server-Sent Events body, flushing after every read so
+// events reach the caller as they arrive
+func copyFlushing(w io.Writer, flusher http.Flusher, body io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 // cleanupLoop removes dead children
 func (b *Broker) cleanupLoop() {
 	defer b.wg.Done()
@@ -345,11 +489,9 @@ func (b *Broker) cleanupDeadChildren() {
 				log.Printf("Child server %s marked unhealthy (no heartbeat)", child.Name)
 			} else if now.Sub(child.LastHeartbeat) > b.heartbeatTimeout*3 {
 				// Remove after 3x timeout
-				for _, tool := range child.Tools {
-					if b.toolMap[tool] == id {
-						delete(b.toolMap, tool)
-					}
-				}
+				b.unindexChild(id)
+				delete(b.outstanding, id)
+				delete(b.breakers, id)
 				delete(b.children, id)
 				log.Printf("Child server %s removed (dead)", child.Name)
 			}
@@ -2,6 +2,7 @@ package broker
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -21,10 +22,15 @@ type ChildClient struct {
 	name      string
 	tools     []string
 	agentDoc  string
+	capacity  int
+	running   func() []string // Tools running persistently, sampled for each heartbeat
+	secret    string          // Shared secret for registering
+	token     string          // Minted by the broker at registration
 
 	client   *http.Client
-	interval time.Duration
+	interval time.Duration // Set by the broker at registration
 	stopCh   chan struct{}
+	started  sync.Once // Starts the heartbeat loop on the first registration
 	wg       sync.WaitGroup
 	mu       sync.RWMutex
 }
@@ -63,11 +69,76 @@ func (c *ChildClient) SetAgentDoc(doc string) {
 	c.mu.Unlock()
 }
 
-// Register connects to the broker and starts heartbeat
+// SetCapacity sets this server's share of traffic when the broker routes by
+// weight: a server with capacity 2 gets twice the calls of one with capacity 1
+func (c *ChildClient) SetCapacity(capacity int) {
+	c.mu.Lock()
+	c.capacity = capacity
+	c.mu.Unlock()
+}
+
+// SetSecret sets the shared secret the broker requires for registration
+func (c *ChildClient) SetSecret(secret string) {
+	c.mu.Lock()
+	c.secret = secret
+	c.mu.Unlock()
+}
+
+// SetTLS makes the client connect to the broker over mutual TLS
+func (c *ChildClient) SetTLS(cfg *tls.Config) {
+	c.client.Transport = transport(cfg)
+}
+
+// Token returns the token the broker minted at the last registration. The
+// broker presents it when calling this server on its own authority, so the
+// server should accept it alongside its auth token.
+func (c *ChildClient) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// SetRunningFunc sets how to find the tools running persistently, so the broker
+// can prefer this server for them
+func (c *ChildClient) SetRunningFunc(fn func() []string) {
+	c.mu.Lock()
+	c.running = fn
+	c.mu.Unlock()
+}
+
+// runningTools samples the tools running persistently
+func (c *ChildClient) runningTools() []string {
+	c.mu.RLock()
+	fn := c.running
+	c.mu.RUnlock()
+	if fn == nil {
+		return nil
+	}
+	return fn()
+}
+
+// Register connects to the broker and starts heartbeat. Calling it again
+// re-registers without starting another heartbeat loop.
 func (c *ChildClient) Register() error {
+	if err := c.register(); err != nil {
+		return err
+	}
+	c.started.Do(func() {
+		c.wg.Add(1)
+		go c.heartbeatLoop()
+	})
+	return nil
+}
+
+// register sends the registration and records the token and heartbeat interval
+// the broker answers with
+func (c *ChildClient) register() error {
 	c.mu.RLock()
 	tools := c.tools
 	agentDoc := c.agentDoc
+	capacity := c.capacity
+	secret := c.secret
+	token := c.token
 	c.mu.RUnlock()
 
 	req := map[string]interface{}{
@@ -75,46 +146,62 @@ func (c *ChildClient) Register() error {
 		"url":       c.selfURL,
 		"name":      c.name,
 		"tools":     tools,
+		"running":   c.runningTools(),
+		"capacity":  capacity,
 		"agent_doc": agentDoc,
+		"token":     token, // Proves a re-registration comes from this child
 	}
 
 	body, _ := json.Marshal(req)
-	resp, err := c.client.Post(c.brokerURL+"/v1/broker/register", "application/json", bytes.NewReader(body))
+	resp, err := c.post("/v1/broker/register", secret, body)
 	if err != nil {
 		return fmt.Errorf("failed to register with broker: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		var errResp map[string]string
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
 		json.NewDecoder(resp.Body).Decode(&errResp)
-		return fmt.Errorf("broker registration failed: %s", errResp["error"])
+		return fmt.Errorf("broker registration failed: %s", errResp.Error.Message)
 	}
 
 	var result struct {
 		Status            string `json:"status"`
 		HeartbeatInterval int    `json:"heartbeat_interval"`
+		Token             string `json:"token"`
 	}
 	json.NewDecoder(resp.Body).Decode(&result)
 
+	c.mu.Lock()
+	c.token = result.Token
 	if result.HeartbeatInterval > 0 {
 		c.interval = time.Duration(result.HeartbeatInterval) * time.Second
 	}
+	interval := c.interval
+	c.mu.Unlock()
 
-	log.Printf("Registered with broker %s (heartbeat every %v)", c.brokerURL, c.interval)
-
-	// Start heartbeat goroutine
-	c.wg.Add(1)
-	go c.heartbeatLoop()
-
+	log.Printf("Registered with broker %s (heartbeat every %v)", c.brokerURL, interval)
 	return nil
 }
 
-// heartbeatLoop sends periodic heartbeats to the broker
+// heartbeatInterval returns the interval from the last registration
+func (c *ChildClient) heartbeatInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.interval
+}
+
+// heartbeatLoop sends periodic heartbeats to the broker, re-registering in place
+// when one fails
 func (c *ChildClient) heartbeatLoop() {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.interval)
+	interval := c.heartbeatInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -123,8 +210,11 @@ func (c *ChildClient) heartbeatLoop() {
 			if err := c.sendHeartbeat(); err != nil {
 				log.Printf("Heartbeat failed: %v", err)
 				// Try to re-register
-				if err := c.Register(); err != nil {
+				if err := c.register(); err != nil {
 					log.Printf("Re-registration failed: %v", err)
+				} else if next := c.heartbeatInterval(); next != interval {
+					interval = next
+					ticker.Reset(interval)
 				}
 			}
 		case <-c.stopCh:
@@ -137,15 +227,19 @@ func (c *ChildClient) heartbeatLoop() {
 func (c *ChildClient) sendHeartbeat() error {
 	c.mu.RLock()
 	tools := c.tools
+	capacity := c.capacity
+	token := c.token
 	c.mu.RUnlock()
 
 	req := map[string]interface{}{
-		"id":    c.id,
-		"tools": tools,
+		"id":       c.id,
+		"tools":    tools,
+		"running":  c.runningTools(),
+		"capacity": capacity,
 	}
 
 	body, _ := json.Marshal(req)
-	resp, err := c.client.Post(c.brokerURL+"/v1/broker/heartbeat", "application/json", bytes.NewReader(body))
+	resp, err := c.post("/v1/broker/heartbeat", token, body)
 	if err != nil {
 		return err
 	}
@@ -158,6 +252,19 @@ func (c *ChildClient) sendHeartbeat() error {
 	return nil
 }
 
+// post sends a JSON request to the broker, with token as its credential if set
+func (c *ChildClient) post(path, token string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, c.brokerURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.client.Do(req)
+}
+
 // Stop stops the heartbeat and unregisters
 func (c *ChildClient) Stop() {
 	close(c.stopCh)
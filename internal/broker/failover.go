@@ -0,0 +1,219 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Failover defaults
+const (
+	DefaultMaxRetries       = 2
+	DefaultRetryBudget      = 0.2
+	DefaultFailureThreshold = 5
+	DefaultEjectionTime     = 30 * time.Second
+	DefaultMaxBodyBytes     = 256 << 20 // Matches the largest upload a server accepts
+
+	// minConcurrentRetries lets a quiet broker retry even when the budget's share
+	// of its few requests rounds down to nothing
+	minConcurrentRetries = 3
+)
+
+// breaker is a child's circuit breaker. It opens after FailureThreshold
+// consecutive failures, ejecting the child for EjectionTime. After that the child
+// is tried again, and a single failure ejects it again until a success resets it.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// ejected reports whether the breaker is open
+func (br *breaker) ejected(now time.Time) bool {
+	return br != nil && now.Before(br.openUntil)
+}
+
+// routable reports whether a child can take requests: healthy by heartbeat, or
+// back from an ejection with heartbeats still arriving. Callers hold b.mu.
+func (b *Broker) routable(child *ChildServer, now time.Time) bool {
+	br := b.breakers[child.ID]
+	if br.ejected(now) {
+		return false
+	}
+	if child.Status == "healthy" {
+		return true
+	}
+	return br != nil && now.Sub(child.LastHeartbeat) <= b.heartbeatTimeout
+}
+
+// failed reports whether a proxied request failed because of the child rather
+// than the call: it couldn't be reached, or it answered 503 without a tool error
+// code, as a server that's shutting down does. Anything else, including 500s and
+// coded 503s like queue_full, is the call's own outcome.
+func failed(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusServiceUnavailable && errorCode(resp) == ""
+}
+
+// maxErrorPeek bounds how much of an error response errorCode reads
+const maxErrorPeek = 64 << 10
+
+// errorCode returns the stable error code in a child's JSON error response, or ""
+// if it has none. The body is left intact for the caller.
+func errorCode(resp *http.Response) string {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+	peek, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorPeek))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+
+	var envelope struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(peek, &envelope) != nil {
+		return ""
+	}
+	return envelope.Error.Code
+}
+
+// recordResult feeds a proxied request's outcome to the child's circuit breaker
+func (b *Broker) recordResult(child *ChildServer, resp *http.Response, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed(resp, err) {
+		if br := b.breakers[child.ID]; br != nil {
+			delete(b.breakers, child.ID)
+			if c, ok := b.children[child.ID]; ok && time.Since(c.LastHeartbeat) <= b.heartbeatTimeout {
+				c.Status = "healthy"
+			}
+		}
+		return
+	}
+
+	br := b.breakers[child.ID]
+	if br == nil {
+		br = &breaker{}
+		b.breakers[child.ID] = br
+	}
+	br.failures++
+	if br.failures < b.opts.FailureThreshold {
+		return
+	}
+	br.openUntil = time.Now().Add(b.opts.EjectionTime)
+	if c, ok := b.children[child.ID]; ok {
+		c.Status = "unhealthy"
+	}
+	b.metrics.ejections.Inc(child.Name)
+	log.Printf("Child server %s ejected for %v after %d consecutive failures", child.Name, b.opts.EjectionTime, br.failures)
+}
+
+// idempotent reports whether a request can be sent again after the child may
+// already have acted on it. Calls are POSTs, so they're only retried once
+// they've reached a child if the caller sends an Idempotency-Key.
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+// shouldRetry decides whether a failed attempt can go to another child. A request
+// the child never received, or refused with a 503 without a tool error code (see
+// failed), is always safe to resend; anything else, coded 503s included, only if
+// it's idempotent.
+func shouldRetry(r *http.Request, resp *http.Response, err error, sent bool) bool {
+	if err != nil {
+		if r.Context().Err() != nil {
+			return false // The caller gave up
+		}
+		return !sent || idempotent(r)
+	}
+	switch resp.StatusCode {
+	case http.StatusServiceUnavailable:
+		return errorCode(resp) == "" || idempotent(r)
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(r)
+	}
+	return false
+}
+
+// startRetry takes a slot from the retry budget: retries in flight may be at most
+// RetryBudget of all requests in flight. It returns false when the budget is spent.
+func (b *Broker) startRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	active := 0
+	for _, n := range b.outstanding {
+		active += n
+	}
+	limit := int(b.opts.RetryBudget * float64(active))
+	if limit < minConcurrentRetries {
+		limit = minConcurrentRetries
+	}
+	if b.retrying >= limit {
+		return false
+	}
+	b.retrying++
+	return true
+}
+
+// endRetry returns a slot to the retry budget
+func (b *Broker) endRetry() {
+	b.mu.Lock()
+	b.retrying--
+	b.mu.Unlock()
+}
+
+// forward sends one attempt of a proxied request to a child. body is replayed for
+// each attempt. sent reports whether the request was fully written, so a failed
+// non-idempotent request can tell whether the child might have acted on it.
+func (b *Broker) forward(client *http.Client, r *http.Request, child *ChildServer, body []byte) (resp *http.Response, sent bool, err error) {
+	targetURL := child.URL + r.URL.Path
+	if query := forwardQuery(r.URL); query != "" {
+		targetURL += "?" + query
+	}
+
+	var wrote atomic.Bool
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			wrote.Store(info.Err == nil)
+		},
+	}
+	ctx := httptrace.WithClientTrace(r.Context(), trace)
+
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	for key, values := range r.Header {
+		for _, value := range values {
+			proxyReq.Header.Add(key, value)
+		}
+	}
+	b.authorize(proxyReq, r, child)
+	proxyReq.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	proxyReq.Header.Set("X-Broker-Request", "true")
+
+	started := time.Now()
+	resp, err = client.Do(proxyReq)
+	b.metrics.observeProxy(child, started, resp, err)
+	if r.Context().Err() == nil {
+		b.recordResult(child, resp, err)
+	}
+	return resp, wrote.Load(), err
+}
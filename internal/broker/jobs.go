@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// recordJob remembers which child owns an async job
+func (b *Broker) recordJob(jobID, childID string) {
+	b.remember(b.jobMap, jobID, childID)
+}
+
+// GetChildForJob returns the child that owns a job. Jobs the broker hasn't seen
+// (e.g. after a broker restart) are located by asking each healthy child with
+// the caller's credentials.
+func (b *Broker) GetChildForJob(caller *http.Request, jobID string) (*ChildServer, bool) {
+	return b.locate(caller, b.jobMap, jobID, http.MethodGet, "/v1/jobs/"+jobID)
+}
+
+// healthyChildren returns the children currently marked healthy
+func (b *Broker) healthyChildren() []*ChildServer {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	children := make([]*ChildServer, 0, len(b.children))
+	for _, child := range b.children {
+		if child.Status == "healthy" {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// ListJobs aggregates jobs from all healthy children, newest first. The caller's
+// query is passed through so children apply the same filters.
+func (b *Broker) ListJobs(caller *http.Request) []map[string]interface{} {
+	all := []map[string]interface{}{}
+	for _, child := range b.healthyChildren() {
+		var jobs []map[string]interface{}
+		if err := b.fetchFromChild(caller, child, "/v1/jobs", forwardQuery(caller.URL), &jobs); err != nil {
+			log.Printf("Failed to fetch jobs from %s: %v", child.Name, err)
+			continue
+		}
+		for _, job := range jobs {
+			job["server_id"] = child.ID
+			job["server_name"] = child.Name
+			if id, ok := job["id"].(string); ok {
+				b.recordJob(id, child.ID)
+			}
+		}
+		all = append(all, jobs...)
+	}
+
+	sortNewestFirst(all)
+	return all
+}
+
+// ProxyJobRequest forwards a job status or cancel request to the owning child
+func (b *Broker) ProxyJobRequest(w http.ResponseWriter, r *http.Request, jobID string) {
+	child, ok := b.GetChildForJob(r, jobID)
+	if !ok {
+		b.metrics.unroutable.Inc("job")
+		writeError(w, fmt.Sprintf("job not found: %s", jobID), http.StatusNotFound)
+		return
+	}
+
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, child.URL+r.URL.Path, nil)
+	if err != nil {
+		writeError(w, "Failed to create proxy request", http.StatusInternalServerError)
+		return
+	}
+	for key, values := range r.Header {
+		for _, value := range values {
+			proxyReq.Header.Add(key, value)
+		}
+	}
+	b.authorize(proxyReq, r, child)
+	proxyReq.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	proxyReq.Header.Set("X-Broker-Request", "true")
+
+	started := time.Now()
+	resp, err := b.client.Do(proxyReq)
+	b.metrics.observeProxy(child, started, resp, err)
+	if r.Context().Err() == nil {
+		b.recordResult(child, resp, err)
+	}
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to reach child server: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	// Tag the job with its server, like the aggregated list, and remember the
+	// files its result mentions
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		b.indexFiles(body, child.ID)
+		body = tagServer(body, child)
+	}
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, bytes.NewReader(body))
+}
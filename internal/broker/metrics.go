@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/metrics"
+)
+
+// brokerMetrics instruments proxying to children
+type brokerMetrics struct {
+	registry *metrics.Registry
+
+	requests   *metrics.CounterVec
+	duration   *metrics.HistogramVec
+	errors     *metrics.CounterVec
+	unroutable *metrics.CounterVec
+
+	retries       *metrics.CounterVec
+	retriesDenied *metrics.CounterVec
+	ejections     *metrics.CounterVec
+
+	staged *metrics.CounterVec
+}
+
+func newBrokerMetrics(b *Broker) *brokerMetrics {
+	r := metrics.NewRegistry()
+	m := &brokerMetrics{
+		registry: r,
+		requests: r.Counter("jb_broker_proxy_requests_total",
+			"Requests proxied to children by response status code.", "child", "code"),
+		duration: r.Histogram("jb_broker_proxy_duration_seconds",
+			"Time until a child responded to a proxied request (headers, for streams).", nil, "child"),
+		errors: r.Counter("jb_broker_proxy_errors_total",
+			"Proxied requests that failed to reach the child.", "child"),
+		unroutable: r.Counter("jb_broker_unroutable_requests_total",
+			"Requests for tools, jobs or files no healthy child has.", "kind"),
+		retries: r.Counter("jb_broker_retries_total",
+			"Failed attempts retried on another child, by the child that failed.", "child"),
+		retriesDenied: r.Counter("jb_broker_retries_denied_total",
+			"Retries skipped because the retry budget was spent."),
+		ejections: r.Counter("jb_broker_ejections_total",
+			"Times a child was ejected after consecutive failures.", "child"),
+		staged: r.Counter("jb_broker_staged_files_total",
+			"Files another child holds that were copied to a child for a call.", "child"),
+	}
+
+	r.GaugeFunc("jb_broker_children", "Registered children by status.", []string{"status"},
+		func(emit metrics.Emit) {
+			counts := map[string]int{"healthy": 0, "unhealthy": 0}
+			for _, child := range b.ListChildren() {
+				counts[child.Status]++
+			}
+			for status, n := range counts {
+				emit(float64(n), status)
+			}
+		})
+	r.GaugeFunc("jb_broker_child_tools", "Tools advertised by each child.", []string{"child"},
+		func(emit metrics.Emit) {
+			for _, child := range b.ListChildren() {
+				emit(float64(len(child.Tools)), child.Name)
+			}
+		})
+
+	r.GaugeFunc("jb_broker_child_outstanding", "Proxied requests in flight to each child.", []string{"child"},
+		func(emit metrics.Emit) {
+			for name, n := range b.outstandingByName() {
+				emit(float64(n), name)
+			}
+		})
+
+	return m
+}
+
+// observeProxy records the outcome of one proxied request
+func (m *brokerMetrics) observeProxy(child *ChildServer, started time.Time, resp *http.Response, err error) {
+	if err != nil {
+		m.errors.Inc(child.Name)
+		return
+	}
+	m.requests.Inc(child.Name, strconv.Itoa(resp.StatusCode))
+	m.duration.Observe(time.Since(started).Seconds(), child.Name)
+}
+
+// Metrics returns the broker's proxy metrics.
+func (b *Broker) Metrics() *metrics.Registry {
+	return b.metrics.registry
+}
@@ -0,0 +1,230 @@
+package broker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Strategy picks which replica of a tool serves a request
+type Strategy string
+
+const (
+	RoundRobin       Strategy = "round-robin"       // Take turns
+	LeastOutstanding Strategy = "least-outstanding" // Fewest requests in flight
+	Weighted         Strategy = "weighted"          // Share requests in proportion to reported capacity
+)
+
+// ParseStrategy validates a strategy name. An empty name is round-robin.
+func ParseStrategy(name string) (Strategy, error) {
+	switch s := Strategy(name); s {
+	case "":
+		return RoundRobin, nil
+	case RoundRobin, LeastOutstanding, Weighted:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown routing strategy %q (want round-robin, least-outstanding or weighted)", name)
+}
+
+// indexChild records a child as a replica of each tool it has. Callers hold b.mu.
+func (b *Broker) indexChild(child *ChildServer) {
+	b.unindexChild(child.ID)
+	for _, tool := range child.Tools {
+		b.replicas[tool] = append(b.replicas[tool], child.ID)
+	}
+}
+
+// unindexChild removes a child from every tool's replica set. Callers hold b.mu.
+func (b *Broker) unindexChild(childID string) {
+	for tool, ids := range b.replicas {
+		kept := ids[:0]
+		for _, id := range ids {
+			if id != childID {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == 0 {
+			delete(b.replicas, tool)
+			delete(b.turns, tool)
+			delete(b.weights, tool)
+		} else {
+			b.replicas[tool] = kept
+		}
+	}
+}
+
+// candidates returns the routable replicas of a tool, leaving out the excluded
+// ones. If any of them has the tool running persistently, only those are
+// returned, so calls don't wait on a cold start while a warm instance exists
+// elsewhere. Callers hold b.mu.
+func (b *Broker) candidates(toolName string, exclude map[string]bool) []*ChildServer {
+	now := time.Now()
+	var healthy, warm []*ChildServer
+	for _, id := range b.replicas[toolName] {
+		child, ok := b.children[id]
+		if !ok || exclude[id] || !b.routable(child, now) {
+			continue
+		}
+		healthy = append(healthy, child)
+		if child.isRunning(toolName) {
+			warm = append(warm, child)
+		}
+	}
+	if len(warm) > 0 {
+		return warm
+	}
+	return healthy
+}
+
+// pickChild chooses the replica to send a tool request to, other than the excluded
+// ones, and counts the request as outstanding until release is called
+func (b *Broker) pickChild(toolName string, exclude map[string]bool) (child *ChildServer, release func(), ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	candidates := b.candidates(toolName, exclude)
+	if len(candidates) == 0 {
+		return nil, nil, false
+	}
+
+	switch b.strategy {
+	case LeastOutstanding:
+		child = b.pickLeastOutstanding(toolName, candidates)
+	case Weighted:
+		child = b.pickWeighted(toolName, candidates)
+	default:
+		child = candidates[b.turn(toolName)%len(candidates)]
+	}
+
+	return child, b.acquire(child.ID), true
+}
+
+// acquire counts a request to a child as outstanding and returns the function
+// that ends it. Callers hold b.mu.
+func (b *Broker) acquire(id string) (release func()) {
+	b.outstanding[id]++
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.outstanding[id]--; b.outstanding[id] <= 0 {
+			delete(b.outstanding, id)
+		}
+	}
+}
+
+// outstandingByName returns the requests in flight to each child, by child name
+func (b *Broker) outstandingByName() map[string]int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	counts := make(map[string]int, len(b.children))
+	for id, child := range b.children {
+		counts[child.Name] = b.outstanding[id]
+	}
+	return counts
+}
+
+// turn advances a tool's round-robin counter, returning its previous value
+func (b *Broker) turn(toolName string) int {
+	n := b.turns[toolName]
+	b.turns[toolName] = n + 1
+	return n
+}
+
+// pickLeastOutstanding returns the candidate with the fewest requests in flight.
+// Ties rotate so idle replicas share the load.
+func (b *Broker) pickLeastOutstanding(toolName string, candidates []*ChildServer) *ChildServer {
+	start := b.turn(toolName)
+	var best *ChildServer
+	for i := range candidates {
+		c := candidates[(start+i)%len(candidates)]
+		if best == nil || b.outstanding[c.ID] < b.outstanding[best.ID] {
+			best = c
+		}
+	}
+	return best
+}
+
+// pickWeighted spreads requests in proportion to each candidate's capacity using
+// smooth weighted round-robin, which interleaves replicas instead of sending
+// bursts to the biggest one
+func (b *Broker) pickWeighted(toolName string, candidates []*ChildServer) *ChildServer {
+	current := b.weights[toolName]
+	if current == nil {
+		current = make(map[string]int)
+		b.weights[toolName] = current
+	}
+
+	total := 0
+	var best *ChildServer
+	for _, c := range candidates {
+		weight := c.weight()
+		total += weight
+		current[c.ID] += weight
+		if best == nil || current[c.ID] > current[best.ID] {
+			best = c
+		}
+	}
+	current[best.ID] -= total
+	return best
+}
+
+// isRunning reports whether the child has a tool running persistently
+func (c *ChildServer) isRunning(toolName string) bool {
+	for _, name := range c.Running {
+		if name == toolName {
+			return true
+		}
+	}
+	return false
+}
+
+// weight is the child's capacity for weighted routing; unreported counts as 1
+func (c *ChildServer) weight() int {
+	if c.Capacity <= 0 {
+		return 1
+	}
+	return c.Capacity
+}
+
+// Replica is one child serving a tool, as shown by /v1/broker/describe
+type Replica struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Warm        bool   `json:"warm"` // Running the tool persistently
+	Capacity    int    `json:"capacity"`
+	Outstanding int    `json:"outstanding"` // Proxied requests in flight
+}
+
+// DescribeReplicas returns each tool's replica set
+func (b *Broker) DescribeReplicas() map[string][]Replica {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	replicas := make(map[string][]Replica, len(b.replicas))
+	for tool, ids := range b.replicas {
+		set := make([]Replica, 0, len(ids))
+		for _, id := range ids {
+			child, ok := b.children[id]
+			if !ok {
+				continue
+			}
+			set = append(set, Replica{
+				ID:          child.ID,
+				Name:        child.Name,
+				Status:      child.Status,
+				Warm:        child.isRunning(tool),
+				Capacity:    child.weight(),
+				Outstanding: b.outstanding[id],
+			})
+		}
+		sort.Slice(set, func(i, j int) bool { return set[i].Name < set[j].Name })
+		replicas[tool] = set
+	}
+	return replicas
+}
+
+// Strategy returns how the broker picks between replicas
+func (b *Broker) Strategy() Strategy {
+	return b.strategy
+}
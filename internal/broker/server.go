@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/http"
 	"strings"
+
+	"github.com/calobozan/jb-serve/internal/metrics"
 )
 
 // Server is the HTTP server for the broker
@@ -14,10 +16,15 @@ type Server struct {
 	mux    *http.ServeMux
 }
 
-// NewServer creates a new broker HTTP server
+// NewServer creates a new broker HTTP server with default options
 func NewServer() *Server {
+	return NewServerWithOptions(Options{})
+}
+
+// NewServerWithOptions creates a new broker HTTP server
+func NewServerWithOptions(opts Options) *Server {
 	s := &Server{
-		broker: New(),
+		broker: NewWithOptions(opts),
 		mux:    http.NewServeMux(),
 	}
 	s.setupRoutes()
@@ -34,20 +41,32 @@ func (s *Server) setupRoutes() {
 	// Aggregated endpoints
 	s.mux.HandleFunc("/v1/tools", s.handleTools)
 	s.mux.HandleFunc("/v1/tools/", s.handleToolProxy)
+	s.mux.HandleFunc("/v1/jobs", s.handleJobs)
+	s.mux.HandleFunc("/v1/jobs/", s.handleJobProxy)
 
-	// File store proxy (routes to appropriate child)
-	s.mux.HandleFunc("/v1/store", s.handleStoreProxy)
+	// Files, routed to the child holding them
+	s.mux.HandleFunc("/v1/store", s.handleStore)
 	s.mux.HandleFunc("/v1/store/", s.handleStoreProxy)
+	s.mux.HandleFunc("/v1/files/", s.handleFileProxy)
 
 	// Health
 	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.Handle("/metrics", metrics.Handler(s.broker.Metrics()))
 }
 
-// ListenAndServe starts the broker server
+// ListenAndServe starts the broker server, over mutual TLS if configured
 func (s *Server) ListenAndServe(port int) error {
-	addr := fmt.Sprintf(":%d", port)
-	log.Printf("jb-serve broker listening on %s", addr)
-	return http.ListenAndServe(addr, s.mux)
+	srv := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   s.authMiddleware(s.mux),
+		TLSConfig: s.broker.opts.TLS,
+	}
+	if srv.TLSConfig != nil {
+		log.Printf("jb-serve broker listening on %s (mTLS)", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	log.Printf("jb-serve broker listening on %s", srv.Addr)
+	return srv.ListenAndServe()
 }
 
 // Close shuts down the broker
@@ -75,20 +94,22 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 // handleDescribe returns agent-friendly descriptions of all servers
 func (s *Server) handleDescribe(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	descriptions := s.broker.DescribeServers()
 	s.json(w, map[string]interface{}{
-		"servers": descriptions,
+		"servers":  descriptions,
+		"tools":    s.broker.DescribeReplicas(),
+		"strategy": s.broker.Strategy(),
 	})
 }
 
 // handleRegister handles child server registration
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -97,7 +118,10 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		URL      string   `json:"url"`
 		Name     string   `json:"name"`
 		Tools    []string `json:"tools"`
+		Running  []string `json:"running"`
+		Capacity int      `json:"capacity"`
 		AgentDoc string   `json:"agent_doc"`
+		Token    string   `json:"token"` // The current token, when re-registering a live ID
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -110,12 +134,21 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.broker.mayReplace(r, req.ID, req.Token) {
+		s.jsonError(w, "child "+req.ID+" is already registered; re-registering it needs its current token", http.StatusConflict)
+		return
+	}
+
 	child := &ChildServer{
 		ID:       req.ID,
 		URL:      req.URL,
 		Name:     req.Name,
 		Tools:    req.Tools,
+		Running:  req.Running,
+		Capacity: req.Capacity,
 		AgentDoc: req.AgentDoc,
+		token:    newToken(),
+		peer:     peerIdentity(r),
 	}
 
 	if child.Name == "" {
@@ -131,19 +164,20 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		"status":             "registered",
 		"id":                 child.ID,
 		"heartbeat_interval": int(s.broker.heartbeatTimeout.Seconds() / 2),
+		"token":              child.token, // For heartbeats, and what the broker presents to the child
 	})
 }
 
 // handleHeartbeat handles child heartbeats
 func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req struct {
-		ID    string   `json:"id"`
-		Tools []string `json:"tools,omitempty"` // Optional: update tool list
+		ID string `json:"id"`
+		ChildReport
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -156,16 +190,12 @@ func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If tools provided, re-register (updates tool list)
-	if len(req.Tools) > 0 {
-		child, ok := s.broker.GetChild(req.ID)
-		if ok {
-			child.Tools = req.Tools
-			s.broker.Register(child)
-		}
+	if _, ok := s.broker.GetChild(req.ID); ok && !s.broker.checkToken(req.ID, bearer(r)) {
+		s.jsonError(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	if err := s.broker.Heartbeat(req.ID); err != nil {
+	if err := s.broker.Heartbeat(req.ID, &req.ChildReport); err != nil {
 		s.jsonError(w, err.Error(), http.StatusNotFound)
 		return
 	}
@@ -176,7 +206,7 @@ func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 // handleChildren lists registered children
 func (s *Server) handleChildren(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -187,11 +217,11 @@ func (s *Server) handleChildren(w http.ResponseWriter, r *http.Request) {
 // handleTools aggregates tools from all children
 func (s *Server) handleTools(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	tools, err := s.broker.ListTools()
+	tools, err := s.broker.ListTools(r)
 	if err != nil {
 		s.jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -208,23 +238,91 @@ func (s *Server) handleToolProxy(w http.ResponseWriter, r *http.Request) {
 	toolName := parts[0]
 
 	if toolName == "" {
-		http.Error(w, "Tool name required", http.StatusBadRequest)
+		s.jsonError(w, "Tool name required", http.StatusBadRequest)
 		return
 	}
 
 	s.broker.ProxyRequest(w, r, toolName)
 }
 
-// handleStoreProxy proxies file store requests
-// For now, we need to know which child to route to
-// This could be enhanced to route based on file ID prefix or a central store
+// handleJobs aggregates jobs from all children
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.json(w, s.broker.ListJobs(r))
+}
+
+// handleJobProxy routes /v1/jobs/{id} to the child that owns the job
+func (s *Server) handleJobProxy(w http.ResponseWriter, r *http.Request) {
+	jobID := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+	if jobID == "" {
+		s.handleJobs(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.broker.ProxyJobRequest(w, r, jobID)
+}
+
+// handleStore aggregates stored files from all children, or imports one
+func (s *Server) handleStore(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.json(w, map[string]interface{}{
+			"files": s.broker.ListStore(r),
+		})
+	case http.MethodPost:
+		s.broker.ImportFile(w, r)
+	default:
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStoreProxy routes /v1/store/{id} and /v1/store/{id}/content to the child
+// that holds the file
 func (s *Server) handleStoreProxy(w http.ResponseWriter, r *http.Request) {
-	// For file store, we could:
-	// 1. Route to a specific "primary" child
-	// 2. Route based on file ID (if IDs encode server info)
-	// 3. Broadcast to all and return first match
-	// For now, return not implemented
-	s.jsonError(w, "File store proxy not yet implemented - access child servers directly", http.StatusNotImplemented)
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/store/"), "/", 2)
+	id := parts[0]
+	if id == "" {
+		s.handleStore(w, r)
+		return
+	}
+	content := len(parts) > 1 && parts[1] == "content"
+
+	switch {
+	case content && r.Method == http.MethodGet:
+	case !content && (r.Method == http.MethodGet || r.Method == http.MethodPatch || r.Method == http.MethodDelete):
+	default:
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.broker.ProxyStoreRequest(w, r, id, content)
+}
+
+// handleFileProxy aggregates output files from all children, or routes
+// /v1/files/{ref} to the child that produced the file
+func (s *Server) handleFileProxy(w http.ResponseWriter, r *http.Request) {
+	filename := strings.TrimPrefix(r.URL.Path, "/v1/files/")
+	if filename == "" {
+		if r.Method != http.MethodGet {
+			s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.json(w, s.broker.ListOutputs(r))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodDelete {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.broker.ProxyOutputRequest(w, r, filename)
 }
 
 func (s *Server) json(w http.ResponseWriter, data interface{}) {
@@ -233,7 +331,32 @@ func (s *Server) json(w http.ResponseWriter, data interface{}) {
 }
 
 func (s *Server) jsonError(w http.ResponseWriter, message string, code int) {
+	writeError(w, message, code)
+}
+
+// writeError writes the same {"error": {code, message}} envelope that child servers use
+func writeError(w http.ResponseWriter, message string, status int) {
+	code := "internal_error"
+	switch status {
+	case http.StatusBadRequest:
+		code = "bad_request"
+	case http.StatusUnauthorized:
+		code = "unauthorized"
+	case http.StatusNotFound:
+		code = "not_found"
+	case http.StatusMethodNotAllowed:
+		code = "method_not_allowed"
+	case http.StatusNotImplemented:
+		code = "not_implemented"
+	case http.StatusBadGateway:
+		code = "bad_gateway"
+	case http.StatusServiceUnavailable:
+		code = "unavailable"
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
 }
@@ -0,0 +1,364 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Files live on the child that stored or produced them. The broker learns where
+// from the responses it proxies (imports, listings, tool results) and falls back
+// to asking each healthy child, like jobs.
+
+// remember records which child holds an item in one of the location indexes
+func (b *Broker) remember(index map[string]string, key, childID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	index[key] = childID
+}
+
+// forget drops an item from a location index
+func (b *Broker) forget(index map[string]string, key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(index, key)
+}
+
+// locate returns the child holding an item in index. Items the broker hasn't
+// seen are looked for by sending method probePath to each healthy child, with
+// the caller's credentials; the first to answer 200 is recorded as the owner.
+func (b *Broker) locate(caller *http.Request, index map[string]string, key, method, probePath string) (*ChildServer, bool) {
+	b.mu.RLock()
+	childID, ok := index[key]
+	var child *ChildServer
+	if ok {
+		child, ok = b.children[childID]
+	}
+	b.mu.RUnlock()
+	if ok {
+		return child, true
+	}
+
+	for _, c := range b.healthyChildren() {
+		req, err := http.NewRequestWithContext(caller.Context(), method, c.URL+probePath, nil)
+		if err != nil {
+			return nil, false
+		}
+		b.authorize(req, caller, c)
+		resp, err := b.client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			b.remember(index, key, c.ID)
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// indexFiles records the stored files and output refs mentioned anywhere in a
+// child's JSON response: FileInfo objects (id and sha256) and FileRef objects
+// (ref and a /v1/files/ url)
+func (b *Broker) indexFiles(body []byte, childID string) {
+	var doc interface{}
+	if json.Unmarshal(body, &doc) != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["ref"].(string); ok && ref != "" {
+				if url, _ := v["url"].(string); strings.HasPrefix(url, "/v1/files/") {
+					b.outputMap[ref] = childID
+				}
+			}
+			if id, ok := v["id"].(string); ok && id != "" {
+				if _, ok := v["sha256"].(string); ok {
+					b.storeMap[id] = childID
+				}
+			}
+			for _, item := range v {
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// tagServer adds the serving child to a JSON object in a response body, as the
+// aggregated lists do. Bodies that aren't JSON objects are returned unchanged.
+func tagServer(body []byte, child *ChildServer) []byte {
+	var obj map[string]interface{}
+	if json.Unmarshal(body, &obj) != nil || obj == nil {
+		return body
+	}
+	obj["server_id"] = child.ID
+	obj["server_name"] = child.Name
+	tagged, err := json.Marshal(obj)
+	if err != nil {
+		return body
+	}
+	return tagged
+}
+
+// ListStore aggregates stored files from all healthy children, newest first. The
+// caller's query is passed through so children apply the same filters.
+func (b *Broker) ListStore(caller *http.Request) []map[string]interface{} {
+	all := []map[string]interface{}{}
+	for _, child := range b.healthyChildren() {
+		var list struct {
+			Files []map[string]interface{} `json:"files"`
+		}
+		if err := b.fetchFromChild(caller, child, "/v1/store", forwardQuery(caller.URL), &list); err != nil {
+			log.Printf("Failed to fetch stored files from %s: %v", child.Name, err)
+			continue
+		}
+		for _, file := range list.Files {
+			file["server_id"] = child.ID
+			file["server_name"] = child.Name
+			if id, ok := file["id"].(string); ok {
+				b.remember(b.storeMap, id, child.ID)
+			}
+		}
+		all = append(all, list.Files...)
+	}
+	sortNewestFirst(all)
+	return all
+}
+
+// ListOutputs aggregates output files from all healthy children, newest first
+func (b *Broker) ListOutputs(caller *http.Request) []map[string]interface{} {
+	all := []map[string]interface{}{}
+	for _, child := range b.healthyChildren() {
+		var refs []map[string]interface{}
+		if err := b.fetchFromChild(caller, child, "/v1/files/", "", &refs); err != nil {
+			log.Printf("Failed to fetch output files from %s: %v", child.Name, err)
+			continue
+		}
+		for _, ref := range refs {
+			ref["server_id"] = child.ID
+			ref["server_name"] = child.Name
+			if name, ok := ref["ref"].(string); ok {
+				b.remember(b.outputMap, name, child.ID)
+			}
+		}
+		all = append(all, refs...)
+	}
+	sortNewestFirst(all)
+	return all
+}
+
+// fetchFromChild decodes a JSON listing from a child server, asked for with the
+// caller's credentials
+func (b *Broker) fetchFromChild(caller *http.Request, child *ChildServer, path, rawQuery string, v interface{}) error {
+	url := child.URL + path
+	if rawQuery != "" {
+		url += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(caller.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	b.authorize(req, caller, child)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("child returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// sortNewestFirst orders aggregated items by created_at, newest first
+func sortNewestFirst(items []map[string]interface{}) {
+	sort.SliceStable(items, func(i, j int) bool {
+		ci, _ := items[i]["created_at"].(float64)
+		cj, _ := items[j]["created_at"].(float64)
+		return ci > cj
+	})
+}
+
+// pickStoreChild chooses the child to import a file to: the one named by server
+// (an ID or name), or else the routable child with the fewest requests in
+// flight. The import counts as outstanding until release is called.
+func (b *Broker) pickStoreChild(server string) (child *ChildServer, release func(), ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range b.children {
+		if !b.routable(c, now) {
+			continue
+		}
+		if server != "" {
+			if c.ID == server || c.Name == server {
+				child = c
+				break
+			}
+			continue
+		}
+		// Map order is random, so ties are spread across idle children
+		if child == nil || b.outstanding[c.ID] < b.outstanding[child.ID] {
+			child = c
+		}
+	}
+	if child == nil {
+		return nil, nil, false
+	}
+	return child, b.acquire(child.ID), true
+}
+
+// ImportFile stores an uploaded file on a chosen or least-loaded child. The
+// server query parameter (a child ID or name) picks the child; it matters for
+// imports by path, which refer to that child's filesystem.
+func (b *Broker) ImportFile(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server := r.URL.Query().Get("server")
+	child, release, ok := b.pickStoreChild(server)
+	if !ok {
+		b.metrics.unroutable.Inc("store")
+		if server != "" {
+			writeError(w, fmt.Sprintf("No healthy server named %s", server), http.StatusServiceUnavailable)
+		} else {
+			writeError(w, "No server available for the file store", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	defer release()
+
+	resp, _, err := b.forward(b.stream, r, child, body)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to reach child server: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		b.indexFiles(respBody, child.ID)
+		respBody = tagServer(respBody, child)
+	}
+	writeBuffered(w, resp, respBody)
+}
+
+// ProxyStoreRequest forwards a request for a stored file (/v1/store/{id} and
+// /v1/store/{id}/content) to the child holding it
+func (b *Broker) ProxyStoreRequest(w http.ResponseWriter, r *http.Request, id string, content bool) {
+	child, ok := b.locate(r, b.storeMap, id, http.MethodGet, "/v1/store/"+id)
+	if !ok {
+		b.metrics.unroutable.Inc("store")
+		writeError(w, fmt.Sprintf("file not found: %s", id), http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := b.client
+	if content {
+		client = b.stream
+	}
+	resp, _, err := b.forward(client, r, child, body)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to reach child server: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// Deleted or expired on the child
+		b.forget(b.storeMap, id)
+	case r.Method == http.MethodDelete && resp.StatusCode == http.StatusOK:
+		b.forget(b.storeMap, id)
+	}
+
+	if content {
+		copyResponse(w, resp)
+		return
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK && r.Method != http.MethodDelete {
+		respBody = tagServer(respBody, child)
+	}
+	writeBuffered(w, resp, respBody)
+}
+
+// ProxyOutputRequest forwards a download or delete of an output file
+// (/v1/files/{ref}.{ext}) to the child that produced it
+func (b *Broker) ProxyOutputRequest(w http.ResponseWriter, r *http.Request, filename string) {
+	ref := strings.TrimSuffix(filename, filepath.Ext(filename))
+	child, ok := b.locate(r, b.outputMap, ref, http.MethodHead, "/v1/files/"+filename)
+	if !ok {
+		b.metrics.unroutable.Inc("file")
+		writeError(w, fmt.Sprintf("file not found: %s", filename), http.StatusNotFound)
+		return
+	}
+
+	resp, _, err := b.forward(b.stream, r, child, nil)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to reach child server: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || (r.Method == http.MethodDelete && resp.StatusCode == http.StatusOK) {
+		b.forget(b.outputMap, ref)
+	}
+	copyResponse(w, resp)
+}
+
+// copyResponse streams a child's response to the caller unchanged
+func copyResponse(w http.ResponseWriter, resp *http.Response) {
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// writeBuffered writes a child's response with a body the broker read, and may
+// have rewritten, so the child's Content-Length isn't copied
+func writeBuffered(w http.ResponseWriter, resp *http.Response, body []byte) {
+	for key, values := range resp.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, bytes.NewReader(body))
+}
@@ -0,0 +1,183 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StagedOutputTTL is how long an output file copied into a child's store for a
+// call is kept there
+const StagedOutputTTL = 24 * time.Hour
+
+// stageFiles makes the files a call's params refer to available on the child
+// about to run it, and returns the body to send. Store IDs held by another child
+// are copied to it under the same ID. Output file URLs (/v1/files/{ref}, with or
+// without a host) are imported into its store and replaced by the local path,
+// which is what tools take for file inputs. Only JSON bodies are looked at.
+func (b *Broker) stageFiles(r *http.Request, child *ChildServer, body []byte) ([]byte, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return body, nil
+	}
+	var params interface{}
+	if len(body) == 0 || json.Unmarshal(body, &params) != nil {
+		return body, nil
+	}
+
+	staged := make(map[string]string) // param value -> value to send
+	var stage func(v interface{}) (interface{}, bool, error)
+	stage = func(v interface{}) (interface{}, bool, error) {
+		switch v := v.(type) {
+		case string:
+			if to, ok := staged[v]; ok {
+				return to, to != v, nil
+			}
+			to, err := b.stageValue(r, child, v)
+			if err != nil {
+				return nil, false, err
+			}
+			staged[v] = to
+			return to, to != v, nil
+		case map[string]interface{}:
+			changed := false
+			for key, item := range v {
+				to, ok, err := stage(item)
+				if err != nil {
+					return nil, false, err
+				}
+				if ok {
+					v[key], changed = to, true
+				}
+			}
+			return v, changed, nil
+		case []interface{}:
+			changed := false
+			for i, item := range v {
+				to, ok, err := stage(item)
+				if err != nil {
+					return nil, false, err
+				}
+				if ok {
+					v[i], changed = to, true
+				}
+			}
+			return v, changed, nil
+		}
+		return v, false, nil
+	}
+
+	params, changed, err := stage(params)
+	if err != nil || !changed {
+		return body, err
+	}
+	return json.Marshal(params)
+}
+
+// stageValue stages the file a single param value refers to, if any, returning
+// the value to send in its place
+func (b *Broker) stageValue(r *http.Request, child *ChildServer, value string) (string, error) {
+	if filename, ok := outputFile(value); ok {
+		ref := strings.TrimSuffix(filename, filepath.Ext(filename))
+		owner, ok := b.locate(r, b.outputMap, ref, http.MethodHead, "/v1/files/"+filename)
+		if !ok {
+			return value, nil // Not ours; the tool sees it as given
+		}
+		info, err := b.pull(r, child, owner, map[string]interface{}{
+			"ref": filename,
+			"ttl": int64(StagedOutputTTL / time.Second),
+		})
+		if err != nil {
+			return "", fmt.Errorf("copying %s from %s to %s: %w", filename, owner.Name, child.Name, err)
+		}
+		return info.Path, nil
+	}
+
+	// Store IDs are UUIDs; anything else can't be one
+	if len(value) != 36 || uuid.Validate(value) != nil {
+		return value, nil
+	}
+	owner, ok := b.locate(r, b.storeMap, value, http.MethodGet, "/v1/store/"+value)
+	if !ok || owner.ID == child.ID {
+		return value, nil
+	}
+	if _, err := b.pull(r, child, owner, map[string]interface{}{"id": value}); err != nil {
+		return "", fmt.Errorf("copying stored file %s from %s to %s: %w", value, owner.Name, child.Name, err)
+	}
+	return value, nil
+}
+
+// outputFile returns the file name in an output file URL: /v1/files/{name}, or
+// the same path on an http(s) URL
+func outputFile(value string) (string, bool) {
+	if !strings.Contains(value, "/v1/files/") {
+		return "", false
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Host != "" && u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	name := strings.TrimPrefix(u.Path, "/v1/files/")
+	if name == u.Path || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+// stagedFile is the part of a child's pull response the broker needs
+type stagedFile struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+// pull asks a child to copy a file from its owner (POST /v1/store/pull), with
+// the caller's credentials for both
+func (b *Broker) pull(r *http.Request, child, owner *ChildServer, req map[string]interface{}) (*stagedFile, error) {
+	req["source"] = owner.URL
+	if auth := b.credentials(r, owner); auth != "" {
+		req["auth"] = auth
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pullReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, child.URL+"/v1/store/pull", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	b.authorize(pullReq, r, child)
+	pullReq.Header.Set("Content-Type", "application/json")
+	pullReq.Header.Set("X-Broker-Request", "true")
+
+	resp, err := b.stream.Do(pullReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error.Message != "" {
+			return nil, fmt.Errorf("%s", e.Error.Message)
+		}
+		return nil, fmt.Errorf("child returned status %d", resp.StatusCode)
+	}
+
+	var file stagedFile
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, err
+	}
+	b.metrics.staged.Inc(child.Name)
+	return &file, nil
+}
@@ -34,15 +34,31 @@ func NewFromPort(port int) *Client {
 // ToolInfo represents tool/service information from the API.
 // Note: Methods field differs between list ([]string) and info (map) endpoints.
 type ToolInfo struct {
-	Name         string      `json:"name"`
-	Type         string      `json:"type"` // "tool" or "builtin"
-	Version      string      `json:"version"`
-	Description  string      `json:"description"`
-	Capabilities []string    `json:"capabilities"`
-	Mode         string      `json:"mode"`
-	Status       string      `json:"status"`
-	HealthStatus string      `json:"health_status,omitempty"`
-	Methods      interface{} `json:"methods,omitempty"` // []string for list, map for info
+	Name         string            `json:"name"`
+	Type         string            `json:"type"` // "tool" or "builtin"
+	Version      string            `json:"version"`
+	Description  string            `json:"description"`
+	Capabilities []string          `json:"capabilities"`
+	Mode         string            `json:"mode"`
+	Status       string            `json:"status"`
+	HealthStatus string            `json:"health_status,omitempty"`
+	RestartCount int               `json:"restart_count,omitempty"`
+	LastExit     string            `json:"last_exit_reason,omitempty"`
+	Source       string            `json:"source,omitempty"`
+	Commit       string            `json:"commit,omitempty"`
+	NeedsRebuild bool              `json:"needs_rebuild,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`     // Secret values are redacted
+	Sandbox      *SandboxInfo      `json:"sandbox,omitempty"` // Nil if the tool runs unsandboxed
+	Methods      interface{}       `json:"methods,omitempty"` // []string for list, map for info
+}
+
+// SandboxInfo is the sandbox policy a tool's processes run under.
+type SandboxInfo struct {
+	Network    bool     `json:"network"`
+	Paths      []string `json:"paths,omitempty"`
+	MemoryMB   int      `json:"memory_mb,omitempty"`
+	CPUSeconds int      `json:"cpu_seconds,omitempty"`
+	OpenFiles  int      `json:"open_files,omitempty"`
 }
 
 // MethodNames returns method names as a slice (works for both list and info responses).
@@ -69,7 +85,6 @@ func (t *ToolInfo) MethodNames() []string {
 type StatusResponse struct {
 	Status string `json:"status"`
 	Tool   string `json:"tool"`
-	Error  string `json:"error,omitempty"`
 }
 
 // Ping checks if the server is reachable.
@@ -95,8 +110,7 @@ func (c *Client) List() ([]ToolInfo, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server error: %s", string(body))
+		return nil, decodeError(resp)
 	}
 
 	var tools []ToolInfo
@@ -114,12 +128,8 @@ func (c *Client) Info(toolName string) (*ToolInfo, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("tool not found: %s", toolName)
-	}
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server error: %s", string(body))
+		return nil, decodeError(resp)
 	}
 
 	var info ToolInfo
@@ -137,14 +147,14 @@ func (c *Client) Start(toolName string) (*StatusResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
 	var status StatusResponse
 	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-	
-	if status.Error != "" {
-		return nil, fmt.Errorf("failed to start tool: %s", status.Error)
-	}
 	return &status, nil
 }
 
@@ -156,19 +166,57 @@ func (c *Client) Stop(toolName string) (*StatusResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
 	var status StatusResponse
 	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-	
-	if status.Error != "" {
-		return nil, fmt.Errorf("failed to stop tool: %s", status.Error)
-	}
 	return &status, nil
 }
 
+// ReloadResult is what a tool directory rescan changed.
+type ReloadResult struct {
+	Added     []string          `json:"added"`
+	Updated   []string          `json:"updated"`
+	Removed   []string          `json:"removed"`
+	Restarted []string          `json:"restarted"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// Reload asks the server to rescan its tools directory.
+func (c *Client) Reload() (*ReloadResult, error) {
+	resp, err := c.HTTPClient.Post(c.BaseURL+"/v1/admin/reload", "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
+	var result ReloadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
+
+// CallOptions configures a single method call.
+type CallOptions struct {
+	Timeout time.Duration // Server-side call timeout (0 = manifest default)
+}
+
 // Call invokes a method on a tool.
 func (c *Client) Call(toolName, methodName string, params map[string]interface{}) (map[string]interface{}, error) {
+	return c.CallWithOptions(toolName, methodName, params, CallOptions{})
+}
+
+// CallWithOptions invokes a method on a tool with per-call options.
+func (c *Client) CallWithOptions(toolName, methodName string, params map[string]interface{}, opts CallOptions) (map[string]interface{}, error) {
 	var body io.Reader
 	if params != nil && len(params) > 0 {
 		data, err := json.Marshal(params)
@@ -179,6 +227,9 @@ func (c *Client) Call(toolName, methodName string, params map[string]interface{}
 	}
 
 	url := fmt.Sprintf("%s/v1/tools/%s/%s", c.BaseURL, toolName, methodName)
+	if opts.Timeout > 0 {
+		url += fmt.Sprintf("?timeout=%g", opts.Timeout.Seconds())
+	}
 	resp, err := c.HTTPClient.Post(url, "application/json", body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call method: %w", err)
@@ -186,8 +237,7 @@ func (c *Client) Call(toolName, methodName string, params map[string]interface{}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("method call failed: %s", string(body))
+		return nil, decodeError(resp)
 	}
 
 	var result map[string]interface{}
@@ -206,8 +256,7 @@ func (c *Client) FilesList() ([]map[string]interface{}, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server error: %s", string(body))
+		return nil, decodeError(resp)
 	}
 
 	var result struct {
@@ -236,14 +285,14 @@ func (c *Client) FilesImport(path, name string, ttl int64) (map[string]interface
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-
-	if errMsg, ok := result["error"].(string); ok {
-		return nil, fmt.Errorf("import failed: %s", errMsg)
-	}
 	return result, nil
 }
 
@@ -255,14 +304,14 @@ func (c *Client) FilesInfo(id string) (map[string]interface{}, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-
-	if errMsg, ok := result["error"].(string); ok {
-		return nil, fmt.Errorf("file not found: %s", errMsg)
-	}
 	return result, nil
 }
 
@@ -275,13 +324,8 @@ func (c *Client) FilesDelete(id string) error {
 	}
 	defer resp.Body.Close()
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	if errMsg, ok := result["error"].(string); ok {
-		return fmt.Errorf("delete failed: %s", errMsg)
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp)
 	}
 	return nil
 }
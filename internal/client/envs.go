@@ -0,0 +1,69 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EnvInfo describes a Python environment on the server.
+type EnvInfo struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Python    string    `json:"python,omitempty"`
+	SizeBytes int64     `json:"size_bytes"`
+	Tools     []string  `json:"tools"`
+	Shared    bool      `json:"shared"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// PruneResult lists the environments a prune removed, or would remove.
+type PruneResult struct {
+	Removed    []EnvInfo `json:"removed"`
+	FreedBytes int64     `json:"freed_bytes"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+}
+
+// Envs lists the server's environments and the tools using each one.
+func (c *Client) Envs() ([]EnvInfo, error) {
+	resp, err := c.HTTPClient.Get(c.BaseURL + "/v1/envs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
+	var envs []EnvInfo
+	if err := json.NewDecoder(resp.Body).Decode(&envs); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return envs, nil
+}
+
+// PruneEnvs removes environments no installed tool uses. With dryRun the server
+// only reports what it would remove.
+func (c *Client) PruneEnvs(dryRun bool) (*PruneResult, error) {
+	u := c.BaseURL + "/v1/envs/prune"
+	if dryRun {
+		u += "?dry_run=true"
+	}
+	resp, err := c.HTTPClient.Post(u, "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune environments: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
+	var result PruneResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors for use with errors.Is on an *APIError.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrConflict      = errors.New("conflict")
+	ErrValidation    = errors.New("validation failed")
+	ErrToolException = errors.New("tool exception")
+	ErrTimeout       = errors.New("timeout")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrUnavailable   = errors.New("unavailable")
+	ErrQueueFull     = errors.New("queue full")
+)
+
+// FieldError describes a single parameter that failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// APIError is an error returned by the jb-serve API.
+// Transport failures (connection refused, etc.) are never APIErrors.
+type APIError struct {
+	StatusCode int          `json:"-"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	Type       string       `json:"type,omitempty"`      // Python exception type
+	Traceback  string       `json:"traceback,omitempty"` // Python traceback
+	Tool       string       `json:"tool,omitempty"`
+	Method     string       `json:"method,omitempty"`
+	Fields     []FieldError `json:"fields,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if e.Type != "" {
+		msg = fmt.Sprintf("%s: %s", e.Type, e.Message)
+	}
+	if e.Code != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Code)
+	}
+	return msg
+}
+
+// Is matches the sentinel errors above based on the error code.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code == "tool_not_found" || e.Code == "method_not_found" || e.Code == "not_found"
+	case ErrConflict:
+		return e.Code == "already_running" || e.Code == "not_running" || e.Code == "job_finished" ||
+			e.Code == "already_installed" || e.Code == "conflict"
+	case ErrValidation:
+		return e.Code == "validation_failed"
+	case ErrToolException:
+		return e.Code == "tool_exception"
+	case ErrTimeout:
+		return e.Code == "timeout"
+	case ErrUnauthorized:
+		return e.Code == "unauthorized"
+	case ErrQueueFull:
+		return e.Code == "queue_full"
+	case ErrUnavailable:
+		return e.Code == "unavailable" || e.Code == "insufficient_resources"
+	}
+	return false
+}
+
+// decodeError reads an error envelope from a non-2xx response.
+// Falls back to the raw body for servers that reply in plain text.
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && len(envelope.Error) > 0 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(envelope.Error, apiErr); err == nil {
+			return apiErr
+		}
+		// Legacy {"error": "message"} form
+		var msg string
+		if err := json.Unmarshal(envelope.Error, &msg); err == nil {
+			return &APIError{StatusCode: resp.StatusCode, Code: codeForStatus(resp.StatusCode), Message: msg}
+		}
+	}
+
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Code:       codeForStatus(resp.StatusCode),
+		Message:    strings.TrimSpace(string(body)),
+	}
+}
+
+// codeForStatus guesses an error code for responses without an envelope.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusUnprocessableEntity:
+		return "validation_failed"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusBadGateway:
+		return "bad_gateway"
+	case http.StatusGatewayTimeout:
+		return "timeout"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	case http.StatusTooManyRequests:
+		return "queue_full"
+	default:
+		return "internal_error"
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// CallRecord is one entry in the server's call history.
+type CallRecord struct {
+	ID           int64    `json:"id"`
+	Tool         string   `json:"tool"`
+	Method       string   `json:"method"`
+	Mode         string   `json:"mode"` // "sync", "stream" or "async"
+	ParamsDigest string   `json:"params_digest"`
+	Caller       string   `json:"caller,omitempty"`
+	Status       string   `json:"status"` // "ok" or "error"
+	ErrorCode    string   `json:"error_code,omitempty"`
+	ErrorType    string   `json:"error_type,omitempty"`
+	OutputFiles  []string `json:"output_files,omitempty"`
+	StartedAt    int64    `json:"started_at"` // Unix milliseconds
+	DurationMS   int64    `json:"duration_ms"`
+}
+
+// HistoryOptions filters the call history. Since and Until accept an RFC 3339
+// timestamp, Unix seconds, or a duration ago such as "1h".
+type HistoryOptions struct {
+	Tool   string
+	Method string
+	Status string
+	Since  string
+	Until  string
+	Limit  int
+}
+
+// History returns recorded calls, newest first.
+func (c *Client) History(opts HistoryOptions) ([]CallRecord, error) {
+	q := url.Values{}
+	for key, value := range map[string]string{
+		"tool":   opts.Tool,
+		"method": opts.Method,
+		"status": opts.Status,
+		"since":  opts.Since,
+		"until":  opts.Until,
+	} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	endpoint := c.BaseURL + "/v1/calls"
+	if len(q) > 0 {
+		endpoint += "?" + q.Encode()
+	}
+
+	resp, err := c.HTTPClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
+	var calls []CallRecord
+	if err := json.NewDecoder(resp.Body).Decode(&calls); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return calls, nil
+}
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// LockedTool is a tool's provenance from the server's lockfile.
+type LockedTool struct {
+	Source      string    `json:"source" yaml:"source"`
+	Ref         string    `json:"ref,omitempty" yaml:"ref,omitempty"`
+	Commit      string    `json:"commit,omitempty" yaml:"commit,omitempty"`
+	Version     string    `json:"version" yaml:"version"`
+	Python      string    `json:"python,omitempty" yaml:"python,omitempty"`
+	InstalledAt time.Time `json:"installed_at" yaml:"installed_at"`
+	Pip         []string  `json:"pip,omitempty" yaml:"pip,omitempty"`
+	Conda       []string  `json:"conda,omitempty" yaml:"conda,omitempty"`
+}
+
+// Lockfile maps tool names to their provenance. It reads and writes the same
+// YAML as ~/.jb-serve/jb-serve.lock.
+type Lockfile struct {
+	Tools map[string]*LockedTool `json:"tools" yaml:"tools"`
+}
+
+// Install installs a tool on the server from a git URL or a path on the server's
+// filesystem. onLog, if set, receives each line of install output as it happens.
+func (c *Client) Install(source string, onLog func(line string)) (*ToolInfo, error) {
+	return c.install(map[string]interface{}{"source": source}, onLog)
+}
+
+// InstallLocked installs the exact commit and package versions of a lockfile entry.
+func (c *Client) InstallLocked(entry *LockedTool, onLog func(line string)) (*ToolInfo, error) {
+	return c.install(map[string]interface{}{"lock": entry}, onLog)
+}
+
+func (c *Client) install(request map[string]interface{}, onLog func(line string)) (*ToolInfo, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/v1/tools?stream=true", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.installStream(req, "install tool", onLog)
+}
+
+// Upgrade pulls and reinstalls the latest version of a tool, restarting it if it
+// was running. onLog, if set, receives each line of output.
+func (c *Client) Upgrade(toolName string, onLog func(line string)) (*ToolInfo, error) {
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/v1/tools/"+toolName+"/upgrade?stream=true", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	return c.installStream(req, "upgrade tool", onLog)
+}
+
+// Rebuild recreates a tool's environment from its current manifest and reruns its
+// setup. onLog, if set, receives each line of output.
+func (c *Client) Rebuild(toolName string, onLog func(line string)) (*ToolInfo, error) {
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/v1/tools/"+toolName+"/rebuild?stream=true", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	return c.installStream(req, "rebuild environment", onLog)
+}
+
+// Lock returns the provenance of every tool installed on the server.
+func (c *Client) Lock() (*Lockfile, error) {
+	resp, err := c.HTTPClient.Get(c.BaseURL + "/v1/lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lockfile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
+	var lock Lockfile
+	if err := json.NewDecoder(resp.Body).Decode(&lock); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &lock, nil
+}
+
+// Uninstall stops and removes a tool. removeEnv also deletes its Python environment.
+func (c *Client) Uninstall(toolName string, removeEnv bool) (*StatusResponse, error) {
+	u := c.BaseURL + "/v1/tools/" + toolName
+	if removeEnv {
+		u += "?" + url.Values{"remove_env": {"true"}}.Encode()
+	}
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to uninstall tool: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
+	var status StatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &status, nil
+}
+
+// BundleResult describes a bundle written by the server.
+type BundleResult struct {
+	Tool      string `json:"tool"`
+	Version   string `json:"version"`
+	Path      string `json:"path"` // On the server's filesystem
+	SizeBytes int64  `json:"size_bytes"`
+	Wheels    int    `json:"wheels"`
+}
+
+// Bundle writes a tool and wheels for its pip packages to a tarball in the server's
+// bundles directory. Fetch it with DownloadBundle; install accepts it as a source
+// and needs no network for it. onLog, if set, receives each line of output.
+func (c *Client) Bundle(toolName string, onLog func(line string)) (*BundleResult, error) {
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/v1/tools/"+toolName+"/bundle?stream=true", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	var result BundleResult
+	if err := c.logStream(req, "bundle tool", onLog, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DownloadBundle copies the tool's last bundle from the server to w and returns
+// the number of bytes written.
+func (c *Client) DownloadBundle(toolName string, w io.Writer) (int64, error) {
+	// Bundles with wheels can be large, so don't cut the copy short
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+
+	resp, err := httpClient.Get(c.BaseURL + "/v1/tools/" + toolName + "/bundle")
+	if err != nil {
+		return 0, fmt.Errorf("failed to download bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, decodeError(resp)
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("failed to download bundle: %w", err)
+	}
+	return n, nil
+}
+
+// installStream relays log events from an install or upgrade and decodes the
+// resulting tool info
+func (c *Client) installStream(req *http.Request, action string, onLog func(line string)) (*ToolInfo, error) {
+	var info ToolInfo
+	if err := c.logStream(req, action, onLog, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// logStream relays log events from a long server operation and decodes its
+// result into v
+func (c *Client) logStream(req *http.Request, action string, onLog func(line string), v interface{}) error {
+	stream, err := c.openStream(req, action)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	for stream.Next() {
+		ev := stream.Event()
+		switch ev.Type {
+		case "log":
+			if onLog != nil {
+				onLog(fmt.Sprint(ev.Log))
+			}
+		case "result":
+			data, _ := json.Marshal(ev.Result)
+			if err := json.Unmarshal(data, v); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+			return nil
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("failed to %s: stream ended without a result", action)
+}
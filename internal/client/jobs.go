@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Job is a background call started with CallAsync.
+type Job struct {
+	ID         string      `json:"id"`
+	Tool       string      `json:"tool"`
+	Method     string      `json:"method"`
+	Status     string      `json:"status"` // "queued", "running", "succeeded", "failed", "canceled"
+	Progress   interface{} `json:"progress,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      *APIError   `json:"error,omitempty"`
+	CreatedAt  int64       `json:"created_at"`
+	StartedAt  int64       `json:"started_at,omitempty"`
+	FinishedAt int64       `json:"finished_at,omitempty"`
+	ServerID   string      `json:"server_id,omitempty"` // Set by a broker
+}
+
+// Finished reports whether the job has reached a final state.
+func (j *Job) Finished() bool {
+	return j.Status == "succeeded" || j.Status == "failed" || j.Status == "canceled"
+}
+
+// CallAsync starts a method call in the background and returns its job.
+func (c *Client) CallAsync(toolName, methodName string, params map[string]interface{}, opts CallOptions) (*Job, error) {
+	var body io.Reader
+	if len(params) > 0 {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode params: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	url := fmt.Sprintf("%s/v1/tools/%s/%s?async=true", c.BaseURL, toolName, methodName)
+	if opts.Timeout > 0 {
+		url += fmt.Sprintf("&timeout=%g", opts.Timeout.Seconds())
+	}
+	resp, err := c.HTTPClient.Post(url, "application/json", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call method: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, decodeError(resp)
+	}
+	return decodeJob(resp)
+}
+
+// Jobs lists recent jobs, optionally filtered by status and tool.
+func (c *Client) Jobs(status, toolName string) ([]Job, error) {
+	q := url.Values{}
+	if status != "" {
+		q.Set("status", status)
+	}
+	if toolName != "" {
+		q.Set("tool", toolName)
+	}
+	endpoint := c.BaseURL + "/v1/jobs"
+	if len(q) > 0 {
+		endpoint += "?" + q.Encode()
+	}
+
+	resp, err := c.HTTPClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+
+	var list []Job
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return list, nil
+}
+
+// Job returns a job's status, progress and result.
+func (c *Client) Job(id string) (*Job, error) {
+	resp, err := c.HTTPClient.Get(c.BaseURL + "/v1/jobs/" + id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+	return decodeJob(resp)
+}
+
+// CancelJob cancels a queued or running job.
+func (c *Client) CancelJob(id string) (*Job, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.BaseURL+"/v1/jobs/"+id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+	return decodeJob(resp)
+}
+
+// WaitJob polls a job until it finishes. onProgress, if set, is called with each
+// new progress value. A timeout of 0 waits forever.
+func (c *Client) WaitJob(id string, interval, timeout time.Duration, onProgress func(interface{})) (*Job, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	var last []byte
+	for {
+		job, err := c.Job(id)
+		if err != nil {
+			return nil, err
+		}
+		if onProgress != nil && job.Progress != nil {
+			if p, _ := json.Marshal(job.Progress); !bytes.Equal(p, last) {
+				last = p
+				onProgress(job.Progress)
+			}
+		}
+		if job.Finished() {
+			return job, nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return job, fmt.Errorf("job %s still %s after %v", id, job.Status, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func decodeJob(resp *http.Response) (*Job, error) {
+	var job Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &job, nil
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StreamEvent is one event from a streaming call.
+type StreamEvent struct {
+	Type     string      // "chunk", "progress", "log" or "result"
+	Chunk    interface{} // Set for "chunk" events
+	Progress interface{} // Set for "progress" events
+	Log      interface{} // Set for "log" events
+	Result   interface{} // Set for the final "result" event
+}
+
+// Stream iterates over the events of a streaming call:
+//
+//	stream, err := c.CallStream("llm", "generate", params, client.CallOptions{})
+//	if err != nil { ... }
+//	defer stream.Close()
+//	for stream.Next() {
+//		ev := stream.Event()
+//		...
+//	}
+//	if err := stream.Err(); err != nil { ... }
+type Stream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	event   StreamEvent
+	err     error
+	done    bool
+}
+
+// CallStream invokes a method and streams its output as Server-Sent Events.
+// The final event has Type "result"; a tool error ends the stream with Err set.
+func (c *Client) CallStream(toolName, methodName string, params map[string]interface{}, opts CallOptions) (*Stream, error) {
+	var body io.Reader
+	if len(params) > 0 {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode params: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	url := fmt.Sprintf("%s/v1/tools/%s/%s?stream=true", c.BaseURL, toolName, methodName)
+	if opts.Timeout > 0 {
+		url += fmt.Sprintf("&timeout=%g", opts.Timeout.Seconds())
+	}
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.openStream(req, "call method")
+}
+
+// openStream sends a request that answers with Server-Sent Events. Transport
+// failures are reported as "failed to <action>"; error responses as *APIError.
+func (c *Client) openStream(req *http.Request, action string) (*Stream, error) {
+	req.Header.Set("Accept", "text/event-stream")
+
+	// Streams can outlive the client's overall request timeout
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &Stream{body: resp.Body, scanner: scanner}, nil
+}
+
+// Next advances to the next event. It returns false when the stream ends or fails.
+func (s *Stream) Next() bool {
+	if s.done || s.err != nil {
+		return false
+	}
+
+	var eventType string
+	var data []byte
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		switch {
+		case line == "":
+			// Blank line dispatches the event
+			if eventType == "" && data == nil {
+				continue
+			}
+			return s.dispatch(eventType, data)
+		case strings.HasPrefix(line, ":"):
+			// Comment / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		s.err = fmt.Errorf("stream interrupted: %w", err)
+	} else {
+		s.err = fmt.Errorf("stream ended without a result")
+	}
+	return false
+}
+
+// dispatch decodes one event. Error events end the stream with Err set.
+func (s *Stream) dispatch(eventType string, data []byte) bool {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(data, &payload); err != nil {
+		s.err = fmt.Errorf("invalid %s event: %w", eventType, err)
+		return false
+	}
+
+	if eventType == "error" {
+		apiErr := &APIError{StatusCode: http.StatusOK}
+		if err := json.Unmarshal(payload["error"], apiErr); err != nil {
+			s.err = fmt.Errorf("invalid error event: %w", err)
+		} else {
+			s.err = apiErr
+		}
+		return false
+	}
+
+	var value interface{}
+	if raw, ok := payload[eventType]; ok {
+		json.Unmarshal(raw, &value)
+	}
+
+	s.event = StreamEvent{Type: eventType}
+	switch eventType {
+	case "chunk":
+		s.event.Chunk = value
+	case "progress":
+		s.event.Progress = value
+	case "log":
+		s.event.Log = value
+	case "result":
+		s.event.Result = value
+		s.done = true
+	}
+	return true
+}
+
+// Event returns the current event.
+func (s *Stream) Event() StreamEvent {
+	return s.event
+}
+
+// Err returns the error that ended the stream, if any.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close releases the connection. Closing before the result cancels the call.
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
@@ -14,6 +14,66 @@ type Config struct {
 	RunDir    string `yaml:"run_dir"`    // Runtime state (pids, sockets)
 	APIPort   int    `yaml:"api_port"`   // Default API server port
 	AuthToken string `yaml:"auth_token"` // Optional auth token
+
+	Resources *ResourceBudget `yaml:"resources,omitempty"` // Host budget for persistent tools (nil = unlimited)
+	Packages  *PackageSources `yaml:"packages,omitempty"`  // Where pip and micromamba get packages (nil = public indexes)
+	Sandbox   *SandboxConfig  `yaml:"sandbox,omitempty"`   // Isolation for tool processes (nil = only tools that ask for it)
+	Broker    *BrokerConfig   `yaml:"broker,omitempty"`    // Security between a broker and its children (nil = plain HTTP, open registration)
+
+	ToolEnv map[string]map[string]string `yaml:"tool_env,omitempty"` // Per-tool environment variables, overriding the manifest's
+
+	HistoryRetentionDays int `yaml:"history_retention_days,omitempty"` // Days of call history to keep, default: 30
+	JobRetentionHours    int `yaml:"job_retention_hours,omitempty"`    // Hours to keep finished jobs, default: 24
+}
+
+// ResourceBudget is the host capacity shared by running persistent tools.
+// A limit that's set is enforced as given: a budget with gpus: 0 refuses tools
+// that need a GPU. A limit that's left out is unlimited.
+type ResourceBudget struct {
+	GPUs         *int   `yaml:"gpus,omitempty"`          // GPU slots
+	VRAMGB       *int   `yaml:"vram_gb,omitempty"`       // Total VRAM across GPUs
+	RAMGB        *int   `yaml:"ram_gb,omitempty"`        // System RAM available to tools
+	Policy       string `yaml:"policy,omitempty"`        // "refuse" (default) or "queue" when a start doesn't fit
+	EvictIdle    bool   `yaml:"evict_idle,omitempty"`    // Stop the least-recently-used idle tool to make room
+	QueueTimeout int    `yaml:"queue_timeout,omitempty"` // Seconds a queued start waits, default: 600
+}
+
+// SandboxConfig is the host's policy for sandboxing tool processes.
+type SandboxConfig struct {
+	Enforce   bool   `yaml:"enforce,omitempty"`    // Sandbox every tool, including those whose manifest has no sandbox section
+	NoNetwork bool   `yaml:"no_network,omitempty"` // Deny network access to sandboxed tools even if their manifest allows it
+	Bwrap     string `yaml:"bwrap,omitempty"`      // Path to bubblewrap, default: bwrap on PATH
+}
+
+// BrokerConfig secures the connection between a broker and its children. Both
+// read the same section: the secret a child registers with, and the files for
+// mutual TLS, where every node has a certificate signed by a shared CA.
+type BrokerConfig struct {
+	Secret   string `yaml:"secret,omitempty"`    // Shared secret children present to register, default: open registration
+	CAFile   string `yaml:"ca_file,omitempty"`   // CA that signs every node's certificate; setting it enables mTLS
+	CertFile string `yaml:"cert_file,omitempty"` // This node's certificate (PEM)
+	KeyFile  string `yaml:"key_file,omitempty"`  // This node's private key (PEM)
+}
+
+// PackageSources points installs at mirrors or local packages instead of PyPI and
+// conda-forge, for hosts with limited or no network access.
+type PackageSources struct {
+	PipIndexURL       string   `yaml:"pip_index_url,omitempty"`        // Replaces PyPI
+	PipExtraIndexURLs []string `yaml:"pip_extra_index_urls,omitempty"` // Searched in addition to the index
+	Wheelhouse        string   `yaml:"wheelhouse,omitempty"`           // Directory of wheels pip installs from (--find-links)
+	CondaChannels     []string `yaml:"conda_channels,omitempty"`       // Channel names, URLs or local channel paths, default: [conda-forge]
+	Offline           bool     `yaml:"offline,omitempty"`              // Never touch the network: no git, pip only from the wheelhouse, conda only from local channels and cache
+}
+
+// DefaultCondaChannel is used when no conda channels are configured
+const DefaultCondaChannel = "conda-forge"
+
+// Channels returns the conda channels to install from
+func (p *PackageSources) Channels() []string {
+	if p == nil || len(p.CondaChannels) == 0 {
+		return []string{DefaultCondaChannel}
+	}
+	return p.CondaChannels
 }
 
 // DefaultConfig returns config with default paths
@@ -83,3 +143,14 @@ func (c *Config) EnsureDirs() error {
 	}
 	return nil
 }
+
+// SecretsPath returns the path to the secrets file: per-tool environment variables
+// that are kept out of logs and tool info. It must be readable only by its owner.
+func (c *Config) SecretsPath() string {
+	return filepath.Join(c.BaseDir(), "secrets.yaml")
+}
+
+// LockPath returns the path to the lockfile recording where each tool came from
+func (c *Config) LockPath() string {
+	return filepath.Join(c.BaseDir(), "jb-serve.lock")
+}
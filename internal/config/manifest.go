@@ -1,5 +1,14 @@
 package config
 
+import (
+	"fmt"
+	"sort"
+)
+
+// ReservedMethods are the actions served at POST /v1/tools/{name}/{action}. A tool
+// method with one of these names could never be called, so manifests can't use them.
+var ReservedMethods = []string{"start", "stop", "upgrade", "rebuild", "bundle"}
+
 // Manifest represents a jumpboot.yaml tool manifest
 type Manifest struct {
 	Name         string       `yaml:"name"`
@@ -11,6 +20,8 @@ type Manifest struct {
 	RPC          RPC          `yaml:"rpc"`
 	Health       *Health      `yaml:"health,omitempty"`
 	Setup        *Setup       `yaml:"setup,omitempty"`
+	Restart      *Restart     `yaml:"restart,omitempty"`
+	Sandbox      *Sandbox     `yaml:"sandbox,omitempty"`
 }
 
 // Setup defines post-install setup configuration (e.g., model downloads)
@@ -19,6 +30,27 @@ type Setup struct {
 	Timeout int    `yaml:"timeout,omitempty"` // Seconds to wait, default: 600 (10 min)
 }
 
+// Restart defines the auto-restart policy for persistent tools
+type Restart struct {
+	Policy      string `yaml:"policy,omitempty"`       // "never" (default), "on-failure", or "always"
+	MaxRestarts int    `yaml:"max_restarts,omitempty"` // Restarts allowed within Window before giving up, default: 5
+	Window      int    `yaml:"window,omitempty"`       // Seconds, default: 300
+	Backoff     int    `yaml:"backoff,omitempty"`      // Initial delay in seconds, doubled per restart, default: 1
+	MaxBackoff  int    `yaml:"max_backoff,omitempty"`  // Cap on the delay in seconds, default: 60
+}
+
+// Sandbox runs the tool's processes under bubblewrap (Linux only). They see the
+// system directories, the tool directory and environment read-only, files uploaded
+// for its own calls, and a private writable home; nothing else from the host, and
+// only PATH, LANG, JB_SERVE_URL and the tool's own variables from the environment.
+type Sandbox struct {
+	Network    bool     `yaml:"network,omitempty" json:"network"`                   // Allow network access, default: off
+	Paths      []string `yaml:"paths,omitempty" json:"paths,omitempty"`             // Extra host paths, read-only unless suffixed with ":rw"
+	MemoryMB   int      `yaml:"memory_mb,omitempty" json:"memory_mb,omitempty"`     // Address space per process, 0 = unlimited
+	CPUSeconds int      `yaml:"cpu_seconds,omitempty" json:"cpu_seconds,omitempty"` // CPU time per process, 0 = unlimited
+	OpenFiles  int      `yaml:"open_files,omitempty" json:"open_files,omitempty"`   // Open file descriptors per process, 0 = inherited
+}
+
 // Runtime defines the Python environment requirements
 type Runtime struct {
 	Python         string   `yaml:"python"`                    // Python version (e.g., "3.11")
@@ -29,6 +61,13 @@ type Runtime struct {
 	Transport      string   `yaml:"transport,omitempty"`       // "repl" (default) or "msgpack"
 	Entrypoint     string   `yaml:"entrypoint,omitempty"`      // main script, defaults to main.py
 	StartupTimeout int      `yaml:"startup_timeout,omitempty"` // seconds
+	Workers        int      `yaml:"workers,omitempty"`         // Worker processes for persistent tools, default: 1
+	MaxConcurrency int      `yaml:"max_concurrency,omitempty"` // Calls running at once, default: workers
+	MaxQueue       int      `yaml:"max_queue,omitempty"`       // Calls allowed to wait, 0 = unlimited
+	IdleTimeout    int      `yaml:"idle_timeout,omitempty"`    // Seconds without calls before a persistent tool is stopped, 0 = never
+	Autostart      bool     `yaml:"autostart,omitempty"`       // Start a stopped persistent tool on its first call
+
+	Env map[string]string `yaml:"env,omitempty"` // Environment variables for the tool's processes; ${JB_TOOL_DIR} and a few server variables like ${HOME} expand
 }
 
 // Resources defines resource hints for scheduling
@@ -50,6 +89,7 @@ type Method struct {
 	Description string  `yaml:"description" json:"description"`
 	Input       *Schema `yaml:"input,omitempty" json:"input,omitempty"`
 	Output      *Schema `yaml:"output,omitempty" json:"output,omitempty"`
+	Timeout     int     `yaml:"timeout,omitempty" json:"timeout,omitempty"` // Seconds before the call is abandoned, default: 300
 }
 
 // Schema is a simplified JSON Schema
@@ -67,6 +107,7 @@ type Health struct {
 	Method           string `yaml:"method,omitempty"`            // Method to call, default: "health"
 	Interval         int    `yaml:"interval,omitempty"`          // Seconds between checks, default: 30
 	FailureThreshold int    `yaml:"failure_threshold,omitempty"` // Consecutive failures before unhealthy, default: 3
+	Timeout          int    `yaml:"timeout,omitempty"`           // Seconds a check may take, default: 10
 }
 
 // ApplyDefaults fills in sensible defaults
@@ -83,6 +124,12 @@ func (m *Manifest) ApplyDefaults() {
 	if m.Runtime.StartupTimeout == 0 {
 		m.Runtime.StartupTimeout = 60
 	}
+	if m.Runtime.Workers <= 0 {
+		m.Runtime.Workers = 1
+	}
+	if m.Runtime.MaxConcurrency <= 0 {
+		m.Runtime.MaxConcurrency = m.Runtime.Workers
+	}
 	if m.RPC.Transport == "" {
 		m.RPC.Transport = "jsonqueue"
 	}
@@ -96,6 +143,26 @@ func (m *Manifest) ApplyDefaults() {
 		if m.Health.FailureThreshold == 0 {
 			m.Health.FailureThreshold = 3
 		}
+		if m.Health.Timeout == 0 {
+			m.Health.Timeout = 10
+		}
+	}
+	if m.Restart != nil {
+		if m.Restart.Policy == "" {
+			m.Restart.Policy = "never"
+		}
+		if m.Restart.MaxRestarts == 0 {
+			m.Restart.MaxRestarts = 5
+		}
+		if m.Restart.Window == 0 {
+			m.Restart.Window = 300
+		}
+		if m.Restart.Backoff == 0 {
+			m.Restart.Backoff = 1
+		}
+		if m.Restart.MaxBackoff == 0 {
+			m.Restart.MaxBackoff = 60
+		}
 	}
 	if m.Setup != nil {
 		if m.Setup.Method == "" {
@@ -106,3 +173,37 @@ func (m *Manifest) ApplyDefaults() {
 		}
 	}
 }
+
+// Validate rejects settings that can't be honored. Call it after ApplyDefaults.
+func (m *Manifest) Validate() error {
+	// Each running call needs a worker of its own: a Python process handles one call at a time
+	if m.Runtime.MaxConcurrency > m.Runtime.Workers {
+		return fmt.Errorf("runtime.max_concurrency (%d) can't exceed runtime.workers (%d)", m.Runtime.MaxConcurrency, m.Runtime.Workers)
+	}
+	if m.Restart != nil {
+		switch m.Restart.Policy {
+		case "never", "on-failure", "always":
+		default:
+			return fmt.Errorf("unknown restart.policy %q (want never, on-failure or always)", m.Restart.Policy)
+		}
+	}
+
+	// A reserved name would be shadowed by its action, and a default that doesn't
+	// match its type would fail every call that omits it
+	names := make([]string, 0, len(m.RPC.Methods))
+	for name := range m.RPC.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, reserved := range ReservedMethods {
+			if name == reserved {
+				return fmt.Errorf("method %s is reserved for POST /v1/tools/{name}/%s; rename it", name, name)
+			}
+		}
+		if errs := m.RPC.Methods[name].Input.CheckDefaults(); len(errs) > 0 {
+			return fmt.Errorf("method %s: default for %s: %s", name, errs[0].Field, errs[0].Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldError describes a single parameter that failed schema validation
+type FieldError struct {
+	Field   string `json:"field"`   // Dotted path to the field (e.g., "options.width", "items[2]")
+	Message string `json:"message"` // Human-readable reason
+}
+
+// ValidationError is returned when call parameters don't match a method's input schema
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fmt.Sprintf("%s: %s", fe.Field, fe.Message)
+	}
+	return "invalid parameters: " + strings.Join(msgs, "; ")
+}
+
+// ValidateParams checks params against an input schema, fills in defaults, and
+// coerces string values (from form fields or CLI key=value pairs) to the declared
+// type. Only strings are coerced; a number or boolean where a string is declared
+// is an error.
+// A nil schema accepts anything. Returns the normalized params or a *ValidationError.
+func ValidateParams(schema *Schema, params map[string]interface{}) (map[string]interface{}, error) {
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	if schema == nil {
+		return params, nil
+	}
+
+	var errs []FieldError
+	out := schema.validateObject("", params, &errs)
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+	return out, nil
+}
+
+// validateObject validates a params object against the schema's properties
+func (s *Schema) validateObject(path string, obj map[string]interface{}, errs *[]FieldError) map[string]interface{} {
+	out := make(map[string]interface{}, len(obj))
+
+	// Reject unknown fields when properties are declared - catches typos before a process is spawned
+	if len(s.Properties) > 0 {
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, ok := s.Properties[k]; !ok {
+				*errs = append(*errs, FieldError{Field: joinPath(path, k), Message: "unknown field"})
+			}
+		}
+	}
+
+	for k, v := range obj {
+		prop, ok := s.Properties[k]
+		if !ok || prop == nil {
+			out[k] = v
+			continue
+		}
+		out[k] = prop.validateValue(joinPath(path, k), v, errs)
+	}
+
+	// Fill defaults for missing properties, coerced like a value the caller sent.
+	// Manifest.Validate has already checked that they match their type.
+	for name, prop := range s.Properties {
+		if _, ok := out[name]; ok || prop == nil || prop.Default == nil {
+			continue
+		}
+		out[name] = prop.validateValue(joinPath(path, name), prop.Default, errs)
+	}
+
+	for _, name := range s.Required {
+		if v, ok := out[name]; !ok || v == nil {
+			*errs = append(*errs, FieldError{Field: joinPath(path, name), Message: "required field missing"})
+		}
+	}
+
+	return out
+}
+
+// CheckDefaults returns an error for each default value in the schema that doesn't
+// match its property's type
+func (s *Schema) CheckDefaults() []FieldError {
+	var errs []FieldError
+	s.checkDefaults("", &errs)
+	return errs
+}
+
+func (s *Schema) checkDefaults(path string, errs *[]FieldError) {
+	if s == nil {
+		return
+	}
+	if s.Default != nil && path != "" {
+		s.validateValue(path, s.Default, errs)
+	}
+
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.Properties[name].checkDefaults(joinPath(path, name), errs)
+	}
+	if s.Items != nil {
+		s.Items.checkDefaults(path+"[]", errs)
+	}
+}
+
+// validateValue checks a single value against the schema type, returning the coerced value
+func (s *Schema) validateValue(path string, v interface{}, errs *[]FieldError) interface{} {
+	if v == nil || s.Type == "" {
+		return v
+	}
+
+	switch s.Type {
+	case "string", "file":
+		if val, ok := v.(string); ok {
+			return val
+		}
+
+	case "number":
+		if n, ok := toFloat(v); ok {
+			return n
+		}
+
+	case "integer":
+		if n, ok := toFloat(v); ok {
+			if n == math.Trunc(n) {
+				return int64(n)
+			}
+			*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf("expected integer, got %v", v)})
+			return v
+		}
+
+	case "boolean":
+		switch val := v.(type) {
+		case bool:
+			return val
+		case string:
+			if b, err := strconv.ParseBool(val); err == nil {
+				return b
+			}
+		}
+
+	case "object":
+		if obj, ok := v.(map[string]interface{}); ok {
+			return s.validateObject(path, obj, errs)
+		}
+
+	case "array":
+		if arr, ok := v.([]interface{}); ok {
+			if s.Items == nil {
+				return arr
+			}
+			out := make([]interface{}, len(arr))
+			for i, item := range arr {
+				out[i] = s.Items.validateValue(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+			return out
+		}
+
+	default:
+		// Unknown schema types are passed through for the tool to handle
+		return v
+	}
+
+	*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf("expected %s, got %s", s.Type, typeName(v))})
+	return v
+}
+
+// toFloat converts numeric values (and numeric strings) to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// typeName returns the JSON type name of a decoded value
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64, float32, int, int64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func joinPath(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
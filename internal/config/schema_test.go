@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testSchema() *Schema {
+	return &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"prompt":  {Type: "string"},
+			"steps":   {Type: "integer", Default: 20},
+			"scale":   {Type: "number", Default: 7.5},
+			"upscale": {Type: "boolean", Default: false},
+			"image":   {Type: "file"},
+			"options": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"width": {Type: "integer", Default: 512},
+				},
+			},
+			"tags": {Type: "array", Items: &Schema{Type: "number"}},
+		},
+		Required: []string{"prompt"},
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   map[string]interface{} // Normalized params, if valid
+		errs   []FieldError           // Expected field errors, if not
+	}{
+		{
+			name:   "defaults filled",
+			params: map[string]interface{}{"prompt": "a cat"},
+			want:   map[string]interface{}{"prompt": "a cat", "steps": int64(20), "scale": 7.5, "upscale": false},
+		},
+		{
+			name:   "nested defaults filled",
+			params: map[string]interface{}{"prompt": "a cat", "options": map[string]interface{}{}},
+			want: map[string]interface{}{"prompt": "a cat", "steps": int64(20), "scale": 7.5, "upscale": false,
+				"options": map[string]interface{}{"width": int64(512)}},
+		},
+		{
+			name:   "given values kept over defaults",
+			params: map[string]interface{}{"prompt": "a cat", "steps": float64(30), "scale": float64(2), "upscale": true},
+			want:   map[string]interface{}{"prompt": "a cat", "steps": int64(30), "scale": float64(2), "upscale": true},
+		},
+		{
+			name:   "strings coerced to numbers and booleans",
+			params: map[string]interface{}{"prompt": "a cat", "steps": "30", "scale": "1.5", "upscale": "true", "tags": []interface{}{"1", float64(2)}},
+			want: map[string]interface{}{"prompt": "a cat", "steps": int64(30), "scale": 1.5, "upscale": true,
+				"tags": []interface{}{float64(1), float64(2)}},
+		},
+		{
+			name:   "numbers not coerced to strings",
+			params: map[string]interface{}{"prompt": float64(2024)},
+			errs:   []FieldError{{Field: "prompt", Message: "expected string, got number"}},
+		},
+		{
+			name:   "booleans not coerced to files",
+			params: map[string]interface{}{"prompt": "a cat", "image": true},
+			errs:   []FieldError{{Field: "image", Message: "expected file, got boolean"}},
+		},
+		{
+			name:   "unparseable string",
+			params: map[string]interface{}{"prompt": "a cat", "scale": "big", "upscale": "maybe"},
+			errs: []FieldError{
+				{Field: "scale", Message: "expected number, got string"},
+				{Field: "upscale", Message: "expected boolean, got string"},
+			},
+		},
+		{
+			name:   "fractional integer",
+			params: map[string]interface{}{"prompt": "a cat", "steps": 2.5},
+			errs:   []FieldError{{Field: "steps", Message: "expected integer, got 2.5"}},
+		},
+		{
+			name:   "required field missing",
+			params: map[string]interface{}{},
+			errs:   []FieldError{{Field: "prompt", Message: "required field missing"}},
+		},
+		{
+			name:   "required field null",
+			params: map[string]interface{}{"prompt": nil},
+			errs:   []FieldError{{Field: "prompt", Message: "required field missing"}},
+		},
+		{
+			name:   "unknown fields",
+			params: map[string]interface{}{"prompt": "a cat", "stpes": float64(3), "options": map[string]interface{}{"hieght": float64(1)}},
+			errs: []FieldError{
+				{Field: "stpes", Message: "unknown field"},
+				{Field: "options.hieght", Message: "unknown field"},
+			},
+		},
+		{
+			name:   "array item paths",
+			params: map[string]interface{}{"prompt": "a cat", "tags": []interface{}{float64(1), "x"}},
+			errs:   []FieldError{{Field: "tags[1]", Message: "expected number, got string"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateParams(testSchema(), tt.params)
+			if tt.errs == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %#v, want %#v", got, tt.want)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("got %v, want a *ValidationError", err)
+			}
+			if !sameErrors(verr.Errors, tt.errs) {
+				t.Fatalf("got errors %v, want %v", verr.Errors, tt.errs)
+			}
+		})
+	}
+}
+
+// sameErrors compares field errors ignoring order, since properties are checked in map order
+func sameErrors(got, want []FieldError) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := make(map[FieldError]int)
+	for _, fe := range got {
+		seen[fe]++
+	}
+	for _, fe := range want {
+		if seen[fe] == 0 {
+			return false
+		}
+		seen[fe]--
+	}
+	return true
+}
+
+func TestValidateParamsNilSchema(t *testing.T) {
+	params := map[string]interface{}{"anything": float64(1)}
+	got, err := ValidateParams(nil, params)
+	if err != nil || !reflect.DeepEqual(got, params) {
+		t.Fatalf("got %v, %v; want params unchanged", got, err)
+	}
+	if got, err := ValidateParams(nil, nil); err != nil || got == nil {
+		t.Fatalf("got %v, %v; want an empty map", got, err)
+	}
+}
+
+func TestManifestValidateDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   *Schema
+		wantErr string // Empty if the manifest is valid
+	}{
+		{
+			name:  "matching defaults",
+			input: testSchema(),
+		},
+		{
+			name:  "no input schema",
+			input: nil,
+		},
+		{
+			name:    "string default for an integer",
+			input:   &Schema{Type: "object", Properties: map[string]*Schema{"steps": {Type: "integer", Default: "many"}}},
+			wantErr: "method generate: default for steps: expected integer, got string",
+		},
+		{
+			name:    "number default for a string",
+			input:   &Schema{Type: "object", Properties: map[string]*Schema{"prompt": {Type: "string", Default: 5}}},
+			wantErr: "method generate: default for prompt: expected string, got number",
+		},
+		{
+			name: "nested default",
+			input: &Schema{Type: "object", Properties: map[string]*Schema{
+				"options": {Type: "object", Properties: map[string]*Schema{"width": {Type: "integer", Default: 1.5}}},
+			}},
+			wantErr: "method generate: default for options.width: expected integer, got 1.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manifest{Name: "test"}
+			m.RPC.Methods = map[string]Method{"generate": {Input: tt.input}}
+			m.ApplyDefaults()
+
+			err := m.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManifestValidateReservedMethods(t *testing.T) {
+	for _, name := range ReservedMethods {
+		m := &Manifest{Name: "test"}
+		m.RPC.Methods = map[string]Method{name: {}, "generate": {}}
+		m.ApplyDefaults()
+		if err := m.Validate(); err == nil || !strings.Contains(err.Error(), "method "+name+" is reserved") {
+			t.Fatalf("%s: got %v, want a reserved method error", name, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TLSEnabled reports whether mutual TLS is configured
+func (b *BrokerConfig) TLSEnabled() bool {
+	return b != nil && b.CAFile != ""
+}
+
+// TLSConfigs loads the mutual TLS configs: server for a node's listener and
+// client for its connections to other nodes. Both are nil if mTLS isn't
+// configured. The listener verifies a client certificate when one is presented
+// but doesn't require it, so callers without one can still use a token.
+func (b *BrokerConfig) TLSConfigs() (server, client *tls.Config, err error) {
+	if !b.TLSEnabled() {
+		return nil, nil, nil
+	}
+	if b.CertFile == "" || b.KeyFile == "" {
+		return nil, nil, fmt.Errorf("broker.ca_file needs broker.cert_file and broker.key_file")
+	}
+
+	caPEM, err := os.ReadFile(expandPath(b.CAFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, nil, fmt.Errorf("no certificates found in %s", b.CAFile)
+	}
+	cert, err := tls.LoadX509KeyPair(expandPath(b.CertFile), expandPath(b.KeyFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return server, client, nil
+}
+
+// expandPath expands a leading ~ to the home directory
+func expandPath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
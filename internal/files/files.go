@@ -51,9 +51,28 @@ func NewManager(baseDir string) (*Manager, error) {
 
 // SaveUpload saves a multipart file to temp storage and returns the path
 func (m *Manager) SaveUpload(file multipart.File, header *multipart.FileHeader) (string, error) {
+	return m.saveUpload(m.uploadDir, file, header)
+}
+
+// SaveToolUpload saves a multipart file passed to a tool in the tool's own upload
+// directory, which is the only one a sandboxed tool can see
+func (m *Manager) SaveToolUpload(toolName string, file multipart.File, header *multipart.FileHeader) (string, error) {
+	dir := m.ToolUploadDir(toolName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create dir %s: %w", dir, err)
+	}
+	return m.saveUpload(dir, file, header)
+}
+
+// ToolUploadDir returns the directory uploads passed to a tool are saved in
+func (m *Manager) ToolUploadDir(toolName string) string {
+	return filepath.Join(m.uploadDir, toolName)
+}
+
+func (m *Manager) saveUpload(dir string, file multipart.File, header *multipart.FileHeader) (string, error) {
 	ext := filepath.Ext(header.Filename)
 	filename := uuid.New().String() + ext
-	path := filepath.Join(m.uploadDir, filename)
+	path := filepath.Join(dir, filename)
 
 	dst, err := os.Create(path)
 	if err != nil {
@@ -144,7 +163,7 @@ func (m *Manager) OutputDir() string {
 
 // Cleanup removes a temporary upload file
 func (m *Manager) Cleanup(path string) error {
-	if filepath.Dir(path) != m.uploadDir {
+	if dir := filepath.Dir(path); dir != m.uploadDir && filepath.Dir(dir) != m.uploadDir {
 		return nil
 	}
 	return os.Remove(path)
@@ -37,6 +37,7 @@ type Store struct {
 	gcInterval time.Duration
 	gcStop     chan struct{}
 	gcWg       sync.WaitGroup
+	gcDeleted  int64 // Files removed by GC since startup
 }
 
 // New creates a new file store at the given base directory.
@@ -164,6 +165,145 @@ func (s *Store) Import(sourcePath string, name string, ttl int64) (*FileInfo, er
 	}, nil
 }
 
+// ImportShared imports a file unless the store already has an unexpired file with
+// the same SHA256, in which case that file is returned instead, its TTL extended
+// to at least ttl.
+func (s *Store) ImportShared(sourcePath string, name string, ttl int64) (*FileInfo, error) {
+	hash, err := hashFile(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.FindBySHA256(hash)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return s.Import(sourcePath, name, ttl)
+	}
+
+	if existing.ExpiresAt > 0 && (ttl == 0 || existing.ExpiresAt < time.Now().Unix()+ttl) {
+		if err := s.SetTTL(existing.ID, ttl); err != nil {
+			return nil, err
+		}
+		return s.Info(existing.ID)
+	}
+	return existing, nil
+}
+
+// Replicate stores a copy of a file from another node under the same ID, so
+// references to it resolve here too. If the store already has the content (the
+// same SHA256 under another ID), it's copied locally and fetch isn't called.
+// Fetched content must match info's SHA256.
+func (s *Store) Replicate(info *FileInfo, fetch func() (io.ReadCloser, error)) (*FileInfo, error) {
+	if existing, err := s.Info(info.ID); err == nil {
+		return existing, nil
+	}
+	if _, err := uuid.Parse(info.ID); err != nil {
+		return nil, fmt.Errorf("invalid file ID: %s", info.ID)
+	}
+
+	local, err := s.FindBySHA256(info.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	var src io.ReadCloser
+	if local != nil {
+		src, err = os.Open(local.Path)
+	} else {
+		src, err = fetch()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source: %w", err)
+	}
+	defer src.Close()
+
+	// Copy outside the lock, since fetching can take a while
+	tmp, err := os.CreateTemp(s.blobDir, info.ID+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy file: %w", err)
+	}
+	if hash := hex.EncodeToString(hasher.Sum(nil)); hash != info.SHA256 {
+		return nil, fmt.Errorf("checksum mismatch for %s: got %s, want %s", info.ID, hash, info.SHA256)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dstPath := filepath.Join(s.blobDir, info.ID)
+	if err := os.Rename(tmp.Name(), dstPath); err != nil {
+		return nil, fmt.Errorf("failed to store blob: %w", err)
+	}
+	// A concurrent replica of the same file may have got here first; its row stands
+	_, err = s.db.Exec(
+		`INSERT OR IGNORE INTO files (id, name, size, sha256, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		info.ID, info.Name, size, info.SHA256, info.CreatedAt, info.ExpiresAt,
+	)
+	if err != nil {
+		os.Remove(dstPath)
+		return nil, fmt.Errorf("failed to insert record: %w", err)
+	}
+
+	return &FileInfo{
+		ID:        info.ID,
+		Name:      info.Name,
+		Size:      size,
+		SHA256:    info.SHA256,
+		Path:      dstPath,
+		CreatedAt: info.CreatedAt,
+		ExpiresAt: info.ExpiresAt,
+	}, nil
+}
+
+// FindBySHA256 returns an unexpired file with the given content, or nil if
+// there's none.
+func (s *Store) FindBySHA256(hash string) (*FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var info FileInfo
+	err := s.db.QueryRow(
+		`SELECT id, name, size, sha256, created_at, expires_at FROM files
+		 WHERE sha256 = ? AND (expires_at = 0 OR expires_at > ?)
+		 ORDER BY expires_at = 0 DESC, expires_at DESC LIMIT 1`,
+		hash, time.Now().Unix(),
+	).Scan(&info.ID, &info.Name, &info.Size, &info.SHA256, &info.CreatedAt, &info.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	info.Path = filepath.Join(s.blobDir, info.ID)
+	return &info, nil
+}
+
+// hashFile returns the hex SHA256 of a file's content
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source: %w", err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", fmt.Errorf("failed to read source: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
 // GetPath returns the blob path for a file ID.
 // Returns empty string if file doesn't exist.
 func (s *Store) GetPath(id string) (string, error) {
@@ -354,10 +494,19 @@ func (s *Store) runGC() {
 
 	// Delete each expired file
 	for _, id := range expired {
-		s.deleteLocked(id)
+		if s.deleteLocked(id) == nil {
+			s.gcDeleted++
+		}
 	}
 }
 
+// GCDeleted returns how many expired files GC has removed since the store opened.
+func (s *Store) GCDeleted() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gcDeleted
+}
+
 // Stats returns storage statistics.
 func (s *Store) Stats() (totalFiles int64, totalSize int64, err error) {
 	s.mu.RLock()
@@ -0,0 +1,239 @@
+// Package history records every tool call in SQLite for auditing and debugging.
+package history
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DefaultRetention is how long call records are kept when not configured.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Call outcomes
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Call is one recorded method call.
+type Call struct {
+	ID           int64    `json:"id"`
+	Tool         string   `json:"tool"`
+	Method       string   `json:"method"`
+	Mode         string   `json:"mode"`          // "sync", "stream" or "async"
+	ParamsDigest string   `json:"params_digest"` // sha256 of the JSON-encoded params
+	Caller       string   `json:"caller,omitempty"`
+	Status       string   `json:"status"`
+	ErrorCode    string   `json:"error_code,omitempty"`
+	ErrorType    string   `json:"error_type,omitempty"`
+	OutputFiles  []string `json:"output_files,omitempty"` // Refs of files the call produced
+	StartedAt    int64    `json:"started_at"`             // Unix milliseconds
+	DurationMS   int64    `json:"duration_ms"`
+}
+
+// Filter selects calls for List. Zero values match everything.
+type Filter struct {
+	Tool   string
+	Method string
+	Status string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // Default 100
+}
+
+// Store keeps call records in {baseDir}/calls.db.
+type Store struct {
+	db        *sql.DB
+	retention time.Duration
+
+	// GC settings
+	gcInterval time.Duration
+	gcStop     chan struct{}
+	gcWg       sync.WaitGroup
+}
+
+// New opens the call history at the given base directory. Records older than
+// retention are removed periodically; 0 uses DefaultRetention.
+func New(baseDir string, retention time.Duration) (*Store, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	dbPath := filepath.Join(baseDir, "calls.db")
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := createSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	s := &Store{
+		db:         db,
+		retention:  retention,
+		gcInterval: 10 * time.Minute,
+		gcStop:     make(chan struct{}),
+	}
+
+	// Start GC goroutine
+	s.gcWg.Add(1)
+	go s.gcLoop()
+
+	return s, nil
+}
+
+func createSchema(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS calls (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tool TEXT NOT NULL,
+		method TEXT NOT NULL,
+		mode TEXT NOT NULL,
+		params_digest TEXT NOT NULL,
+		caller TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		error_code TEXT NOT NULL DEFAULT '',
+		error_type TEXT NOT NULL DEFAULT '',
+		output_files TEXT NOT NULL DEFAULT '',
+		started_at INTEGER NOT NULL,
+		duration_ms INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_calls_started ON calls(started_at);
+	CREATE INDEX IF NOT EXISTS idx_calls_tool ON calls(tool, started_at);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// Digest returns a stable fingerprint of call params. Params themselves are not
+// stored since they may hold prompts, secrets or large payloads.
+func Digest(params map[string]interface{}) string {
+	// encoding/json sorts map keys, so equal params give equal digests
+	data, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Record appends a call to the history.
+func (s *Store) Record(c *Call) error {
+	res, err := s.db.Exec(
+		`INSERT INTO calls (tool, method, mode, params_digest, caller, status, error_code, error_type, output_files, started_at, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.Tool, c.Method, c.Mode, c.ParamsDigest, c.Caller, c.Status, c.ErrorCode, c.ErrorType,
+		strings.Join(c.OutputFiles, ","), c.StartedAt, c.DurationMS,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	c.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// List returns calls matching the filter, newest first.
+func (s *Store) List(f Filter) ([]*Call, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	query := `SELECT id, tool, method, mode, params_digest, caller, status, error_code, error_type, output_files, started_at, duration_ms
+		FROM calls WHERE 1 = 1`
+	var args []interface{}
+	if f.Tool != "" {
+		query += ` AND tool = ?`
+		args = append(args, f.Tool)
+	}
+	if f.Method != "" {
+		query += ` AND method = ?`
+		args = append(args, f.Method)
+	}
+	if f.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, f.Status)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND started_at >= ?`
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		query += ` AND started_at < ?`
+		args = append(args, f.Until.UnixMilli())
+	}
+	query += ` ORDER BY started_at DESC, id DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	calls := []*Call{}
+	for rows.Next() {
+		var c Call
+		var outputs string
+		if err := rows.Scan(&c.ID, &c.Tool, &c.Method, &c.Mode, &c.ParamsDigest, &c.Caller, &c.Status,
+			&c.ErrorCode, &c.ErrorType, &outputs, &c.StartedAt, &c.DurationMS); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		if outputs != "" {
+			c.OutputFiles = strings.Split(outputs, ",")
+		}
+		calls = append(calls, &c)
+	}
+	return calls, rows.Err()
+}
+
+// gcLoop periodically removes calls older than the retention period
+func (s *Store) gcLoop() {
+	defer s.gcWg.Done()
+
+	ticker := time.NewTicker(s.gcInterval)
+	defer ticker.Stop()
+
+	// Run once at startup
+	s.runGC()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.runGC()
+		case <-s.gcStop:
+			return
+		}
+	}
+}
+
+// runGC deletes expired call records
+func (s *Store) runGC() {
+	cutoff := time.Now().Add(-s.retention).UnixMilli()
+	res, err := s.db.Exec(`DELETE FROM calls WHERE started_at < ?`, cutoff)
+	if err != nil {
+		log.Printf("Call history GC failed: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Call history GC: removed %d records", n)
+	}
+}
+
+// Close stops the GC loop and closes the database.
+func (s *Store) Close() error {
+	close(s.gcStop)
+	s.gcWg.Wait()
+	return s.db.Close()
+}
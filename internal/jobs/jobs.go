@@ -0,0 +1,376 @@
+// Package jobs runs tool calls in the background and records them in SQLite.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/tools"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Job states
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+var (
+	// ErrNotFound is returned for unknown job IDs.
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned when canceling a job that already ended.
+	ErrFinished = errors.New("job already finished")
+)
+
+// Job is a background tool call.
+type Job struct {
+	ID         string           `json:"id"`
+	Tool       string           `json:"tool"`
+	Method     string           `json:"method"`
+	Status     string           `json:"status"`
+	Progress   interface{}      `json:"progress,omitempty"` // Latest progress event from the tool
+	Result     interface{}      `json:"result,omitempty"`
+	Error      *tools.CallError `json:"error,omitempty"`
+	CreatedAt  int64            `json:"created_at"`
+	StartedAt  int64            `json:"started_at,omitempty"`
+	FinishedAt int64            `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job has reached a final state.
+func (j *Job) Finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed || j.Status == StatusCanceled
+}
+
+// RunFunc performs a job's call, reporting progress events as they arrive.
+type RunFunc func(ctx context.Context, progress func(interface{})) (interface{}, error)
+
+// running tracks a job executing in this process
+type running struct {
+	cancel   context.CancelFunc
+	progress interface{}
+	canceled bool
+}
+
+// DefaultRetention is how long finished jobs are kept when not configured.
+const DefaultRetention = 24 * time.Hour
+
+// Manager runs jobs and keeps their state in {baseDir}/jobs.db.
+type Manager struct {
+	db *sql.DB
+	mu sync.Mutex
+
+	running   map[string]*running
+	retention time.Duration // How long finished jobs are kept
+
+	gcStop chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New opens the job table at the given base directory. Jobs left running by a
+// previous server process are marked failed. Finished jobs older than retention
+// are removed periodically; 0 uses DefaultRetention.
+func New(baseDir string, retention time.Duration) (*Manager, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	dbPath := filepath.Join(baseDir, "jobs.db")
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := createSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	errJSON, _ := json.Marshal(&tools.CallError{Code: tools.ErrCodeUnavailable, Message: "server restarted while the job was running"})
+	if _, err := db.Exec(
+		`UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE status IN (?, ?)`,
+		StatusFailed, string(errJSON), time.Now().Unix(), StatusQueued, StatusRunning,
+	); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to recover jobs: %w", err)
+	}
+
+	m := &Manager{
+		db:        db,
+		running:   make(map[string]*running),
+		retention: retention,
+		gcStop:    make(chan struct{}),
+	}
+
+	m.wg.Add(1)
+	go m.gcLoop()
+
+	return m, nil
+}
+
+func createSchema(db *sql.DB) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		tool TEXT NOT NULL,
+		method TEXT NOT NULL,
+		status TEXT NOT NULL,
+		progress TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		started_at INTEGER NOT NULL DEFAULT 0,
+		finished_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
+	CREATE INDEX IF NOT EXISTS idx_jobs_created ON jobs(created_at);
+	`
+	_, err := db.Exec(schema)
+	return err
+}
+
+// Submit records a new job and starts run in the background. The job outlives the
+// request that created it and is abandoned after timeout, if set.
+func (m *Manager) Submit(toolName, methodName string, timeout time.Duration, run RunFunc) (*Job, error) {
+	job := &Job{
+		ID:        uuid.New().String(),
+		Tool:      toolName,
+		Method:    methodName,
+		Status:    StatusQueued,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	if _, err := m.db.Exec(
+		`INSERT INTO jobs (id, tool, method, status, created_at) VALUES (?, ?, ?, ?, ?)`,
+		job.ID, job.Tool, job.Method, job.Status, job.CreatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	r := &running{cancel: cancel}
+
+	m.mu.Lock()
+	m.running[job.ID] = r
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.execute(ctx, job.ID, r, run)
+
+	return job, nil
+}
+
+// execute runs a job and records its outcome
+func (m *Manager) execute(ctx context.Context, id string, r *running, run RunFunc) {
+	defer m.wg.Done()
+	defer r.cancel()
+
+	if _, err := m.db.Exec(`UPDATE jobs SET status = ?, started_at = ? WHERE id = ?`, StatusRunning, time.Now().Unix(), id); err != nil {
+		log.Printf("Job %s: failed to record start: %v", id, err)
+	}
+
+	result, err := run(ctx, func(p interface{}) {
+		m.mu.Lock()
+		r.progress = p
+		m.mu.Unlock()
+	})
+
+	m.mu.Lock()
+	delete(m.running, id)
+	canceled := r.canceled
+	progress := r.progress
+	m.mu.Unlock()
+
+	status := StatusSucceeded
+	var callErr *tools.CallError
+	if err != nil {
+		status = StatusFailed
+		callErr = tools.AsCallError(err, "", "")
+		if canceled {
+			status = StatusCanceled
+		}
+	}
+
+	resultJSON := encode(result)
+	if err != nil {
+		resultJSON = ""
+	}
+	if _, dbErr := m.db.Exec(
+		`UPDATE jobs SET status = ?, progress = ?, result = ?, error = ?, finished_at = ? WHERE id = ?`,
+		status, encode(progress), resultJSON, encode(callErr), time.Now().Unix(), id,
+	); dbErr != nil {
+		log.Printf("Job %s: failed to record result: %v", id, dbErr)
+	}
+}
+
+// Get returns a job by ID.
+func (m *Manager) Get(id string) (*Job, error) {
+	row := m.db.QueryRow(
+		`SELECT id, tool, method, status, progress, result, error, created_at, started_at, finished_at FROM jobs WHERE id = ?`,
+		id,
+	)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	m.overlayProgress(job)
+	return job, nil
+}
+
+// List returns the most recent jobs, newest first, optionally filtered by status and tool.
+func (m *Manager) List(status, toolName string, limit int) ([]*Job, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT id, tool, method, status, progress, result, error, created_at, started_at, finished_at FROM jobs WHERE 1 = 1`
+	var args []interface{}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if toolName != "" {
+		query += ` AND tool = ?`
+		args = append(args, toolName)
+	}
+	query += ` ORDER BY created_at DESC, rowid DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		m.overlayProgress(job)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Cancel stops a queued or running job.
+func (m *Manager) Cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	r, ok := m.running[id]
+	if ok {
+		r.canceled = true
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		job, err := m.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return job, ErrFinished
+	}
+
+	r.cancel()
+	return m.Get(id)
+}
+
+// overlayProgress fills in live progress for jobs running in this process
+func (m *Manager) overlayProgress(job *Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.running[job.ID]; ok && r.progress != nil {
+		job.Progress = r.progress
+	}
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var job Job
+	var progress, result, errJSON string
+	if err := row.Scan(&job.ID, &job.Tool, &job.Method, &job.Status, &progress, &result, &errJSON,
+		&job.CreatedAt, &job.StartedAt, &job.FinishedAt); err != nil {
+		return nil, err
+	}
+	if progress != "" {
+		json.Unmarshal([]byte(progress), &job.Progress)
+	}
+	if result != "" {
+		json.Unmarshal([]byte(result), &job.Result)
+	}
+	if errJSON != "" {
+		job.Error = &tools.CallError{}
+		json.Unmarshal([]byte(errJSON), job.Error)
+	}
+	return &job, nil
+}
+
+// encode marshals v for a TEXT column, "" for nil
+func encode(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if ce, ok := v.(*tools.CallError); ok && ce == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// gcLoop periodically removes old finished jobs
+func (m *Manager) gcLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := time.Now().Add(-m.retention).Unix()
+			m.db.Exec(`DELETE FROM jobs WHERE finished_at > 0 AND finished_at < ?`, cutoff)
+		case <-m.gcStop:
+			return
+		}
+	}
+}
+
+// Close cancels running jobs, waits for them to finish and closes the database.
+func (m *Manager) Close() error {
+	close(m.gcStop)
+
+	m.mu.Lock()
+	for _, r := range m.running {
+		r.cancel()
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	return m.db.Close()
+}
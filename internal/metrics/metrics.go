@@ -0,0 +1,344 @@
+// Package metrics implements counters, gauges and histograms exposed in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10 minutes.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// family is one named metric with its samples
+type family interface {
+	write(w io.Writer)
+}
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+// Handler serves the registries' metrics, in order, as one exposition.
+func Handler(registries ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, reg := range registries {
+			if reg != nil {
+				reg.WriteText(w)
+			}
+		}
+	})
+}
+
+// vec stores one value per distinct set of label values
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{name: name, help: help, kind: kind, labels: labels, values: make(map[string]*sample)}
+}
+
+// with returns the sample for the label values; callers hold v.mu
+func (v *vec) with(labelValues []string) *sample {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		v.values[key] = s
+	}
+	return s
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	v.mu.Lock()
+	v.with(labelValues).value += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	samples := make([]*sample, 0, len(v.values))
+	for _, s := range v.values {
+		samples = append(samples, &sample{labelValues: s.labelValues, value: s.value})
+	}
+	v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, v.kind)
+	sortSamples(samples)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), formatValue(s.value))
+	}
+}
+
+// CounterVec is a monotonically increasing value per label set.
+type CounterVec struct{ v *vec }
+
+// Counter registers a counter.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", labels)}
+	r.register(c.v)
+	return c
+}
+
+// Inc adds one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.v.add(1, labelValues)
+}
+
+// Add adds delta, which must not be negative.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.v.add(delta, labelValues)
+}
+
+// GaugeVec is a value per label set that can go up and down.
+type GaugeVec struct{ v *vec }
+
+// Gauge registers a gauge.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec(name, help, "gauge", labels)}
+	r.register(g.v)
+	return g
+}
+
+// Set sets the gauge.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.mu.Lock()
+	g.v.with(labelValues).value = value
+	g.v.mu.Unlock()
+}
+
+// Inc adds one.
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.v.add(1, labelValues)
+}
+
+// Dec subtracts one.
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.v.add(-1, labelValues)
+}
+
+// Emit reports one sample from a collect function.
+type Emit func(value float64, labelValues ...string)
+
+// collected is a family whose samples are gathered at scrape time
+type collected struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	collect func(emit Emit)
+}
+
+// GaugeFunc registers a gauge whose samples are produced by collect on every scrape.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func(emit Emit)) {
+	r.register(&collected{name: name, help: help, kind: "gauge", labels: labels, collect: collect})
+}
+
+// CounterFunc registers a counter whose samples are produced by collect on every scrape.
+func (r *Registry) CounterFunc(name, help string, labels []string, collect func(emit Emit)) {
+	r.register(&collected{name: name, help: help, kind: "counter", labels: labels, collect: collect})
+}
+
+func (c *collected) write(w io.Writer) {
+	var samples []*sample
+	c.collect(func(value float64, labelValues ...string) {
+		if len(labelValues) != len(c.labels) {
+			return
+		}
+		samples = append(samples, &sample{labelValues: labelValues, value: value})
+	})
+
+	writeHeader(w, c.name, c.help, c.kind)
+	sortSamples(samples)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues, "", ""), formatValue(s.value))
+	}
+}
+
+// HistogramVec counts observations into buckets per label set.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64 // Per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// Histogram registers a histogram. nil buckets use DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// Observe records one value.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if value <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	series := make([]histogram, 0, len(h.series))
+	for _, s := range h.series {
+		series = append(series, histogram{
+			labelValues: s.labelValues,
+			counts:      append([]uint64(nil), s.counts...),
+			count:       s.count,
+			sum:         s.sum,
+		})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return strings.Join(series[i].labelValues, "\xff") < strings.Join(series[j].labelValues, "\xff")
+	})
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, s := range series {
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	help = strings.ReplaceAll(help, "\n", `\n`)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders {a="x",b="y"}, plus an extra label if extraName is set
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", name, quote(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", extraName, quote(extraValue))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortSamples(samples []*sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labelValues, "\xff") < strings.Join(samples[j].labelValues, "\xff")
+	})
+}
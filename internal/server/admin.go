@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleReload rescans the tools directory: POST /v1/admin/reload
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := s.executor.Reload()
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.json(w, result)
+}
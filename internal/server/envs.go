@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleEnvs lists environments and the tools using them: GET /v1/envs
+func (s *Server) handleEnvs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	envs, err := s.manager.Envs()
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.json(w, envs)
+}
+
+// handlePruneEnvs removes environments no tool uses: POST /v1/envs/prune?dry_run=true
+func (s *Server) handlePruneEnvs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := s.executor.PruneEnvs(r.URL.Query().Get("dry_run") == "true")
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.json(w, result)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/calobozan/jb-serve/internal/tools"
+)
+
+func TestValidationErrorResponse(t *testing.T) {
+	schema := &config.Schema{
+		Type: "object",
+		Properties: map[string]*config.Schema{
+			"prompt": {Type: "string"},
+			"steps":  {Type: "integer"},
+		},
+		Required: []string{"prompt"},
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		field  string
+	}{
+		{"required field missing", map[string]interface{}{"steps": "3"}, "prompt"},
+		{"unknown field", map[string]interface{}{"prompt": "a cat", "stpes": float64(3)}, "stpes"},
+		{"wrong type", map[string]interface{}{"prompt": "a cat", "steps": "many"}, "steps"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.ValidateParams(schema, tt.params)
+			if err == nil {
+				t.Fatal("expected a validation error")
+			}
+
+			rec := httptest.NewRecorder()
+			(&Server{}).writeError(rec, tools.AsCallError(err, "sd", "generate"))
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want 422", rec.Code)
+			}
+
+			var body struct {
+				Error tools.CallError `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != tools.ErrCodeValidation || body.Error.Tool != "sd" || body.Error.Method != "generate" {
+				t.Fatalf("got %+v", body.Error)
+			}
+			if len(body.Error.Fields) != 1 || body.Error.Fields[0].Field != tt.field {
+				t.Fatalf("got fields %+v, want one for %s", body.Error.Fields, tt.field)
+			}
+		})
+	}
+}
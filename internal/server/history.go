@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/files"
+	"github.com/calobozan/jb-serve/internal/history"
+	"github.com/calobozan/jb-serve/internal/tools"
+)
+
+// recordCall appends a finished call to the call history. result is the wrapped
+// result returned to the caller; err is the call's error, if any.
+func (s *Server) recordCall(caller, toolName, methodName, mode string, params map[string]interface{}, started time.Time, result interface{}, err error) {
+	if s.history == nil {
+		return
+	}
+
+	c := &history.Call{
+		Tool:         toolName,
+		Method:       methodName,
+		Mode:         mode,
+		ParamsDigest: history.Digest(params),
+		Caller:       caller,
+		Status:       history.StatusOK,
+		StartedAt:    started.UnixMilli(),
+		DurationMS:   time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		callErr := tools.AsCallError(err, toolName, methodName)
+		c.Status = history.StatusError
+		c.ErrorCode = callErr.Code
+		c.ErrorType = callErr.Type
+	} else {
+		c.OutputFiles = outputRefs(result, nil)
+	}
+
+	if err := s.history.Record(c); err != nil {
+		log.Printf("Failed to record call %s.%s: %v", toolName, methodName, err)
+	}
+}
+
+// outputRefs collects the refs of file outputs in a wrapped result
+func outputRefs(result interface{}, refs []string) []string {
+	switch v := result.(type) {
+	case *files.FileRef:
+		refs = append(refs, v.Ref)
+	case map[string]interface{}:
+		for _, val := range v {
+			refs = outputRefs(val, refs)
+		}
+	case []interface{}:
+		for _, item := range v {
+			refs = outputRefs(item, refs)
+		}
+	}
+	return refs
+}
+
+// callerIdentity identifies who made a request from how it authenticated, since
+// headers a client sets can say anything: "cert:<common name>" for a verified
+// client certificate, "token" for the auth token, each with the client address, or
+// just the address when auth is off. Calls the broker relays with the token it
+// minted for this server are attributed to the address the broker saw.
+func (s *Server) callerIdentity(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		auth = r.URL.Query().Get("token")
+	}
+
+	switch {
+	case s.isBrokerToken(auth):
+		addr := r.RemoteAddr
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			addr = strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+		return hostOf(addr) + " via broker"
+	case s.verifiedPeer(r):
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName + "@" + hostOf(r.RemoteAddr)
+	case s.cfg.AuthToken != "":
+		return "token@" + hostOf(r.RemoteAddr)
+	}
+	return hostOf(r.RemoteAddr)
+}
+
+// hostOf strips the port from a client address
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// handleCalls lists call history:
+// GET /v1/calls?tool=whisper&method=transcribe&status=error&since=1h&until=...&limit=50
+func (s *Server) handleCalls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.history == nil {
+		s.jsonError(w, "Call history not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := history.Filter{
+		Tool:   q.Get("tool"),
+		Method: q.Get("method"),
+		Status: q.Get("status"),
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(q.Get("since")); err != nil {
+		s.jsonError(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseTimeParam(q.Get("until")); err != nil {
+		s.jsonError(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			s.jsonError(w, "invalid limit: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+
+	calls, err := s.history.List(filter)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.json(w, calls)
+}
+
+// parseTimeParam accepts an RFC 3339 timestamp, Unix seconds, or a duration
+// meaning "that long ago" (e.g. "1h", "30m"). Empty gives the zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a timestamp, Unix time or duration", value)
+}
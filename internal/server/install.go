@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/calobozan/jb-serve/internal/tools"
+)
+
+// installRequest is the body of POST /v1/tools
+type installRequest struct {
+	Source string            `json:"source"`         // Git URL (optionally url@ref) or absolute path on the server
+	Lock   *tools.LockedTool `json:"lock,omitempty"` // Install exactly this lockfile entry
+}
+
+// handleInstall installs a tool: POST /v1/tools
+func (s *Server) handleInstall(w http.ResponseWriter, r *http.Request) {
+	var req installRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Source == "" && req.Lock != nil {
+		req.Source = req.Lock.PinnedSource()
+	}
+	if req.Source == "" {
+		s.jsonError(w, "source is required", http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(req.Source, ".") {
+		s.jsonError(w, "local paths must be absolute", http.StatusBadRequest)
+		return
+	}
+
+	s.runInstallOp(w, r, http.StatusCreated, func(out io.Writer) (string, error) {
+		tool, err := s.executor.Install(req.Source, req.Lock, out)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Installed %s from %s", tool.Name, req.Source)
+		return tool.Name, nil
+	})
+}
+
+// handleLock returns the provenance of every installed tool: GET /v1/lock
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lock, err := s.manager.Lockfile()
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.json(w, lock)
+}
+
+// handleUninstall removes a tool: DELETE /v1/tools/{name}?remove_env=true
+func (s *Server) handleUninstall(w http.ResponseWriter, r *http.Request, toolName string) {
+	removeEnv := r.URL.Query().Get("remove_env") == "true"
+	if err := s.executor.Uninstall(toolName, removeEnv); err != nil {
+		s.writeError(w, tools.AsCallError(err, toolName, ""))
+		return
+	}
+	log.Printf("Uninstalled %s", toolName)
+	s.json(w, map[string]string{"status": "uninstalled", "tool": toolName})
+}
+
+// handleUpgrade re-pulls and reinstalls a tool: POST /v1/tools/{name}/upgrade
+func (s *Server) handleUpgrade(w http.ResponseWriter, r *http.Request, toolName string) {
+	s.runInstallOp(w, r, http.StatusOK, func(out io.Writer) (string, error) {
+		tool, err := s.executor.Upgrade(toolName, out)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Upgraded %s to v%s", tool.Name, tool.Manifest.Version)
+		return tool.Name, nil
+	})
+}
+
+// handleBundle exports a tool and its wheels for offline installs into the
+// server's bundles directory: POST /v1/tools/{name}/bundle
+func (s *Server) handleBundle(w http.ResponseWriter, r *http.Request, toolName string) {
+	s.runLoggedOp(w, r, http.StatusOK, func(out io.Writer) (interface{}, error) {
+		result, err := s.executor.Bundle(toolName, out)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Bundled %s to %s", toolName, result.Path)
+		return result, nil
+	})
+}
+
+// handleBundleDownload sends the tool's last bundle: GET /v1/tools/{name}/bundle
+func (s *Server) handleBundleDownload(w http.ResponseWriter, r *http.Request, toolName string) {
+	f, err := os.Open(s.manager.BundlePath(toolName))
+	if err != nil {
+		s.writeError(w, &tools.CallError{
+			Code:    tools.ErrCodeNotFound,
+			Message: "no bundle for " + toolName + "; create one with POST /v1/tools/" + toolName + "/bundle",
+			Tool:    toolName,
+		})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", toolName+".tar.gz"))
+	http.ServeContent(w, r, toolName+".tar.gz", info.ModTime(), f)
+}
+
+// handleRebuild recreates a tool's environment: POST /v1/tools/{name}/rebuild
+func (s *Server) handleRebuild(w http.ResponseWriter, r *http.Request, toolName string) {
+	s.runInstallOp(w, r, http.StatusOK, func(out io.Writer) (string, error) {
+		tool, err := s.executor.Rebuild(toolName, out)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Rebuilt environment for %s", tool.Name)
+		return tool.Name, nil
+	})
+}
+
+// runInstallOp runs an install, upgrade or rebuild and responds with the tool's
+// info, as runLoggedOp.
+func (s *Server) runInstallOp(w http.ResponseWriter, r *http.Request, status int, op func(out io.Writer) (string, error)) {
+	s.runLoggedOp(w, r, status, func(out io.Writer) (interface{}, error) {
+		name, err := op(out)
+		if err != nil {
+			return nil, err
+		}
+		info, _ := s.manager.Info(name)
+		return info, nil
+	})
+}
+
+// runLoggedOp runs a long operation and responds with its result. With
+// ?stream=true (or Accept: text/event-stream) the git, micromamba, pip and setup
+// output is relayed as "log" events, ending with a "result" or "error" event;
+// otherwise it goes to the server log.
+func (s *Server) runLoggedOp(w http.ResponseWriter, r *http.Request, status int, op func(out io.Writer) (interface{}, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !wantsStream(r) || !ok {
+		result, err := op(log.Writer())
+		if err != nil {
+			s.writeError(w, tools.AsCallError(err, "", ""))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	es := &eventStream{w: w, flusher: flusher}
+	out := &logLineWriter{send: func(line string) { es.send("log", line) }}
+	result, err := op(out)
+	out.Flush()
+	if err != nil {
+		callErr := tools.AsCallError(err, "", "")
+		if !es.started {
+			s.writeError(w, callErr)
+			return
+		}
+		es.send("error", callErr)
+		return
+	}
+	es.send("result", result)
+}
+
+// logLineWriter splits written output into lines. Carriage returns also end a
+// line so git and pip progress meters come through as separate updates.
+type logLineWriter struct {
+	send func(line string)
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lw *logLineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	for _, b := range p {
+		if b == '\n' || b == '\r' {
+			lw.emit()
+			continue
+		}
+		lw.buf.WriteByte(b)
+	}
+	return len(p), nil
+}
+
+// Flush sends any partial final line.
+func (lw *logLineWriter) Flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.emit()
+}
+
+// emit sends the buffered line, skipping blank ones; callers hold lw.mu
+func (lw *logLineWriter) emit() {
+	line := strings.TrimRight(lw.buf.String(), " \t")
+	lw.buf.Reset()
+	if line != "" {
+		lw.send(line)
+	}
+}
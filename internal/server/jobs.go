@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/calobozan/jb-serve/internal/jobs"
+	"github.com/calobozan/jb-serve/internal/tools"
+)
+
+// submitJob starts a call in the background and responds 202 with the new job
+func (s *Server) submitJob(w http.ResponseWriter, caller, toolName, methodName string, params map[string]interface{}, method config.Method, timeout time.Duration, tempFiles []string) {
+	if s.jobs == nil {
+		s.writeError(w, &tools.CallError{
+			Code:    tools.ErrCodeUnavailable,
+			Message: "job table not available",
+			Tool:    toolName,
+			Method:  methodName,
+		})
+		return
+	}
+
+	job, err := s.jobs.Submit(toolName, methodName, timeout, func(ctx context.Context, progress func(interface{})) (interface{}, error) {
+		if len(tempFiles) > 0 && s.files != nil {
+			defer s.files.CleanupAll(tempFiles)
+		}
+
+		started := time.Now()
+		result, err := s.executor.CallStream(ctx, toolName, methodName, params, func(ev tools.StreamEvent) error {
+			if ev.Type == "progress" {
+				progress(ev.Data)
+			}
+			return nil
+		})
+		if err != nil {
+			s.recordCall(caller, toolName, methodName, "async", params, started, nil, err)
+			return nil, tools.AsCallError(err, toolName, methodName)
+		}
+		wrapped := s.wrapFileOutputs(toolName, result, method)
+		s.recordCall(caller, toolName, methodName, "async", params, started, wrapped, nil)
+		return wrapped, nil
+	})
+	if err != nil {
+		if len(tempFiles) > 0 && s.files != nil {
+			s.files.CleanupAll(tempFiles)
+		}
+		s.writeError(w, tools.AsCallError(err, toolName, methodName))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	s.json(w, job)
+}
+
+// handleJobs lists jobs: GET /v1/jobs?status=running&tool=whisper&limit=50
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.jobs == nil {
+		s.jsonError(w, "Job table not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	list, err := s.jobs.List(q.Get("status"), q.Get("tool"), limit)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.json(w, list)
+}
+
+// handleJob handles GET (status) and DELETE (cancel) on /v1/jobs/{id}
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		s.jsonError(w, "Job table not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+	if id == "" {
+		s.handleJobs(w, r)
+		return
+	}
+
+	var job *jobs.Job
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		job, err = s.jobs.Get(id)
+	case http.MethodDelete:
+		job, err = s.jobs.Cancel(id)
+	default:
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		s.writeError(w, &tools.CallError{Code: tools.ErrCodeNotFound, Message: "job not found: " + id})
+	case errors.Is(err, jobs.ErrFinished):
+		s.writeError(w, &tools.CallError{
+			Code:    tools.ErrCodeJobFinished,
+			Message: "job " + id + " already " + job.Status,
+			Tool:    job.Tool,
+			Method:  job.Method,
+		})
+	case err != nil:
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+	default:
+		s.json(w, job)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/calobozan/jb-serve/internal/metrics"
+)
+
+// setupMetrics registers server-side metrics; call and tool metrics come from the executor
+func (s *Server) setupMetrics() {
+	if s.filestore == nil {
+		return
+	}
+
+	s.metrics.GaugeFunc("jb_filestore_files", "Files in the persistent file store.", nil,
+		func(emit metrics.Emit) {
+			if files, _, err := s.filestore.Stats(); err == nil {
+				emit(float64(files))
+			}
+		})
+	s.metrics.GaugeFunc("jb_filestore_bytes", "Total size of files in the persistent file store.", nil,
+		func(emit metrics.Emit) {
+			if _, size, err := s.filestore.Stats(); err == nil {
+				emit(float64(size))
+			}
+		})
+	s.metrics.CounterFunc("jb_filestore_gc_deleted_total", "Expired files removed by the file store GC.", nil,
+		func(emit metrics.Emit) {
+			emit(float64(s.filestore.GCDeleted()))
+		})
+}
+
+// executorMetrics returns the executor's registry, or nil without an executor
+func (s *Server) executorMetrics() *metrics.Registry {
+	if s.executor == nil {
+		return nil
+	}
+	return s.executor.Metrics()
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/calobozan/jb-serve/internal/filestore"
+)
+
+// pullRequest names a file on another jb-serve node to copy into the local store:
+// a stored file by ID, or an output file by its /v1/files/ name
+type pullRequest struct {
+	Source string `json:"source"`         // Base URL of the node holding the file
+	Auth   string `json:"auth,omitempty"` // Authorization to present to it, default: this node's auth token
+	ID     string `json:"id,omitempty"`
+	Ref    string `json:"ref,omitempty"` // Output file name, e.g. "43af6f50.png"
+	TTL    int64  `json:"ttl,omitempty"` // Seconds to keep an imported output file, 0 = permanent
+}
+
+// handleStorePull copies a file from another node into the local store:
+// POST /v1/store/pull. A stored file keeps its ID, so a call naming it works the
+// same on any node; an output file is imported under a new one. Content already
+// in the store (by SHA256) isn't transferred again.
+func (s *Server) handleStorePull(w http.ResponseWriter, r *http.Request) {
+	if s.filestore == nil {
+		s.jsonError(w, "File store not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req pullRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	source, err := url.Parse(req.Source)
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		s.jsonError(w, "source must be an http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if (req.ID == "") == (req.Ref == "") {
+		s.jsonError(w, "exactly one of id and ref is required", http.StatusBadRequest)
+		return
+	}
+
+	var info *filestore.FileInfo
+	if req.ID != "" {
+		info, err = s.pullStored(r, req)
+	} else {
+		info, err = s.pullOutput(r, req)
+	}
+	if err != nil {
+		s.jsonError(w, "Pull failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	s.json(w, info)
+}
+
+// pullStored replicates a stored file from the source node under the same ID
+func (s *Server) pullStored(r *http.Request, req pullRequest) (*filestore.FileInfo, error) {
+	if info, err := s.filestore.Info(req.ID); err == nil {
+		return info, nil
+	}
+
+	base := strings.TrimSuffix(req.Source, "/") + "/v1/store/" + url.PathEscape(req.ID)
+	resp, err := s.fetch(r, req, base)
+	if err != nil {
+		return nil, err
+	}
+	var remote filestore.FileInfo
+	err = json.NewDecoder(resp.Body).Decode(&remote)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("bad file info from %s: %w", req.Source, err)
+	}
+	if remote.ID != req.ID {
+		return nil, fmt.Errorf("%s returned file %q for %q", req.Source, remote.ID, req.ID)
+	}
+
+	return s.filestore.Replicate(&remote, func() (io.ReadCloser, error) {
+		resp, err := s.fetch(r, req, base+"/content")
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	})
+}
+
+// pullOutput imports an output file into the store, from the local outputs if
+// it was produced here, otherwise downloaded from the source node
+func (s *Server) pullOutput(r *http.Request, req pullRequest) (*filestore.FileInfo, error) {
+	name := filepath.Base(req.Ref)
+	if name != req.Ref {
+		return nil, fmt.Errorf("invalid output file name: %s", req.Ref)
+	}
+
+	if s.files != nil {
+		if path := s.files.GetOutputPath(name); isFilePath(path) {
+			return s.filestore.ImportShared(path, name, req.TTL)
+		}
+	}
+
+	resp, err := s.fetch(r, req, strings.TrimSuffix(req.Source, "/")+"/v1/files/"+url.PathEscape(name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp("", "jb-pull-*"+filepath.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	return s.filestore.ImportShared(tmp.Name(), name, req.TTL)
+}
+
+// fetch GETs a URL on the node a pull names, with the credentials it gave or
+// else this node's auth token. Anything but 200 is an error.
+func (s *Server) fetch(r *http.Request, pull pullRequest, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pull.Auth != "" {
+		req.Header.Set("Authorization", pull.Auth)
+	} else if s.cfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.AuthToken)
+	}
+	resp, err := s.peers.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned status %d", target, resp.StatusCode)
+	}
+	return resp, nil
+}
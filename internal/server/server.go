@@ -1,6 +1,9 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,10 +12,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/calobozan/jb-serve/internal/config"
 	"github.com/calobozan/jb-serve/internal/files"
 	"github.com/calobozan/jb-serve/internal/filestore"
+	"github.com/calobozan/jb-serve/internal/history"
+	"github.com/calobozan/jb-serve/internal/jobs"
+	"github.com/calobozan/jb-serve/internal/metrics"
 	"github.com/calobozan/jb-serve/internal/tools"
 )
 
@@ -23,13 +30,25 @@ type Server struct {
 	executor  *tools.Executor
 	files     *files.Manager
 	filestore *filestore.Store
+	jobs      *jobs.Manager
+	history   *history.Store
+	metrics   *metrics.Registry
 	mux       *http.ServeMux
+
+	tls         *tls.Config   // mTLS listener config, nil if not configured
+	tlsPort     int           // Port for the mTLS listener
+	peers       *http.Client  // For fetching files from other nodes
+	brokerToken func() string // Token the broker minted for this server, accepted like the auth token
 }
 
 // Options configures the server
 type Options struct {
 	FileStorePath    string // Custom path for file store (empty = use base dir)
 	FileStoreDisable bool   // Disable file store entirely
+
+	TLS     *tls.Config // Serve mutual TLS for the broker and other nodes; the plain API then listens on loopback only
+	TLSPort int         // Port for the mTLS listener
+	PeerTLS *tls.Config // Client config for connecting to other nodes over mTLS
 }
 
 // New creates a new API server with default options
@@ -61,14 +80,40 @@ func NewWithOptions(cfg *config.Config, manager *tools.Manager, executor *tools.
 		log.Printf("File store disabled")
 	}
 
+	// Background job table
+	jobMgr, err := jobs.New(cfg.BaseDir(), time.Duration(cfg.JobRetentionHours)*time.Hour)
+	if err != nil {
+		log.Printf("Warning: failed to open job table: %v", err)
+		jobMgr = nil
+	}
+
+	// Call history
+	historyStore, err := history.New(cfg.BaseDir(), time.Duration(cfg.HistoryRetentionDays)*24*time.Hour)
+	if err != nil {
+		log.Printf("Warning: failed to open call history: %v", err)
+		historyStore = nil
+	}
+
 	s := &Server{
 		cfg:       cfg,
 		manager:   manager,
 		executor:  executor,
 		files:     fileMgr,
 		filestore: store,
+		jobs:      jobMgr,
+		history:   historyStore,
+		metrics:   metrics.NewRegistry(),
 		mux:       http.NewServeMux(),
+		tls:       opts.TLS,
+		tlsPort:   opts.TLSPort,
+		peers:     &http.Client{},
 	}
+	if opts.PeerTLS != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = opts.PeerTLS.Clone()
+		s.peers.Transport = transport
+	}
+	s.setupMetrics()
 	s.setupRoutes()
 	return s
 }
@@ -76,10 +121,20 @@ func NewWithOptions(cfg *config.Config, manager *tools.Manager, executor *tools.
 func (s *Server) setupRoutes() {
 	s.mux.HandleFunc("/v1/tools", s.handleTools)
 	s.mux.HandleFunc("/v1/tools/", s.handleTool)
+	s.mux.HandleFunc("/v1/lock", s.handleLock)
+	s.mux.HandleFunc("/v1/envs", s.handleEnvs)
+	s.mux.HandleFunc("/v1/envs/prune", s.handlePruneEnvs)
 	s.mux.HandleFunc("/v1/files/", s.handleFiles)
+	s.mux.HandleFunc("/v1/resources", s.handleResources)
+	s.mux.HandleFunc("/v1/jobs", s.handleJobs)
+	s.mux.HandleFunc("/v1/jobs/", s.handleJob)
+	s.mux.HandleFunc("/v1/calls", s.handleCalls)
+	s.mux.HandleFunc("/v1/admin/reload", s.handleReload)
 	s.mux.HandleFunc("/v1/store", s.handleStore)
 	s.mux.HandleFunc("/v1/store/", s.handleStoreItem)
+	s.mux.HandleFunc("/v1/store/pull", s.handleStorePull)
 	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.Handle("/metrics", metrics.Handler(s.executorMetrics(), s.metrics))
 }
 
 // ListenAndServe starts the server
@@ -88,13 +143,38 @@ func (s *Server) ListenAndServe(port int) error {
 	if s.executor != nil {
 		s.executor.SetServerPort(port)
 	}
-	addr := fmt.Sprintf(":%d", port)
-	log.Printf("jb-serve API listening on %s", addr)
-	return http.ListenAndServe(addr, s.authMiddleware(s.mux))
+	handler := s.authMiddleware(s.mux)
+	if s.tls == nil {
+		addr := fmt.Sprintf(":%d", port)
+		log.Printf("jb-serve API listening on %s", addr)
+		return http.ListenAndServe(addr, handler)
+	}
+
+	// Remote traffic must use mTLS; local tools and the CLI keep plain HTTP
+	errCh := make(chan error, 2)
+	local := fmt.Sprintf("127.0.0.1:%d", port)
+	secure := &http.Server{Addr: fmt.Sprintf(":%d", s.tlsPort), Handler: handler, TLSConfig: s.tls}
+	log.Printf("jb-serve API listening on %s and %s (mTLS)", local, secure.Addr)
+	go func() { errCh <- http.ListenAndServe(local, handler) }()
+	go func() { errCh <- secure.ListenAndServeTLS("", "") }()
+	return <-errCh
+}
+
+// AcceptBrokerToken lets in requests bearing the token fn returns: the one the
+// broker minted when this server registered, which it presents when calling on
+// its own authority
+func (s *Server) AcceptBrokerToken(fn func() string) {
+	s.brokerToken = fn
 }
 
 // Close cleans up server resources
 func (s *Server) Close() error {
+	if s.jobs != nil {
+		s.jobs.Close()
+	}
+	if s.history != nil {
+		s.history.Close()
+	}
 	if s.filestore != nil {
 		return s.filestore.Close()
 	}
@@ -108,14 +188,14 @@ func (s *Server) FileStore() *filestore.Store {
 
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.cfg.AuthToken != "" {
+		if s.cfg.AuthToken != "" && !s.verifiedPeer(r) {
 			token := r.Header.Get("Authorization")
 			if token == "" {
 				token = r.URL.Query().Get("token")
 			}
 			expected := "Bearer " + s.cfg.AuthToken
-			if token != expected && token != s.cfg.AuthToken {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			if token != expected && token != s.cfg.AuthToken && !s.isBrokerToken(token) {
+				s.jsonError(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 		}
@@ -123,13 +203,41 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// verifiedPeer reports whether a request came over mTLS with a certificate signed
+// by the cluster CA: the broker or another node
+func (s *Server) verifiedPeer(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// isBrokerToken reports whether an Authorization value carries the token the
+// broker minted for this server
+func (s *Server) isBrokerToken(auth string) bool {
+	if s.brokerToken == nil {
+		return false
+	}
+	token := s.brokerToken()
+	return token != "" && subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	s.json(w, map[string]string{"status": "ok"})
 }
 
+func (s *Server) handleResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.json(w, s.executor.Resources())
+}
+
 func (s *Server) handleTools(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.handleInstall(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -142,6 +250,7 @@ func (s *Server) handleTools(w http.ResponseWriter, r *http.Request) {
 		Mode         string   `json:"mode,omitempty"`
 		Status       string   `json:"status"`
 		HealthStatus string   `json:"health_status,omitempty"`
+		NeedsRebuild bool     `json:"needs_rebuild,omitempty"`
 		Methods      []string `json:"methods,omitempty"`
 	}
 
@@ -178,6 +287,7 @@ func (s *Server) handleTools(w http.ResponseWriter, r *http.Request) {
 			Mode:         t.Manifest.Runtime.Mode,
 			Status:       t.Status,
 			HealthStatus: t.HealthStatus,
+			NeedsRebuild: s.manager.NeedsRebuild(t),
 			Methods:      methods,
 		})
 	}
@@ -192,18 +302,26 @@ func (s *Server) handleTool(w http.ResponseWriter, r *http.Request) {
 	toolName := parts[0]
 	tool, ok := s.manager.Get(toolName)
 	if !ok {
-		http.Error(w, "Tool not found", http.StatusNotFound)
+		s.writeError(w, &tools.CallError{
+			Code:    tools.ErrCodeToolNotFound,
+			Message: "tool not found: " + toolName,
+			Tool:    toolName,
+		})
 		return
 	}
 
-	// GET /v1/tools/{name}
+	// GET /v1/tools/{name}, DELETE /v1/tools/{name}
 	if len(parts) == 1 || parts[1] == "" {
 		if r.Method == http.MethodGet {
 			info, _ := s.manager.Info(toolName)
 			s.json(w, info)
 			return
 		}
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		if r.Method == http.MethodDelete {
+			s.handleUninstall(w, r, toolName)
+			return
+		}
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -218,11 +336,15 @@ func (s *Server) handleTool(w http.ResponseWriter, r *http.Request) {
 	// POST /v1/tools/{name}/start - start a persistent tool
 	if action == "start" && r.Method == http.MethodPost {
 		if tool.Manifest.Runtime.Mode != "persistent" {
-			s.json(w, map[string]string{"error": "tool is not a persistent tool"})
+			s.writeError(w, &tools.CallError{
+				Code:    tools.ErrCodeNotPersistent,
+				Message: "tool is not a persistent tool",
+				Tool:    toolName,
+			})
 			return
 		}
 		if err := s.executor.Start(toolName); err != nil {
-			s.json(w, map[string]string{"error": err.Error()})
+			s.writeError(w, tools.AsCallError(err, toolName, ""))
 			return
 		}
 		s.json(w, map[string]string{"status": "started", "tool": toolName})
@@ -232,46 +354,120 @@ func (s *Server) handleTool(w http.ResponseWriter, r *http.Request) {
 	// POST /v1/tools/{name}/stop - stop a persistent tool
 	if action == "stop" && r.Method == http.MethodPost {
 		if err := s.executor.Stop(toolName); err != nil {
-			s.json(w, map[string]string{"error": err.Error()})
+			s.writeError(w, tools.AsCallError(err, toolName, ""))
 			return
 		}
 		s.json(w, map[string]string{"status": "stopped", "tool": toolName})
 		return
 	}
 
+	// POST /v1/tools/{name}/upgrade - pull and reinstall the latest version
+	if action == "upgrade" && r.Method == http.MethodPost {
+		s.handleUpgrade(w, r, toolName)
+		return
+	}
+
+	// POST /v1/tools/{name}/rebuild - recreate the environment and rerun setup
+	if action == "rebuild" && r.Method == http.MethodPost {
+		s.handleRebuild(w, r, toolName)
+		return
+	}
+
+	// POST /v1/tools/{name}/bundle - export the tool for offline installs
+	// GET /v1/tools/{name}/bundle - download the bundle
+	if action == "bundle" && r.Method == http.MethodPost {
+		s.handleBundle(w, r, toolName)
+		return
+	}
+	if action == "bundle" && r.Method == http.MethodGet {
+		s.handleBundleDownload(w, r, toolName)
+		return
+	}
+
 	// POST /v1/tools/{name}/{method} - call a method
 	if r.Method == http.MethodPost {
 		method, ok := tool.Manifest.RPC.Methods[action]
 		if !ok {
-			http.Error(w, "Method not found", http.StatusNotFound)
+			s.writeError(w, &tools.CallError{
+				Code:    tools.ErrCodeMethodNotFound,
+				Message: "method not found: " + action,
+				Tool:    toolName,
+				Method:  action,
+			})
 			return
 		}
 
-		params, tempFiles, err := s.parseRequestParams(r, method)
+		params, tempFiles, err := s.parseRequestParams(r, toolName, method)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, &tools.CallError{
+				Code:    tools.ErrCodeBadRequest,
+				Message: err.Error(),
+				Tool:    toolName,
+				Method:  action,
+			})
 			return
 		}
 
-		// Ensure temp files are cleaned up after the call
-		if len(tempFiles) > 0 && s.files != nil {
+		// Ensure temp files are cleaned up after the call (async jobs clean up when they finish)
+		async := r.URL.Query().Get("async") == "true"
+		if len(tempFiles) > 0 && s.files != nil && !async {
 			defer s.files.CleanupAll(tempFiles)
 		}
 
-		result, err := s.executor.Call(toolName, action, params)
+		// Per-request timeout override; the request context also cancels on client disconnect
+		ctx := r.Context()
+		timeout, err := requestTimeout(r)
 		if err != nil {
-			s.json(w, map[string]string{"error": err.Error()})
+			s.writeError(w, &tools.CallError{
+				Code:    tools.ErrCodeBadRequest,
+				Message: err.Error(),
+				Tool:    toolName,
+				Method:  action,
+			})
+			return
+		}
+
+		// Run in the background and hand back a job ID. The executor validates
+		// every call; asking it up front turns bad params into a 400, not a failed job.
+		if async {
+			if params, err = s.executor.Validate(toolName, action, params); err != nil {
+				s.writeError(w, tools.AsCallError(err, toolName, action))
+				return
+			}
+			s.submitJob(w, s.callerIdentity(r), toolName, action, params, method, timeout, tempFiles)
+			return
+		}
+
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		// Relay incremental output as Server-Sent Events if the client asked for it
+		started := time.Now()
+		if wantsStream(r) {
+			result, err := s.streamCall(ctx, w, toolName, action, params, method)
+			s.recordCall(s.callerIdentity(r), toolName, action, "stream", params, started, result, err)
+			return
+		}
+
+		result, err := s.executor.Call(ctx, toolName, action, params)
+		if err != nil {
+			s.recordCall(s.callerIdentity(r), toolName, action, "sync", params, started, nil, err)
+			s.writeError(w, tools.AsCallError(err, toolName, action))
 			return
 		}
 
 		// Wrap file outputs with refs
-		wrappedResult := s.wrapFileOutputs(result, method)
+		wrappedResult := s.wrapFileOutputs(toolName, result, method)
+		s.recordCall(s.callerIdentity(r), toolName, action, "sync", params, started, wrappedResult, nil)
 
 		s.json(w, wrappedResult)
 		return
 	}
 
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
 func (s *Server) json(w http.ResponseWriter, data interface{}) {
@@ -280,8 +476,9 @@ func (s *Server) json(w http.ResponseWriter, data interface{}) {
 }
 
 // wrapFileOutputs walks through a result and converts file paths to FileRefs
-// It looks for string values that are valid file paths and converts them
-func (s *Server) wrapFileOutputs(result interface{}, method config.Method) interface{} {
+// It looks for string values that are valid file paths and converts them.
+// Paths outside a sandboxed tool's writable directories are left as they are.
+func (s *Server) wrapFileOutputs(toolName string, result interface{}, method config.Method) interface{} {
 	if s.files == nil {
 		return result
 	}
@@ -295,21 +492,26 @@ func (s *Server) wrapFileOutputs(result interface{}, method config.Method) inter
 		for key, val := range v {
 			// Check if this field is marked as type: file in schema
 			if fileFields[key] {
-				if path, ok := val.(string); ok && isFilePath(path) {
-					if ref, err := s.files.RegisterOutput(path); err == nil {
-						wrapped[key] = ref
-						continue
+				if path, ok := val.(string); ok && strings.HasPrefix(path, "/") {
+					src, err := s.manager.OutputPath(toolName, path)
+					if err != nil {
+						log.Printf("Not serving output %s of %s: %v", key, toolName, err)
+					} else if isFilePath(src) {
+						if ref, err := s.files.RegisterOutput(src); err == nil {
+							wrapped[key] = ref
+							continue
+						}
 					}
 				}
 			}
 			// Recursively wrap nested maps
-			wrapped[key] = s.wrapFileOutputs(val, method)
+			wrapped[key] = s.wrapFileOutputs(toolName, val, method)
 		}
 		return wrapped
 	case []interface{}:
 		wrapped := make([]interface{}, len(v))
 		for i, item := range v {
-			wrapped[i] = s.wrapFileOutputs(item, method)
+			wrapped[i] = s.wrapFileOutputs(toolName, item, method)
 		}
 		return wrapped
 	default:
@@ -345,7 +547,7 @@ func getOutputFileFields(method config.Method) map[string]bool {
 // handleFiles serves output files
 func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
 	if s.files == nil {
-		http.Error(w, "File serving not configured", http.StatusServiceUnavailable)
+		s.jsonError(w, "File serving not configured", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -357,12 +559,12 @@ func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
 			s.json(w, s.files.ListOutputs())
 			return
 		}
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	// Serve file
-	if r.Method == http.MethodGet {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
 		filePath := s.files.GetOutputPath(filename)
 		http.ServeFile(w, r, filePath)
 		return
@@ -373,20 +575,20 @@ func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
 		// Extract ref from filename (remove extension)
 		ref := strings.TrimSuffix(filename, filepath.Ext(filename))
 		if err := s.files.DeleteOutput(ref); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			s.jsonError(w, err.Error(), http.StatusNotFound)
 			return
 		}
 		s.json(w, map[string]string{"status": "deleted"})
 		return
 	}
 
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
 // handleStore handles /v1/store (list, import, stats)
 func (s *Server) handleStore(w http.ResponseWriter, r *http.Request) {
 	if s.filestore == nil {
-		http.Error(w, "File store not configured", http.StatusServiceUnavailable)
+		s.jsonError(w, "File store not configured", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -471,14 +673,14 @@ func (s *Server) handleStore(w http.ResponseWriter, r *http.Request) {
 		s.json(w, info)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // handleStoreItem handles /v1/store/{id} (get, info, rename, delete, content)
 func (s *Server) handleStoreItem(w http.ResponseWriter, r *http.Request) {
 	if s.filestore == nil {
-		http.Error(w, "File store not configured", http.StatusServiceUnavailable)
+		s.jsonError(w, "File store not configured", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -488,7 +690,7 @@ func (s *Server) handleStoreItem(w http.ResponseWriter, r *http.Request) {
 	id := parts[0]
 
 	if id == "" {
-		http.Error(w, "ID required", http.StatusBadRequest)
+		s.jsonError(w, "ID required", http.StatusBadRequest)
 		return
 	}
 
@@ -558,19 +760,95 @@ func (s *Server) handleStoreItem(w http.ResponseWriter, r *http.Request) {
 		s.json(w, map[string]string{"status": "deleted", "id": id})
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) jsonError(w http.ResponseWriter, message string, code int) {
+// jsonError writes the standard error envelope for a plain message and HTTP status
+func (s *Server) jsonError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": &tools.CallError{Code: codeForStatus(status), Message: message},
+	})
+}
+
+// writeError writes the standard error envelope with a status derived from the error code
+func (s *Server) writeError(w http.ResponseWriter, err *tools.CallError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusForCode(err.Code))
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": err})
+}
+
+// statusForCode maps a stable error code to its HTTP status
+func statusForCode(code string) int {
+	switch code {
+	case tools.ErrCodeToolNotFound, tools.ErrCodeMethodNotFound, tools.ErrCodeNotFound:
+		return http.StatusNotFound
+	case tools.ErrCodeAlreadyRunning, tools.ErrCodeNotRunning, tools.ErrCodeJobFinished, tools.ErrCodeAlreadyInstalled:
+		return http.StatusConflict
+	case tools.ErrCodeValidation:
+		return http.StatusUnprocessableEntity
+	case tools.ErrCodeBadRequest, tools.ErrCodeNotPersistent:
+		return http.StatusBadRequest
+	case tools.ErrCodeUnauthorized:
+		return http.StatusUnauthorized
+	case tools.ErrCodeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case tools.ErrCodeToolException:
+		return http.StatusBadGateway
+	case tools.ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	case tools.ErrCodeQueueFull:
+		return http.StatusTooManyRequests
+	case tools.ErrCodeCanceled:
+		return 499 // Client closed request
+	case tools.ErrCodeUnavailable, tools.ErrCodeInsufficientResources:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// codeForStatus maps an HTTP status to a generic error code
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return tools.ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return tools.ErrCodeUnauthorized
+	case http.StatusNotFound:
+		return tools.ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return tools.ErrCodeMethodNotAllowed
+	case http.StatusServiceUnavailable:
+		return tools.ErrCodeUnavailable
+	default:
+		return tools.ErrCodeInternal
+	}
+}
+
+// requestTimeout reads a per-call timeout in seconds from the "timeout" query param
+// or the X-Timeout header. Returns 0 if neither is set.
+func requestTimeout(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		value = r.Header.Get("X-Timeout")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q: must be a positive number of seconds", value)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
 }
 
 // parseRequestParams extracts parameters from JSON or multipart form data
 // Returns params map, list of temp file paths to clean up, and any error
-func (s *Server) parseRequestParams(r *http.Request, method config.Method) (map[string]interface{}, []string, error) {
+func (s *Server) parseRequestParams(r *http.Request, toolName string, method config.Method) (map[string]interface{}, []string, error) {
 	params := make(map[string]interface{})
 	var tempFiles []string
 
@@ -598,8 +876,8 @@ func (s *Server) parseRequestParams(r *http.Request, method config.Method) (map[
 			}
 			defer file.Close()
 
-			// Save to temp and get path
-			path, err := s.files.SaveUpload(file, header)
+			// Save to the tool's upload directory and get path
+			path, err := s.files.SaveToolUpload(toolName, file, header)
 			if err != nil {
 				// Clean up any files we've already saved
 				s.files.CleanupAll(tempFiles)
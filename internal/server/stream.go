@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/calobozan/jb-serve/internal/tools"
+)
+
+// wantsStream reports whether a call request asked for Server-Sent Events
+func wantsStream(r *http.Request) bool {
+	if r.URL.Query().Get("stream") == "true" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// streamCall runs a method and relays its chunk, progress and log events as
+// Server-Sent Events, ending with a "result" or "error" event. Errors raised before
+// the first event get a normal JSON error response instead. Returns the wrapped
+// result or the call's error.
+func (s *Server) streamCall(ctx context.Context, w http.ResponseWriter, toolName, methodName string, params map[string]interface{}, method config.Method) (interface{}, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		callErr := &tools.CallError{
+			Code:    tools.ErrCodeInternal,
+			Message: "streaming not supported by this connection",
+			Tool:    toolName,
+			Method:  methodName,
+		}
+		s.writeError(w, callErr)
+		return nil, callErr
+	}
+
+	es := &eventStream{w: w, flusher: flusher}
+	result, err := s.executor.CallStream(ctx, toolName, methodName, params, func(ev tools.StreamEvent) error {
+		return es.send(ev.Type, ev.Data)
+	})
+	if err != nil {
+		callErr := tools.AsCallError(err, toolName, methodName)
+		if !es.started {
+			s.writeError(w, callErr)
+			return nil, callErr
+		}
+		es.send("error", callErr)
+		return nil, callErr
+	}
+
+	wrapped := s.wrapFileOutputs(toolName, result, method)
+	es.send("result", wrapped)
+	return wrapped, nil
+}
+
+// eventStream writes Server-Sent Events, sending the stream headers with the first event
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mu      sync.Mutex
+	started bool
+}
+
+func (es *eventStream) send(event string, data interface{}) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if !es.started {
+		es.w.Header().Set("Content-Type", "text/event-stream")
+		es.w.Header().Set("Cache-Control", "no-cache")
+		es.w.Header().Set("Connection", "keep-alive")
+		es.w.Header().Set("X-Accel-Buffering", "no") // Don't let proxies buffer the stream
+		es.w.WriteHeader(http.StatusOK)
+		es.started = true
+	}
+	payload, err := json.Marshal(map[string]interface{}{event: data})
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(es.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	es.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,372 @@
+package tools
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A bundle is a gzipped tarball holding everything needed to install a tool
+// without network access:
+//
+//	jb-serve-bundle.yaml  the tool's lockfile entry
+//	tool/                 the tool directory, including .git and anything setup downloaded
+//	wheels/               every locked pip package
+//
+// Conda packages (and Python itself) still come from the configured channels, so
+// offline hosts need a local channel that has them.
+const bundleHeaderFile = "jb-serve-bundle.yaml"
+
+// bundleHeader is the first file in a bundle
+type bundleHeader struct {
+	Name      string      `yaml:"name"`
+	CreatedAt time.Time   `yaml:"created_at"`
+	Tool      *LockedTool `yaml:"tool"`
+}
+
+// BundleResult describes a bundle written by Bundle.
+type BundleResult struct {
+	Tool      string `json:"tool"`
+	Version   string `json:"version"`
+	Path      string `json:"path"` // Where the bundle is on the server, see BundlePath
+	SizeBytes int64  `json:"size_bytes"`
+	Wheels    int    `json:"wheels"`
+}
+
+// isBundle reports whether a local install source is a bundle rather than a tool directory
+func isBundle(source string) bool {
+	return strings.HasSuffix(source, ".tar.gz") || strings.HasSuffix(source, ".tgz")
+}
+
+// BundlePath is where Bundle writes a tool's bundle. The bundles directory belongs
+// to the server; clients download bundles from it rather than naming a path.
+func (m *Manager) BundlePath(name string) string {
+	return filepath.Join(m.cfg.BaseDir(), "bundles", name+".tar.gz")
+}
+
+// Bundle writes a tool, its exact package versions and wheels for all of its pip
+// packages to a tarball at BundlePath, which install accepts in place of a source.
+// Wheels are downloaded through the configured package sources.
+func (e *Executor) Bundle(name string, out io.Writer) (*BundleResult, error) {
+	// Keep upgrades from swapping the tool directory out while it's being read
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	m := e.manager
+	tool, ok := m.Get(name)
+	if !ok {
+		return nil, newError(ErrCodeToolNotFound, name, "", "tool not found: %s", name)
+	}
+	if err := m.EnsureEnvironment(tool); err != nil {
+		return nil, newError(ErrCodeInstallFailed, name, "", "failed to load environment: %v", err)
+	}
+	toolDir, err := filepath.EvalSymlinks(tool.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Freeze now so the bundle matches the environment exactly
+	entry := &LockedTool{Source: toolDir}
+	if locked := m.Locked(name); locked != nil {
+		*entry = *locked
+	}
+	entry.Commit = gitCommit(toolDir)
+	entry.Version = tool.Manifest.Version
+	entry.Python = tool.Env.PythonVersion.String()
+	entry.InstalledAt = time.Now().UTC().Truncate(time.Second)
+	if entry.Pip, entry.Conda, err = freezeEnv(tool.Env); err != nil {
+		return nil, newError(ErrCodeInstallFailed, name, "", "couldn't resolve package versions: %v", err)
+	}
+
+	path := m.BundlePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "jb-serve-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	wheelDir := filepath.Join(tmp, "wheels")
+	if err := os.MkdirAll(wheelDir, 0755); err != nil {
+		return nil, err
+	}
+	if len(entry.Pip) > 0 {
+		requirements := filepath.Join(tmp, "requirements.txt")
+		if err := os.WriteFile(requirements, []byte(strings.Join(entry.Pip, "\n")+"\n"), 0644); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Downloading %d pip packages for %s...\n", len(entry.Pip), name)
+		if err := m.packageSources().pipDownload(tool.Env, requirements, wheelDir, out); err != nil {
+			return nil, newError(ErrCodeInstallFailed, name, "", "%v", err)
+		}
+	}
+
+	fmt.Fprintf(out, "Writing %s\n", path)
+	header := &bundleHeader{Name: name, CreatedAt: entry.InstalledAt, Tool: entry}
+	wheels, err := writeBundle(path, header, toolDir, wheelDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	result := &BundleResult{Tool: name, Version: entry.Version, Path: path, Wheels: wheels}
+	if info, err := os.Stat(path); err == nil {
+		result.SizeBytes = info.Size()
+	}
+	return result, nil
+}
+
+// writeBundle writes the tarball through a temporary file and returns the number
+// of wheels in it
+func writeBundle(path string, header *bundleHeader, toolDir, wheelDir string) (int, error) {
+	data, err := yaml.Marshal(header)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = tw.WriteHeader(&tar.Header{
+		Name:    bundleHeaderFile,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: header.CreatedAt,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return 0, err
+	}
+	if _, err := addTree(tw, toolDir, "tool"); err != nil {
+		return 0, err
+	}
+	wheels, err := addTree(tw, wheelDir, "wheels")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return wheels, os.Rename(tmp, path)
+}
+
+// addTree adds everything under dir to the tarball beneath prefix, keeping
+// symlinks as links, and returns the number of regular files added
+func addTree(tw *tar.Writer, dir, prefix string) (int, error) {
+	files := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if d.Type()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return err
+		}
+		files++
+		return nil
+	})
+	return files, err
+}
+
+// unpackBundle extracts a bundle into staging and points the stage at its tool
+// directory and wheels. It returns the bundle's lockfile entry as the pins to
+// install with, unless pins were given.
+func (st *stagedInstall) unpackBundle(source string, pins *LockedTool) (*LockedTool, error) {
+	path, err := filepath.Abs(expandHome(source))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(st.m.stagingDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	st.bundleDir = filepath.Join(st.m.stagingDir(), st.id+".bundle")
+	header, err := extractBundle(path, st.bundleDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle %s: %w", source, err)
+	}
+
+	st.stagedDir = filepath.Join(st.m.stagingDir(), st.id)
+	if err := os.Rename(filepath.Join(st.bundleDir, "tool"), st.stagedDir); err != nil {
+		st.stagedDir = ""
+		return nil, fmt.Errorf("invalid bundle %s: no tool directory", source)
+	}
+	st.wheels = filepath.Join(st.bundleDir, "wheels")
+
+	// Record where the tool originally came from, unless that was a path on the
+	// machine that made the bundle
+	st.source, st.ref = path, ""
+	if header.Tool != nil && !isLocalSource(header.Tool.Source) {
+		st.source, st.ref = header.Tool.Source, header.Tool.Ref
+	}
+	if pins == nil {
+		pins = header.Tool
+	}
+	return pins, nil
+}
+
+// extractBundle unpacks a bundle into dir and returns its header. Entries that
+// would land outside dir are rejected.
+func extractBundle(path, dir string) (*bundleHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("unsafe path %q", hdr.Name)
+		}
+		target := filepath.Join(root, name)
+
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeSymlink {
+			continue
+		}
+
+		// Don't follow a link extracted earlier out of the bundle
+		parent := filepath.Dir(target)
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return nil, err
+		}
+		realParent, err := filepath.EvalSymlinks(parent)
+		if err != nil || !within(root, realParent) {
+			return nil, fmt.Errorf("unsafe path %q", hdr.Name)
+		}
+
+		if hdr.Typeflag == tar.TypeSymlink {
+			if filepath.IsAbs(hdr.Linkname) || !within(root, filepath.Join(realParent, hdr.Linkname)) {
+				return nil, fmt.Errorf("unsafe link %q -> %q", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, bundleHeaderFile))
+	if err != nil {
+		return nil, fmt.Errorf("missing %s", bundleHeaderFile)
+	}
+	var header bundleHeader
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", bundleHeaderFile, err)
+	}
+	return &header, nil
+}
+
+// within reports whether path is root or inside it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,328 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/richinsley/jumpboot"
+)
+
+// envMetaFile marks a shared environment as completely built and records the spec
+// it was built from
+const envMetaFile = "jb-serve-env.json"
+
+// EnvSpec is what a shared environment was built from. Tools whose specs hash to
+// the same key share one environment.
+type EnvSpec struct {
+	Python        string   `json:"python"`
+	CondaPackages []string `json:"conda_packages,omitempty"`
+	Packages      []string `json:"packages,omitempty"`
+	Requirements  string   `json:"requirements,omitempty"` // sha256 of requirements.txt
+	PinnedPip     []string `json:"pinned_pip,omitempty"`   // From a lockfile
+	PinnedConda   []string `json:"pinned_conda,omitempty"`
+}
+
+// Key hashes the spec into the environment's name.
+func (s *EnvSpec) Key() string {
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Fingerprint hashes only what the manifest asks for, leaving out lockfile pins, so
+// it can be compared against a tool's current manifest.
+func (s *EnvSpec) Fingerprint() string {
+	unpinned := *s
+	unpinned.PinnedPip, unpinned.PinnedConda = nil, nil
+	return unpinned.Key()
+}
+
+// envSpec builds the spec for a tool's manifest, requirements file and pins
+func envSpec(manifest *config.Manifest, toolPath string, pins *LockedTool) *EnvSpec {
+	spec := &EnvSpec{
+		Python:        manifest.Runtime.Python,
+		CondaPackages: manifest.Runtime.CondaPackages,
+		Packages:      manifest.Runtime.Packages,
+	}
+	if manifest.Runtime.Requirements != "" {
+		spec.Requirements = fileDigest(filepath.Join(toolPath, manifest.Runtime.Requirements))
+	}
+	if pins != nil {
+		spec.PinnedPip = pins.Pip
+		spec.PinnedConda = pinnedConda(manifest.Runtime.CondaPackages, pins.Conda)
+	}
+	return spec
+}
+
+// sharedEnvName is the environment directory for a spec
+func sharedEnvName(spec *EnvSpec) string {
+	return "env-" + spec.Key()
+}
+
+// envMeta is stored in each shared environment once it's fully built
+type envMeta struct {
+	Spec        *EnvSpec  `json:"spec"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func readEnvMeta(envDir string) (*envMeta, error) {
+	data, err := os.ReadFile(filepath.Join(envDir, envMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	var meta envMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func writeEnvMeta(envDir string, spec *EnvSpec) error {
+	meta := &envMeta{Spec: spec, Fingerprint: spec.Fingerprint(), CreatedAt: time.Now().UTC()}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(envDir, envMetaFile), data, 0644)
+}
+
+// lockEnv locks a shared environment against concurrent builds and deletes, and
+// returns the function that unlocks it
+func (m *Manager) lockEnv(envName string) func() {
+	m.envMu.Lock()
+	mu, ok := m.envLocks[envName]
+	if !ok {
+		mu = &sync.Mutex{}
+		m.envLocks[envName] = mu
+	}
+	m.envMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// ensureSharedEnv returns the environment for a spec, building it if no complete
+// one exists. built reports whether this call created it. Calls for the same spec
+// wait for each other, so one never mistakes another's build for an unfinished one.
+func (m *Manager) ensureSharedEnv(spec *EnvSpec, manifest *config.Manifest, toolPath string, pins *LockedTool, src *packageSources, out io.Writer) (env *jumpboot.PythonEnvironment, built bool, err error) {
+	unlock := m.lockEnv(sharedEnvName(spec))
+	defer unlock()
+	return m.ensureSharedEnvLocked(spec, manifest, toolPath, pins, src, out)
+}
+
+// ensureSharedEnvLocked is ensureSharedEnv for a caller holding the environment's lock
+func (m *Manager) ensureSharedEnvLocked(spec *EnvSpec, manifest *config.Manifest, toolPath string, pins *LockedTool, src *packageSources, out io.Writer) (env *jumpboot.PythonEnvironment, built bool, err error) {
+	name := sharedEnvName(spec)
+	envDir := filepath.Join(m.envsDir(), name)
+
+	if _, err := readEnvMeta(envDir); err == nil {
+		fmt.Fprintf(out, "Reusing environment %s\n", name)
+		env, err := m.createEnvironment(name, manifest, out)
+		return env, false, err
+	}
+
+	// A directory without metadata is a build that didn't finish
+	os.RemoveAll(envDir)
+
+	fmt.Fprintf(out, "Creating Python %s environment %s...\n", manifest.Runtime.Python, name)
+	env, err = m.createEnvironment(name, manifest, out)
+	if err != nil {
+		os.RemoveAll(envDir)
+		return nil, false, fmt.Errorf("failed to create environment: %w", err)
+	}
+	if err := m.installPackages(env, manifest, toolPath, pins, src, out); err != nil {
+		os.RemoveAll(envDir)
+		return nil, false, fmt.Errorf("failed to install packages: %w", err)
+	}
+	if err := writeEnvMeta(envDir, spec); err != nil {
+		os.RemoveAll(envDir)
+		return nil, false, err
+	}
+	return env, true, nil
+}
+
+// linkedEnv returns the environment directory name a tool's link points at, or the
+// link name itself for a tool with a plain (unshared) environment directory
+func (m *Manager) linkedEnv(toolName string) (string, bool) {
+	link := filepath.Join(m.envsDir(), envLinkName(toolName))
+	info, err := os.Lstat(link)
+	if err != nil {
+		return "", false
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return envLinkName(toolName), true
+	}
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", false
+	}
+	return filepath.Base(target), true
+}
+
+// envUsers maps each environment directory to the installed tools using it
+func (m *Manager) envUsers() map[string][]string {
+	users := make(map[string][]string)
+	for _, tool := range m.List() {
+		if env, ok := m.linkedEnv(tool.Name); ok {
+			users[env] = append(users[env], tool.Name)
+		}
+	}
+	return users
+}
+
+// envInUse reports whether any installed tool uses an environment directory
+func (m *Manager) envInUse(envName string) bool {
+	return len(m.envUsers()[envName]) > 0
+}
+
+// releaseEnv removes an environment directory unless an installed tool still uses it
+func (m *Manager) releaseEnv(envName string) {
+	if envName == "" {
+		return
+	}
+	unlock := m.lockEnv(envName)
+	defer unlock()
+	if m.envInUse(envName) {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(m.envsDir(), envName)); err != nil {
+		log.Printf("Warning: failed to remove environment %s: %v", envName, err)
+	}
+}
+
+// EnvInfo describes one environment directory.
+type EnvInfo struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Python    string    `json:"python,omitempty"`
+	SizeBytes int64     `json:"size_bytes"`
+	Tools     []string  `json:"tools"`  // Installed tools using it; empty means orphaned
+	Shared    bool      `json:"shared"` // Keyed by spec, so tools with the same spec reuse it
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// Envs lists every environment directory and the tools using it.
+func (m *Manager) Envs() ([]EnvInfo, error) {
+	entries, err := os.ReadDir(m.envsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []EnvInfo{}, nil
+		}
+		return nil, err
+	}
+
+	users := m.envUsers()
+	envs := []EnvInfo{}
+	for _, entry := range entries {
+		// Tool links are references, not environments
+		if entry.Type()&os.ModeSymlink != 0 || !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(m.envsDir(), entry.Name())
+		info := EnvInfo{
+			Name:      entry.Name(),
+			Path:      path,
+			SizeBytes: dirSize(path),
+			Tools:     users[entry.Name()],
+			Shared:    strings.HasPrefix(entry.Name(), "env-"),
+		}
+		if info.Tools == nil {
+			info.Tools = []string{}
+		}
+		sort.Strings(info.Tools)
+		if meta, err := readEnvMeta(path); err == nil {
+			info.Python = meta.Spec.Python
+			info.CreatedAt = meta.CreatedAt
+		}
+		envs = append(envs, info)
+	}
+	return envs, nil
+}
+
+// dirSize totals the sizes of regular files under dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// PruneResult lists the environments a prune removed (or would remove).
+type PruneResult struct {
+	Removed    []EnvInfo `json:"removed"`
+	FreedBytes int64     `json:"freed_bytes"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+}
+
+// PruneEnvs removes environments no installed tool uses, along with links left by
+// uninstalled tools. With dryRun it only reports what it would remove.
+func (e *Executor) PruneEnvs(dryRun bool) (*PruneResult, error) {
+	// Installs and upgrades hold reloadMu while an environment is unreferenced
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	m := e.manager
+	envs, err := m.Envs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{Removed: []EnvInfo{}, DryRun: dryRun}
+	for _, env := range envs {
+		if len(env.Tools) > 0 {
+			continue
+		}
+		if !dryRun {
+			// Check again under the lock: a call may have just built and linked it
+			unlock := m.lockEnv(env.Name)
+			if m.envInUse(env.Name) {
+				unlock()
+				continue
+			}
+			err := os.RemoveAll(env.Path)
+			unlock()
+			if err != nil {
+				return result, fmt.Errorf("failed to remove %s: %w", env.Name, err)
+			}
+			log.Printf("Pruned environment %s", env.Name)
+		}
+		result.Removed = append(result.Removed, env)
+		result.FreedBytes += env.SizeBytes
+	}
+
+	// Links whose tool is gone
+	if !dryRun {
+		entries, _ := os.ReadDir(m.envsDir())
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSymlink == 0 {
+				continue
+			}
+			if _, ok := m.Get(strings.TrimPrefix(entry.Name(), "tool-")); !ok {
+				os.Remove(filepath.Join(m.envsDir(), entry.Name()))
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,89 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/calobozan/jb-serve/internal/config"
+)
+
+// Stable error codes returned in the API error envelope
+const (
+	ErrCodeToolNotFound          = "tool_not_found"
+	ErrCodeMethodNotFound        = "method_not_found"
+	ErrCodeNotPersistent         = "not_persistent"
+	ErrCodeAlreadyRunning        = "already_running"
+	ErrCodeAlreadyInstalled      = "already_installed"
+	ErrCodeInstallFailed         = "install_failed"
+	ErrCodeNotRunning            = "not_running"
+	ErrCodeJobFinished           = "job_finished"
+	ErrCodeValidation            = "validation_failed"
+	ErrCodeBadRequest            = "bad_request"
+	ErrCodeToolException         = "tool_exception"
+	ErrCodeCanceled              = "canceled"
+	ErrCodeQueueFull             = "queue_full"
+	ErrCodeInsufficientResources = "insufficient_resources"
+	ErrCodeTimeout               = "timeout"
+	ErrCodeNotFound              = "not_found"
+	ErrCodeUnavailable           = "unavailable"
+	ErrCodeMethodNotAllowed      = "method_not_allowed"
+	ErrCodeUnauthorized          = "unauthorized"
+	ErrCodeInternal              = "internal_error"
+)
+
+// newError creates a CallError with the given code
+func newError(code, tool, method, format string, args ...interface{}) *CallError {
+	return &CallError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Tool:    tool,
+		Method:  method,
+	}
+}
+
+// AsCallError converts any error into a *CallError, filling in tool and method
+// if they aren't already set. Errors without a code become internal errors.
+func AsCallError(err error, tool, method string) *CallError {
+	var ce *CallError
+	if errors.As(err, &ce) {
+		out := *ce
+		if out.Tool == "" {
+			out.Tool = tool
+		}
+		if out.Method == "" {
+			out.Method = method
+		}
+		return &out
+	}
+
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		return &CallError{
+			Code:    ErrCodeValidation,
+			Message: verr.Error(),
+			Tool:    tool,
+			Method:  method,
+			Fields:  verr.Errors,
+		}
+	}
+
+	return &CallError{
+		Code:    ErrCodeInternal,
+		Message: err.Error(),
+		Tool:    tool,
+		Method:  method,
+	}
+}
+
+// pythonError builds a CallError for an exception raised inside the tool
+func pythonError(errType, message, traceback string) *CallError {
+	if errType == "" {
+		errType = "Exception"
+	}
+	return &CallError{
+		Code:      ErrCodeToolException,
+		Type:      errType,
+		Message:   message,
+		Traceback: traceback,
+	}
+}
@@ -3,135 +3,284 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/calobozan/jb-serve/internal/config"
 	"github.com/richinsley/jumpboot"
 )
 
 // CallResponse represents the response from a jb-service call
 type CallResponse struct {
-	OK     bool                   `json:"ok"`
-	Result interface{}            `json:"result,omitempty"`
-	Error  *CallError             `json:"error,omitempty"`
-	Done   bool                   `json:"done"`
-	Chunk  interface{}            `json:"chunk,omitempty"` // For future streaming support
+	OK       bool        `json:"ok"`
+	Result   interface{} `json:"result,omitempty"`
+	Error    *CallError  `json:"error,omitempty"`
+	Done     bool        `json:"done"`
+	Chunk    interface{} `json:"chunk,omitempty"`    // Partial result from a streaming call
+	Progress interface{} `json:"progress,omitempty"` // Progress update from a streaming call
+	Log      interface{} `json:"log,omitempty"`      // Log record from a streaming call
 }
 
-// CallError represents an error from a jb-service call
+// CallError represents an error from a jb-service call.
+// It doubles as the error envelope returned by the HTTP API.
 type CallError struct {
-	Type      string `json:"type"`
-	Message   string `json:"message"`
-	Traceback string `json:"traceback,omitempty"`
+	Code      string              `json:"code,omitempty"` // Stable error code (see ErrCode*)
+	Type      string              `json:"type,omitempty"` // Python exception type
+	Message   string              `json:"message"`
+	Traceback string              `json:"traceback,omitempty"`
+	Tool      string              `json:"tool,omitempty"`
+	Method    string              `json:"method,omitempty"`
+	Fields    []config.FieldError `json:"fields,omitempty"` // Per-field validation errors
+}
+
+func (e *CallError) Error() string {
+	msg := e.Message
+	if e.Type != "" {
+		msg = fmt.Sprintf("%s: %s", e.Type, e.Message)
+	}
+	if e.Traceback != "" {
+		msg += "\n" + e.Traceback
+	}
+	return msg
 }
 
 // Executor handles RPC calls to tools using jumpboot
 type Executor struct {
 	manager       *Manager
-	repls         map[string]*jumpboot.REPLPythonProcess  // REPL transport
-	queues        map[string]*jumpboot.QueueProcess       // MessagePack transport
+	instances     map[string]*instance      // Running persistent tools
+	restarts      map[string]*restartState  // Supervisor restart history
+	resources     *resourceLedger           // Host resource budget
 	healthCancels map[string]context.CancelFunc
+	metrics       *executorMetrics
+	reloading     map[string]chan struct{} // Tools being restarted by a reload or held by an upgrade; calls wait
+	starting      map[string]chan struct{} // Tools whose workers are being started
+	onReload      func(*ReloadResult)
+	reloadMu      sync.Mutex // Serializes reloads
 	mu            sync.RWMutex
 	serverPort    int // Port the server is listening on (for JB_SERVE_URL)
 }
 
 // NewExecutor creates a new executor
 func NewExecutor(manager *Manager) *Executor {
-	return &Executor{
+	e := &Executor{
 		manager:       manager,
-		repls:         make(map[string]*jumpboot.REPLPythonProcess),
-		queues:        make(map[string]*jumpboot.QueueProcess),
+		instances:     make(map[string]*instance),
+		restarts:      make(map[string]*restartState),
+		resources:     newResourceLedger(manager.cfg.Resources),
 		healthCancels: make(map[string]context.CancelFunc),
+		reloading:     make(map[string]chan struct{}),
+		starting:      make(map[string]chan struct{}),
 		serverPort:    9800, // default
 	}
+	e.metrics = newExecutorMetrics(e)
+	return e
 }
 
+// DefaultCallTimeout applies when neither the request nor the manifest sets a timeout
+const DefaultCallTimeout = 300 * time.Second
+
 // SetServerPort sets the port for JB_SERVE_URL env var
 func (e *Executor) SetServerPort(port int) {
 	e.serverPort = port
 }
 
-// getEnv returns environment variables to pass to Python processes
-func (e *Executor) getEnv() map[string]string {
-	return map[string]string{
-		"JB_SERVE_URL": fmt.Sprintf("http://localhost:%d", e.serverPort),
+// getEnv returns the Python environment to start a tool's processes from (sandboxed
+// if its policy says so) and the environment variables to pass them
+func (e *Executor) getEnv(tool *Tool) (*jumpboot.PythonEnvironment, map[string]string, error) {
+	pyEnv, env, err := e.manager.processEnv(tool)
+	if err != nil {
+		return nil, nil, err
 	}
+	env["JB_SERVE_URL"] = fmt.Sprintf("http://localhost:%d", e.serverPort)
+	return pyEnv, env, nil
+}
+
+// Call executes a method on a tool.
+// The call is abandoned when ctx is cancelled or its deadline passes. If ctx has no
+// deadline, the method's manifest timeout (or DefaultCallTimeout) applies.
+func (e *Executor) Call(ctx context.Context, toolName, methodName string, params map[string]interface{}) (interface{}, error) {
+	return e.call(ctx, toolName, methodName, params, nil)
+}
+
+// CallStream executes a method like Call, passing chunk, progress and log events to
+// emit as they arrive. Methods that don't stream produce only the final result.
+func (e *Executor) CallStream(ctx context.Context, toolName, methodName string, params map[string]interface{}, emit func(StreamEvent) error) (interface{}, error) {
+	return e.call(ctx, toolName, methodName, params, emit)
+}
+
+// Validate checks params against a method's input schema, as Call does, and
+// returns them normalized. Use it to reject a call before queueing it.
+func (e *Executor) Validate(toolName, methodName string, params map[string]interface{}) (map[string]interface{}, error) {
+	_, _, params, err := e.validate(toolName, methodName, params)
+	return params, err
 }
 
-// Call executes a method on a tool
-func (e *Executor) Call(toolName, methodName string, params map[string]interface{}) (interface{}, error) {
+// validate looks up a method and checks params against its input schema, before
+// anything is spawned
+func (e *Executor) validate(toolName, methodName string, params map[string]interface{}) (*Tool, config.Method, map[string]interface{}, error) {
 	tool, ok := e.manager.Get(toolName)
 	if !ok {
-		return nil, fmt.Errorf("tool not found: %s", toolName)
+		return nil, config.Method{}, nil, newError(ErrCodeToolNotFound, toolName, methodName, "tool not found: %s", toolName)
 	}
 
 	// Validate method exists in manifest
 	method, ok := tool.Manifest.RPC.Methods[methodName]
 	if !ok {
-		return nil, fmt.Errorf("method not found: %s", methodName)
+		return nil, config.Method{}, nil, newError(ErrCodeMethodNotFound, toolName, methodName, "method not found: %s", methodName)
+	}
+
+	params, err := config.ValidateParams(method.Input, params)
+	if err != nil {
+		return nil, config.Method{}, nil, AsCallError(err, toolName, methodName)
+	}
+	return tool, method, params, nil
+}
+
+// call executes a method, streaming events to emit if it is set
+func (e *Executor) call(ctx context.Context, toolName, methodName string, params map[string]interface{}, emit func(StreamEvent) error) (interface{}, error) {
+	tool, method, params, err := e.validate(toolName, methodName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the manifest timeout unless the caller already set a deadline
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := DefaultCallTimeout
+		if method.Timeout > 0 {
+			timeout = time.Duration(method.Timeout) * time.Second
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	_ = method // Could validate params against schema
 
+	started := time.Now()
+	e.metrics.inFlight.Inc(toolName)
+	defer e.metrics.inFlight.Dec(toolName)
+
+	result, err := e.dispatch(ctx, tool, methodName, params, emit)
+	e.metrics.observeCall(toolName, methodName, started, err)
+	return result, err
+}
+
+// dispatch routes a validated call by mode and transport
+func (e *Executor) dispatch(ctx context.Context, tool *Tool, methodName string, params map[string]interface{}, emit func(StreamEvent) error) (interface{}, error) {
 	// Ensure environment is ready
 	if err := e.manager.EnsureEnvironment(tool); err != nil {
 		return nil, fmt.Errorf("failed to ensure environment: %w", err)
 	}
 
-	// Route based on transport
-	transport := tool.Manifest.Runtime.Transport
-	if transport == "msgpack" {
-		if tool.Manifest.Runtime.Mode == "persistent" {
-			return e.callPersistentMsgpack(tool, methodName, params)
-		}
-		return e.callOneshotMsgpack(tool, methodName, params)
+	// Persistent tools go through their scheduler, which picks the worker and transport
+	if tool.Manifest.Runtime.Mode == "persistent" {
+		return e.callPersistent(ctx, tool, methodName, params, emit)
 	}
 
-	// Default: REPL transport
-	if tool.Manifest.Runtime.Mode == "persistent" {
-		return e.callPersistent(tool, methodName, params)
+	// Route based on transport
+	if tool.Manifest.Runtime.Transport == "msgpack" {
+		return e.callOneshotMsgpack(ctx, tool, methodName, params, emit)
 	}
-	return e.callOneshot(tool, methodName, params)
+	return e.callOneshot(ctx, tool, methodName, params, emit)
 }
 
 // callOneshot runs a tool for a single call using jb-service protocol
-func (e *Executor) callOneshot(tool *Tool, methodName string, params map[string]interface{}) (interface{}, error) {
+func (e *Executor) callOneshot(ctx context.Context, tool *Tool, methodName string, params map[string]interface{}, emit func(StreamEvent) error) (interface{}, error) {
 	entrypoint := filepath.Join(tool.Path, tool.Manifest.Runtime.Entrypoint)
 
 	// Create REPL process - no module needed, we run main.py directly
-	repl, err := tool.Env.NewREPLPythonProcess(nil, e.getEnv(), nil, nil)
+	pyEnv, env, err := e.getEnv(tool)
+	if err != nil {
+		return nil, err
+	}
+	repl, err := pyEnv.NewREPLPythonProcess(nil, env, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create REPL: %w", err)
 	}
 	defer repl.Close()
 
 	// Start the service by executing main.py
-	if err := e.initializeService(repl, entrypoint, tool.Manifest.Runtime.StartupTimeout); err != nil {
+	if err := e.initializeService(ctx, repl, entrypoint, tool.Manifest.Runtime.StartupTimeout); err != nil {
 		return nil, err
 	}
 
-	// Make the call using __jb_call__
-	return e.doCall(repl, methodName, params)
+	// Make the call using __jb_call__, killing the process if we give up on it
+	abort := func() { repl.Terminate() }
+	if emit != nil {
+		return e.doStreamCall(ctx, repl, methodName, params, abort, emit)
+	}
+	return e.doCall(ctx, repl, methodName, params, abort)
 }
 
-// callPersistent calls a method on a running persistent tool (REPL transport)
-func (e *Executor) callPersistent(tool *Tool, methodName string, params map[string]interface{}) (interface{}, error) {
-	e.mu.RLock()
-	repl, ok := e.repls[tool.Name]
-	e.mu.RUnlock()
+// callPersistent calls a method on a running persistent tool.
+// Calls wait in the tool's FIFO queue until a worker slot is free.
+func (e *Executor) callPersistent(ctx context.Context, tool *Tool, methodName string, params map[string]interface{}, emit func(StreamEvent) error) (interface{}, error) {
+	var inst *instance
+	var w *worker
+	for {
+		e.mu.RLock()
+		current, ok := e.instances[tool.Name]
+		e.mu.RUnlock()
 
-	if !ok || repl == nil {
-		return nil, fmt.Errorf("tool %s is not running, start it first", tool.Name)
-	}
+		if !ok {
+			// Being started, or restarted to pick up a changed manifest - wait for it
+			wait := e.reloadWait(tool.Name)
+			if wait == nil {
+				wait = e.startWait(tool.Name)
+			}
+			if wait != nil {
+				select {
+				case <-wait:
+					continue
+				case <-ctx.Done():
+					return nil, contextError(ctx)
+				}
+			}
+			if !tool.Manifest.Runtime.Autostart {
+				return nil, newError(ErrCodeNotRunning, tool.Name, methodName, "tool %s is not running, start it first", tool.Name)
+			}
+			if err := e.autostart(ctx, tool, methodName); err != nil {
+				return nil, err
+			}
+			continue
+		}
 
-	return e.doCall(repl, methodName, params)
+		var err error
+		w, err = current.acquire(ctx, tool.Name, methodName)
+		if errors.Is(err, errRetired) {
+			// Stopped while we were waiting - start again or give up
+			if !tool.Manifest.Runtime.Autostart {
+				return nil, newError(ErrCodeNotRunning, tool.Name, methodName, "tool %s was stopped", tool.Name)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		inst = current
+		break
+	}
+	defer inst.release(w)
+
+	abort := func() { e.abortWorker(tool, inst, w) }
+	switch {
+	case w.queue != nil && emit != nil:
+		return e.doQueueStreamCall(ctx, w.queue, methodName, params, abort, emit)
+	case w.queue != nil:
+		return e.doQueueCall(ctx, w.queue, methodName, params, abort)
+	case emit != nil:
+		return e.doStreamCall(ctx, w.repl, methodName, params, abort, emit)
+	}
+	return e.doCall(ctx, w.repl, methodName, params, abort)
 }
 
 // callOneshotMsgpack runs a tool for a single call using MessagePack transport
-func (e *Executor) callOneshotMsgpack(tool *Tool, methodName string, params map[string]interface{}) (interface{}, error) {
+func (e *Executor) callOneshotMsgpack(ctx context.Context, tool *Tool, methodName string, params map[string]interface{}, emit func(StreamEvent) error) (interface{}, error) {
 	entrypoint := filepath.Join(tool.Path, tool.Manifest.Runtime.Entrypoint)
 
 	// Create module from entrypoint
@@ -148,46 +297,101 @@ func (e *Executor) callOneshotMsgpack(tool *Tool, methodName string, params map[
 	}
 
 	// Create queue process
-	queue, err := tool.Env.NewQueueProcess(program, nil, e.getEnv(), nil)
+	pyEnv, env, err := e.getEnv(tool)
+	if err != nil {
+		return nil, err
+	}
+	queue, err := pyEnv.NewQueueProcess(program, nil, env, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create queue process: %w", err)
 	}
 	defer queue.Close()
 
 	// Call the method
-	return e.doQueueCall(queue, methodName, params)
-}
-
-// callPersistentMsgpack calls a method on a running persistent tool (MessagePack transport)
-func (e *Executor) callPersistentMsgpack(tool *Tool, methodName string, params map[string]interface{}) (interface{}, error) {
-	e.mu.RLock()
-	queue, ok := e.queues[tool.Name]
-	e.mu.RUnlock()
-
-	if !ok || queue == nil {
-		return nil, fmt.Errorf("tool %s is not running, start it first", tool.Name)
+	abort := func() { queue.Terminate() }
+	if emit != nil {
+		return e.doQueueStreamCall(ctx, queue, methodName, params, abort, emit)
 	}
-
-	return e.doQueueCall(queue, methodName, params)
+	return e.doQueueCall(ctx, queue, methodName, params, abort)
 }
 
 // doQueueCall executes a method using MessagePack queue
-func (e *Executor) doQueueCall(queue *jumpboot.QueueProcess, methodName string, params map[string]interface{}) (interface{}, error) {
+func (e *Executor) doQueueCall(ctx context.Context, queue *jumpboot.QueueProcess, methodName string, params map[string]interface{}, abort func()) (interface{}, error) {
 	if params == nil {
 		params = make(map[string]interface{})
 	}
 
-	// Call with 5 minute timeout
-	result, err := queue.Call(methodName, 300, params)
+	// Give the queue the same deadline so its waiter doesn't outlive an abandoned call
+	timeoutSec := deadlineSeconds(ctx)
+
+	out, err := runWithContext(ctx, abort, func() (interface{}, error) {
+		return queue.SendCommand(methodName, params, timeoutSec, true)
+	})
 	if err != nil {
+		var ce *CallError
+		if errors.As(err, &ce) {
+			return nil, ce
+		}
+		if strings.Contains(err.Error(), "timeout") {
+			return nil, &CallError{Code: ErrCodeTimeout, Message: err.Error()}
+		}
 		return nil, fmt.Errorf("queue call failed: %w", err)
 	}
+	response := out.(map[string]interface{})
 
-	return result, nil
+	// Python exceptions come back as {"error": ..., "traceback": ...}
+	if errMsg, ok := response["error"].(string); ok {
+		traceback, _ := response["traceback"].(string)
+		return nil, pythonError("", errMsg, traceback)
+	}
+
+	if result, ok := response["result"]; ok {
+		return result, nil
+	}
+
+	// Return the whole response (minus request_id), matching QueueProcess.Call
+	delete(response, "request_id")
+	if len(response) == 1 {
+		for _, v := range response {
+			return v, nil
+		}
+	}
+	return response, nil
+}
+
+// deadlineSeconds returns the whole seconds left before ctx's deadline, 0 if it has none
+func deadlineSeconds(ctx context.Context) int {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	sec := int(math.Ceil(time.Until(deadline).Seconds()))
+	if sec < 1 {
+		sec = 1
+	}
+	return sec
 }
 
 // initializeService runs the tool's main.py and waits for __JB_READY__
-func (e *Executor) initializeService(repl *jumpboot.REPLPythonProcess, entrypoint string, timeoutSec int) error {
+func (e *Executor) initializeService(ctx context.Context, repl *jumpboot.REPLPythonProcess, entrypoint string, timeoutSec int) error {
+	if timeoutSec > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
+		defer cancel()
+	}
+
+	_, err := runWithContext(ctx, func() { repl.Terminate() }, func() (interface{}, error) {
+		return nil, e.runEntrypoint(repl, entrypoint)
+	})
+	var ce *CallError
+	if errors.As(err, &ce) && ce.Code == ErrCodeTimeout {
+		ce.Message = fmt.Sprintf("service did not start within %ds", timeoutSec)
+	}
+	return err
+}
+
+// runEntrypoint executes main.py in the REPL and imports the jb-service hooks
+func (e *Executor) runEntrypoint(repl *jumpboot.REPLPythonProcess, entrypoint string) error {
 	// Execute the entrypoint file with __name__ set to "__main__"
 	// This is required for the `if __name__ == "__main__": run(Service)` pattern
 	// The run() function registers __jb_call__ etc. in builtins
@@ -210,6 +414,10 @@ if hasattr(builtins, '__jb_call__'):
     __jb_schema__ = builtins.__jb_schema__
     __jb_methods__ = builtins.__jb_methods__
     __jb_shutdown__ = builtins.__jb_shutdown__
+if hasattr(builtins, '__jb_stream__'):
+    __jb_stream__ = builtins.__jb_stream__
+    __jb_stream_next__ = builtins.__jb_stream_next__
+    __jb_stream_close__ = builtins.__jb_stream_close__
 `
 	_, err = repl.Execute(importCode, true)
 	if err != nil {
@@ -231,7 +439,7 @@ if hasattr(builtins, '__jb_call__'):
 }
 
 // doCall executes a method using the __jb_call__ protocol
-func (e *Executor) doCall(repl *jumpboot.REPLPythonProcess, methodName string, params map[string]interface{}) (interface{}, error) {
+func (e *Executor) doCall(ctx context.Context, repl *jumpboot.REPLPythonProcess, methodName string, params map[string]interface{}, abort func()) (interface{}, error) {
 	if params == nil {
 		params = make(map[string]interface{})
 	}
@@ -244,17 +452,108 @@ func (e *Executor) doCall(repl *jumpboot.REPLPythonProcess, methodName string, p
 	// Call __jb_call__(method, params)
 	callExpr := fmt.Sprintf(`__jb_call__(%q, %s)`, methodName, string(paramsJSON))
 	
-	result, err := repl.Execute(callExpr, true)
+	out, err := runWithContext(ctx, abort, func() (interface{}, error) {
+		return repl.Execute(callExpr, true)
+	})
 	if err != nil {
+		var ce *CallError
+		if errors.As(err, &ce) {
+			return nil, ce
+		}
 		return nil, fmt.Errorf("call failed: %w", err)
 	}
 
 	// Parse the response
-	return e.parseResponse(result)
+	return e.parseResponse(out.(string))
+}
+
+// runWithContext runs fn until it finishes or ctx is done. When ctx wins, abort is
+// called (if set) to unstick the Python process and fn's result is discarded.
+func runWithContext(ctx context.Context, abort func(), fn func() (interface{}, error)) (interface{}, error) {
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		done <- result{v, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		if abort != nil {
+			abort()
+		}
+		return nil, contextError(ctx)
+	}
+}
+
+// contextError converts a finished context into a timeout or canceled CallError
+func contextError(ctx context.Context) *CallError {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &CallError{Code: ErrCodeTimeout, Message: "call timed out"}
+	}
+	return &CallError{Code: ErrCodeCanceled, Message: "call canceled"}
+}
+
+// abortWorker kills a wedged worker and replaces it with a fresh process in the
+// background, so an abandoned call doesn't leave the tool stuck
+func (e *Executor) abortWorker(tool *Tool, inst *instance, w *worker) {
+	log.Printf("Abandoned call on %s, restarting worker", tool.Name)
+	inst.markDead(w)
+	w.stopping.Store(true)
+	w.process().Terminate()
+
+	go func() {
+		defer w.close()
+
+		fresh, err := e.spawnWorker(tool)
+		if err != nil {
+			log.Printf("Failed to restart worker for %s: %v", tool.Name, err)
+			// Queued calls wait for a live worker; don't leave them waiting for none
+			if inst.live() == 0 {
+				tool.LastExitReason = fmt.Sprintf("restart failed: %v", err)
+				e.teardown(tool, inst, "crashed")
+			}
+			return
+		}
+
+		// Drop the new worker if the tool was stopped or restarted meanwhile
+		e.mu.RLock()
+		current := e.instances[tool.Name] == inst
+		e.mu.RUnlock()
+		if !current || !inst.replaceWorker(w, fresh) {
+			fresh.close()
+			return
+		}
+		go e.watchWorker(tool, inst, fresh)
+	}()
 }
 
 // parseResponse parses a jb-service response
 func (e *Executor) parseResponse(result string) (interface{}, error) {
+	resp, ok := decodeResponse(result)
+	if !ok {
+		// If not valid JSON, return raw result
+		return result, nil
+	}
+
+	// Check for error
+	if !resp.OK {
+		if resp.Error != nil {
+			return nil, pythonError(resp.Error.Type, resp.Error.Message, resp.Error.Traceback)
+		}
+		return nil, pythonError("", "call failed with unknown error", "")
+	}
+
+	return resp.Result, nil
+}
+
+// decodeResponse parses a CallResponse printed by the REPL
+func decodeResponse(result string) (*CallResponse, bool) {
 	// Clean up the result string - REPL may return quoted strings
 	resultStr := strings.TrimSpace(result)
 	
@@ -273,98 +572,193 @@ func (e *Executor) parseResponse(result string) (interface{}, error) {
 	// Parse as CallResponse
 	var resp CallResponse
 	if err := json.Unmarshal([]byte(resultStr), &resp); err != nil {
-		// If not valid JSON, return raw result
-		return result, nil
-	}
-
-	// Check for error
-	if !resp.OK {
-		if resp.Error != nil {
-			errMsg := fmt.Sprintf("%s: %s", resp.Error.Type, resp.Error.Message)
-			if resp.Error.Traceback != "" {
-				errMsg += "\n" + resp.Error.Traceback
-			}
-			return nil, fmt.Errorf(errMsg)
-		}
-		return nil, fmt.Errorf("call failed with unknown error")
+		return nil, false
 	}
-
-	return resp.Result, nil
+	return &resp, true
 }
 
 // Start starts a persistent tool
 func (e *Executor) Start(toolName string) error {
+	// Start whatever a reload or upgrade in progress leaves in place
+	if wait := e.reloadWait(toolName); wait != nil {
+		<-wait
+	}
+	return e.start(toolName, true)
+}
+
+// start starts a persistent tool. Manual starts clear the supervisor's restart history.
+func (e *Executor) start(toolName string, manual bool) error {
 	tool, ok := e.manager.Get(toolName)
 	if !ok {
-		return fmt.Errorf("tool not found: %s", toolName)
+		return newError(ErrCodeToolNotFound, toolName, "", "tool not found: %s", toolName)
 	}
 
 	if tool.Manifest.Runtime.Mode != "persistent" {
-		return fmt.Errorf("tool %s is not a persistent tool", toolName)
+		return newError(ErrCodeNotPersistent, toolName, "", "tool %s is not a persistent tool", toolName)
+	}
+
+	// Reserve the tool's declared resources against the host budget
+	need := demand(tool)
+	if e.resources.exceedsBudget(need) {
+		return newError(ErrCodeInsufficientResources, toolName, "", "%s needs more resources than this host has (%s)", toolName, describeShort(need))
+	}
+	queueTimeout := DefaultResourceQueueTimeout
+	if b := e.resources.budget; b != nil && b.QueueTimeout > 0 {
+		queueTimeout = time.Duration(b.QueueTimeout) * time.Second
 	}
+	deadline := time.Now().Add(queueTimeout)
+
+	for {
+		e.mu.Lock()
+		e.waitStartLocked(toolName)
+
+		// Check if already running
+		if _, ok := e.instances[toolName]; ok {
+			e.mu.Unlock()
+			return newError(ErrCodeAlreadyRunning, toolName, "", "tool %s is already running", toolName)
+		}
+
+		ok, short, changed := e.resources.tryReserve(toolName, need)
+		if ok {
+			break
+		}
+		e.mu.Unlock()
+		if err := e.waitForResources(tool, short, changed, deadline); err != nil {
+			return err
+		}
+	}
+
+	// Build the environment and spawn workers without holding the lock, so calls to
+	// other tools aren't held up. Other starts and stops of this tool wait for it.
+	done := make(chan struct{})
+	e.starting[toolName] = done
+	e.mu.Unlock()
+	started := time.Now()
+
+	workers, err := e.spawnWorkers(tool)
 
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	delete(e.starting, toolName)
+	close(done)
+	if err != nil {
+		e.resources.release(toolName)
+		return err
+	}
 
-	// Check if already running
-	if _, ok := e.repls[toolName]; ok {
-		return fmt.Errorf("tool %s is already running", toolName)
+	inst := newInstance(workers, tool.Manifest.Runtime)
+	e.instances[tool.Name] = inst
+	tool.Status = "running"
+	tool.HealthStatus = "unknown"
+	tool.HealthFailures = 0
+	if manual {
+		e.resetRestarts(tool.Name)
+		tool.RestartCount = 0
+		tool.LastExitReason = ""
+	}
+
+	// Supervise worker processes
+	for _, w := range workers {
+		go e.watchWorker(tool, inst, w)
+	}
+
+	// Start health check if configured
+	if tool.Manifest.Health != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		e.healthCancels[tool.Name] = cancel
+		go e.runHealthCheck(ctx, tool, inst)
 	}
-	if _, ok := e.queues[toolName]; ok {
-		return fmt.Errorf("tool %s is already running", toolName)
+
+	// Unload after idle_timeout without calls
+	if tool.Manifest.Runtime.IdleTimeout > 0 {
+		go e.runIdleMonitor(tool, inst)
 	}
 
-	// Ensure environment
+	e.metrics.startDuration.Observe(time.Since(started).Seconds(), tool.Name)
+	log.Printf("Started %s (%s, %d worker(s))", tool.Name, tool.Manifest.Runtime.Transport, len(workers))
+	return nil
+}
+
+// spawnWorkers ensures a tool's environment and starts its worker pool
+func (e *Executor) spawnWorkers(tool *Tool) ([]*worker, error) {
 	if err := e.manager.EnsureEnvironment(tool); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Start based on transport
-	transport := tool.Manifest.Runtime.Transport
-	if transport == "msgpack" {
+	count := tool.Manifest.Runtime.Workers
+	workers := make([]*worker, 0, count)
+	for i := 0; i < count; i++ {
+		w, err := e.spawnWorker(tool)
+		if err != nil {
+			for _, started := range workers {
+				started.close()
+			}
+			return nil, err
+		}
+		workers = append(workers, w)
+	}
+	return workers, nil
+}
+
+// waitStartLocked waits for an in-progress start of a tool to finish. e.mu must be
+// held; it's released while waiting.
+func (e *Executor) waitStartLocked(toolName string) {
+	for {
+		done, ok := e.starting[toolName]
+		if !ok {
+			return
+		}
+		e.mu.Unlock()
+		<-done
+		e.mu.Lock()
+	}
+}
+
+// startWait returns a channel closed when the tool's start finishes, or nil if it
+// isn't being started
+func (e *Executor) startWait(toolName string) chan struct{} {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.starting[toolName]
+}
+
+// spawnWorker starts one worker process using the tool's transport
+func (e *Executor) spawnWorker(tool *Tool) (*worker, error) {
+	if tool.Manifest.Runtime.Transport == "msgpack" {
 		return e.startMsgpack(tool)
 	}
 	return e.startRepl(tool)
 }
 
-// startRepl starts a tool with REPL transport
-func (e *Executor) startRepl(tool *Tool) error {
-	repl, err := tool.Env.NewREPLPythonProcess(nil, e.getEnv(), nil, nil)
+// startRepl starts a worker with REPL transport
+func (e *Executor) startRepl(tool *Tool) (*worker, error) {
+	pyEnv, env, err := e.getEnv(tool)
+	if err != nil {
+		return nil, err
+	}
+	repl, err := pyEnv.NewREPLPythonProcess(nil, env, nil, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create REPL: %w", err)
+		return nil, fmt.Errorf("failed to create REPL: %w", err)
 	}
 
 	// Initialize the service
 	entrypoint := filepath.Join(tool.Path, tool.Manifest.Runtime.Entrypoint)
-	if err := e.initializeService(repl, entrypoint, tool.Manifest.Runtime.StartupTimeout); err != nil {
+	if err := e.initializeService(context.Background(), repl, entrypoint, tool.Manifest.Runtime.StartupTimeout); err != nil {
 		repl.Close()
-		return fmt.Errorf("failed to initialize service: %w", err)
-	}
-
-	e.repls[tool.Name] = repl
-	tool.Status = "running"
-	tool.HealthStatus = "unknown"
-	tool.HealthFailures = 0
-
-	// Start health check if configured
-	if tool.Manifest.Health != nil {
-		ctx, cancel := context.WithCancel(context.Background())
-		e.healthCancels[tool.Name] = cancel
-		go e.runHealthCheck(ctx, tool)
+		return nil, fmt.Errorf("failed to initialize service: %w", err)
 	}
 
-	log.Printf("Started %s (REPL)", tool.Name)
-	return nil
+	return &worker{repl: repl}, nil
 }
 
-// startMsgpack starts a tool with MessagePack transport
-func (e *Executor) startMsgpack(tool *Tool) error {
+// startMsgpack starts a worker with MessagePack transport
+func (e *Executor) startMsgpack(tool *Tool) (*worker, error) {
 	entrypoint := filepath.Join(tool.Path, tool.Manifest.Runtime.Entrypoint)
 
 	// Create module from entrypoint
 	mainModule, err := jumpboot.NewModuleFromPath("__main__", entrypoint)
 	if err != nil {
-		return fmt.Errorf("failed to load entrypoint: %w", err)
+		return nil, fmt.Errorf("failed to load entrypoint: %w", err)
 	}
 
 	// Create program
@@ -375,36 +769,34 @@ func (e *Executor) startMsgpack(tool *Tool) error {
 	}
 
 	// Create queue process
-	queue, err := tool.Env.NewQueueProcess(program, nil, e.getEnv(), nil)
+	pyEnv, env, err := e.getEnv(tool)
 	if err != nil {
-		return fmt.Errorf("failed to create queue process: %w", err)
+		return nil, err
 	}
-
-	e.queues[tool.Name] = queue
-	tool.Status = "running"
-	tool.HealthStatus = "unknown"
-	tool.HealthFailures = 0
-
-	// Start health check if configured
-	if tool.Manifest.Health != nil {
-		ctx, cancel := context.WithCancel(context.Background())
-		e.healthCancels[tool.Name] = cancel
-		go e.runHealthCheckMsgpack(ctx, tool)
+	queue, err := pyEnv.NewQueueProcess(program, nil, env, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queue process: %w", err)
 	}
 
-	log.Printf("Started %s (MessagePack)", tool.Name)
-	return nil
+	return &worker{queue: queue}, nil
 }
 
 // Stop stops a persistent tool
 func (e *Executor) Stop(toolName string) error {
 	tool, ok := e.manager.Get(toolName)
 	if !ok {
-		return fmt.Errorf("tool not found: %s", toolName)
+		return newError(ErrCodeToolNotFound, toolName, "", "tool not found: %s", toolName)
 	}
+	return e.stopTool(tool)
+}
+
+// stopTool stops a persistent tool's workers and releases its resources
+func (e *Executor) stopTool(tool *Tool) error {
+	toolName := tool.Name
 
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	e.waitStartLocked(toolName)
 
 	// Cancel health check if running
 	if cancel, ok := e.healthCancels[toolName]; ok {
@@ -412,29 +804,36 @@ func (e *Executor) Stop(toolName string) error {
 		delete(e.healthCancels, toolName)
 	}
 
-	// Stop REPL if running
-	if repl, ok := e.repls[toolName]; ok {
-		repl.Close()
-		delete(e.repls, toolName)
-		tool.Status = "stopped"
-		tool.HealthStatus = ""
-		tool.HealthFailures = 0
-		log.Printf("Stopped %s (REPL)", toolName)
-		return nil
+	inst, ok := e.instances[toolName]
+	if !ok {
+		return newError(ErrCodeNotRunning, toolName, "", "tool %s is not running", toolName)
 	}
 
-	// Stop queue if running
-	if queue, ok := e.queues[toolName]; ok {
-		queue.Close()
-		delete(e.queues, toolName)
-		tool.Status = "stopped"
-		tool.HealthStatus = ""
-		tool.HealthFailures = 0
-		log.Printf("Stopped %s (MessagePack)", toolName)
-		return nil
+	started := time.Now()
+	inst.retire()
+	for _, w := range inst.snapshot() {
+		w.close()
 	}
+	e.metrics.stopDuration.Observe(time.Since(started).Seconds(), toolName)
+	delete(e.instances, toolName)
+	e.resources.release(toolName)
+	tool.Status = "stopped"
+	tool.HealthStatus = ""
+	tool.HealthFailures = 0
+	log.Printf("Stopped %s", toolName)
+	return nil
+}
 
-	return fmt.Errorf("tool %s is not running", toolName)
+// Running returns the names of the persistent tools that are running
+func (e *Executor) Running() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.instances))
+	for name := range e.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // Close stops all running tools
@@ -442,39 +841,43 @@ func (e *Executor) Close() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	// Let starts in progress finish, so their workers are closed too
+	for len(e.starting) > 0 {
+		for name := range e.starting {
+			e.waitStartLocked(name)
+			break
+		}
+	}
+
 	// Cancel all health checks
 	for _, cancel := range e.healthCancels {
 		cancel()
 	}
 	e.healthCancels = make(map[string]context.CancelFunc)
 
-	// Close all REPLs
-	for name, repl := range e.repls {
-		repl.Close()
-		if tool, ok := e.manager.Get(name); ok {
-			tool.Status = "stopped"
-			tool.HealthStatus = ""
+	// Close all workers
+	for name, inst := range e.instances {
+		inst.retire()
+		for _, w := range inst.snapshot() {
+			w.close()
 		}
-	}
-	e.repls = make(map[string]*jumpboot.REPLPythonProcess)
-
-	// Close all queues
-	for name, queue := range e.queues {
-		queue.Close()
+		e.resources.release(name)
 		if tool, ok := e.manager.Get(name); ok {
 			tool.Status = "stopped"
 			tool.HealthStatus = ""
 		}
 	}
-	e.queues = make(map[string]*jumpboot.QueueProcess)
+	e.instances = make(map[string]*instance)
 }
 
-// runHealthCheck runs periodic health checks for a tool
-func (e *Executor) runHealthCheck(ctx context.Context, tool *Tool) {
+// runHealthCheck runs periodic health checks for a tool.
+// Crossing the failure threshold hands the tool to the supervisor.
+func (e *Executor) runHealthCheck(ctx context.Context, tool *Tool, inst *instance) {
 	healthCfg := tool.Manifest.Health
 	interval := time.Duration(healthCfg.Interval) * time.Second
 	method := healthCfg.Method
 	threshold := healthCfg.FailureThreshold
+	timeout := time.Duration(healthCfg.Timeout) * time.Second
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -487,73 +890,25 @@ func (e *Executor) runHealthCheck(ctx context.Context, tool *Tool) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			err := e.doHealthCheck(tool, method)
-			if err != nil {
-				tool.HealthFailures++
-				if tool.HealthFailures >= threshold {
-					if tool.HealthStatus != "unhealthy" {
-						tool.HealthStatus = "unhealthy"
-						log.Printf("Health check failed for %s: %v (failures: %d)", tool.Name, err, tool.HealthFailures)
-					}
-				}
-			} else {
-				if tool.HealthStatus != "healthy" {
-					log.Printf("Health check passed for %s", tool.Name)
-				}
-				tool.HealthStatus = "healthy"
-				tool.HealthFailures = 0
+			err := e.doHealthCheck(tool, inst, method, timeout)
+			if errors.Is(err, errHealthBusy) {
+				continue
 			}
-		}
-	}
-}
-
-// doHealthCheck performs a single health check call using __jb_call__ (REPL)
-func (e *Executor) doHealthCheck(tool *Tool, method string) error {
-	e.mu.RLock()
-	repl, ok := e.repls[tool.Name]
-	e.mu.RUnlock()
-
-	if !ok || repl == nil {
-		return fmt.Errorf("tool not running")
-	}
-
-	// Call the health method via __jb_call__
-	result, err := e.doCall(repl, method, nil)
-	if err != nil {
-		return fmt.Errorf("health call failed: %w", err)
-	}
-
-	return e.checkHealthResult(result)
-}
-
-// runHealthCheckMsgpack runs periodic health checks for a tool (MessagePack transport)
-func (e *Executor) runHealthCheckMsgpack(ctx context.Context, tool *Tool) {
-	healthCfg := tool.Manifest.Health
-	interval := time.Duration(healthCfg.Interval) * time.Second
-	method := healthCfg.Method
-	threshold := healthCfg.FailureThreshold
-
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	// Initial health check after a short delay
-	time.Sleep(2 * time.Second)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			err := e.doHealthCheckMsgpack(tool, method)
 			if err != nil {
+				e.metrics.healthChecks.Inc(tool.Name, "fail")
 				tool.HealthFailures++
 				if tool.HealthFailures >= threshold {
 					if tool.HealthStatus != "unhealthy" {
 						tool.HealthStatus = "unhealthy"
 						log.Printf("Health check failed for %s: %v (failures: %d)", tool.Name, err, tool.HealthFailures)
+						if restartPolicy(tool) != "never" {
+							e.handleUnhealthy(tool, inst)
+							return
+						}
 					}
 				}
 			} else {
+				e.metrics.healthChecks.Inc(tool.Name, "pass")
 				if tool.HealthStatus != "healthy" {
 					log.Printf("Health check passed for %s", tool.Name)
 				}
@@ -564,23 +919,54 @@ func (e *Executor) runHealthCheckMsgpack(ctx context.Context, tool *Tool) {
 	}
 }
 
-// doHealthCheckMsgpack performs a single health check call (MessagePack)
-func (e *Executor) doHealthCheckMsgpack(tool *Tool, method string) error {
-	e.mu.RLock()
-	queue, ok := e.queues[tool.Name]
-	e.mu.RUnlock()
-
-	if !ok || queue == nil {
-		return fmt.Errorf("tool not running")
+// errHealthBusy means a health check couldn't get the workers it needed before its
+// timeout because calls were using them. It's neither a pass nor a failure.
+var errHealthBusy = errors.New("workers busy")
+
+// doHealthCheck calls the health method on the live workers of a tool, taking
+// them through the scheduler so a check never shares a process with a call. The
+// tool is healthy only if all of them pass. A worker that doesn't answer before
+// the timeout is aborted and replaced, like a timed-out call.
+func (e *Executor) doHealthCheck(tool *Tool, inst *instance, method string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Hold the workers until all are checked, so each slot brings a different one
+	var held []*worker
+	defer func() {
+		for _, w := range held {
+			inst.release(w)
+		}
+	}()
+	for n := inst.capacity(); len(held) < n; {
+		w, err := inst.acquire(ctx, tool.Name, method)
+		if errors.Is(err, errRetired) {
+			return fmt.Errorf("tool not running")
+		}
+		if err != nil {
+			return errHealthBusy
+		}
+		held = append(held, w)
 	}
 
-	// Call the health method
-	result, err := e.doQueueCall(queue, method, nil)
-	if err != nil {
-		return fmt.Errorf("health call failed: %w", err)
+	for i, w := range held {
+		abort := func() { e.abortWorker(tool, inst, w) }
+		var result interface{}
+		var err error
+		if w.queue != nil {
+			result, err = e.doQueueCall(ctx, w.queue, method, nil, abort)
+		} else {
+			result, err = e.doCall(ctx, w.repl, method, nil, abort)
+		}
+		if err != nil {
+			return fmt.Errorf("health call failed on worker %d: %w", i, err)
+		}
+		if err := e.checkHealthResult(result); err != nil {
+			return fmt.Errorf("worker %d: %w", i, err)
+		}
 	}
 
-	return e.checkHealthResult(result)
+	return nil
 }
 
 // checkHealthResult validates the health check response
@@ -601,20 +987,35 @@ func (e *Executor) checkHealthResult(result interface{}) error {
 }
 
 // GetSchema returns the schema for a tool (via __jb_schema__)
-func (e *Executor) GetSchema(toolName string) (map[string]interface{}, error) {
+func (e *Executor) GetSchema(ctx context.Context, toolName string) (map[string]interface{}, error) {
 	tool, ok := e.manager.Get(toolName)
 	if !ok {
-		return nil, fmt.Errorf("tool not found: %s", toolName)
+		return nil, newError(ErrCodeToolNotFound, toolName, "", "tool not found: %s", toolName)
 	}
 
-	// For persistent tools, use running REPL
+	// For persistent tools, use a running REPL worker, taking its turn like a call
 	if tool.Manifest.Runtime.Mode == "persistent" {
 		e.mu.RLock()
-		repl, ok := e.repls[toolName]
+		inst, ok := e.instances[toolName]
 		e.mu.RUnlock()
 
-		if ok && repl != nil {
-			return e.getSchemaFromRepl(repl)
+		if ok {
+			w, err := inst.acquire(ctx, toolName, "__jb_schema__")
+			if err != nil && !errors.Is(err, errRetired) {
+				return nil, err
+			}
+			if err == nil && w.repl == nil {
+				inst.release(w) // MessagePack workers don't serve __jb_schema__
+			} else if err == nil {
+				defer inst.release(w)
+				out, err := runWithContext(ctx, func() { e.abortWorker(tool, inst, w) }, func() (interface{}, error) {
+					return e.getSchemaFromRepl(w.repl)
+				})
+				if err != nil {
+					return nil, err
+				}
+				return out.(map[string]interface{}), nil
+			}
 		}
 	}
 
@@ -623,14 +1024,18 @@ func (e *Executor) GetSchema(toolName string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	repl, err := tool.Env.NewREPLPythonProcess(nil, e.getEnv(), nil, nil)
+	pyEnv, env, err := e.getEnv(tool)
+	if err != nil {
+		return nil, err
+	}
+	repl, err := pyEnv.NewREPLPythonProcess(nil, env, nil, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer repl.Close()
 
 	entrypoint := filepath.Join(tool.Path, tool.Manifest.Runtime.Entrypoint)
-	if err := e.initializeService(repl, entrypoint, tool.Manifest.Runtime.StartupTimeout); err != nil {
+	if err := e.initializeService(ctx, repl, entrypoint, tool.Manifest.Runtime.StartupTimeout); err != nil {
 		return nil, err
 	}
 
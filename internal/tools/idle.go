@@ -0,0 +1,94 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// autostart starts a stopped persistent tool for a call and waits until it is ready.
+// If ctx ends first the call gives up, but the start carries on in the background.
+func (e *Executor) autostart(ctx context.Context, tool *Tool, methodName string) error {
+	switch tool.Status {
+	case "crashed", "crash-loop":
+		return newError(ErrCodeUnavailable, tool.Name, methodName, "tool %s is %s: %s", tool.Name, tool.Status, tool.LastExitReason)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		log.Printf("Autostarting %s for %s", tool.Name, methodName)
+		done <- e.start(tool.Name, false)
+	}()
+
+	select {
+	case err := <-done:
+		// Another call may have started it first
+		if ce, ok := err.(*CallError); ok && ce.Code == ErrCodeAlreadyRunning {
+			return nil
+		}
+		if ce, ok := err.(*CallError); ok {
+			return AsCallError(ce, tool.Name, methodName)
+		}
+		if err != nil {
+			return newError(ErrCodeUnavailable, tool.Name, methodName, "failed to start %s: %v", tool.Name, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// runIdleMonitor stops a persistent tool once it has gone idle_timeout seconds without a call
+func (e *Executor) runIdleMonitor(tool *Tool, inst *instance) {
+	timeout := time.Duration(tool.Manifest.Runtime.IdleTimeout) * time.Second
+
+	// Check often enough to stop within a few percent of the timeout
+	interval := timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !e.isCurrent(tool.Name, inst) {
+			return
+		}
+		if e.unloadIfIdle(tool, inst, timeout, fmt.Sprintf("after %v idle", timeout)) {
+			return
+		}
+	}
+}
+
+// unloadIfIdle shuts down an instance that has gone d without a call. It's retired
+// and removed from e.instances in one step, so calls never find it retired but
+// still current. Returns false if it's busy, was used recently or is already gone.
+func (e *Executor) unloadIfIdle(tool *Tool, inst *instance, d time.Duration, reason string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.instances[tool.Name] != inst || !inst.retireIfIdle(d) {
+		return false
+	}
+	if cancel, ok := e.healthCancels[tool.Name]; ok {
+		cancel()
+		delete(e.healthCancels, tool.Name)
+	}
+	started := time.Now()
+	for _, w := range inst.snapshot() {
+		w.close()
+	}
+	e.metrics.stopDuration.Observe(time.Since(started).Seconds(), tool.Name)
+	delete(e.instances, tool.Name)
+	e.resources.release(tool.Name)
+	tool.Status = "stopped"
+	tool.HealthStatus = ""
+	tool.HealthFailures = 0
+	log.Printf("Stopped %s %s", tool.Name, reason)
+	return true
+}
@@ -0,0 +1,254 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/richinsley/jumpboot"
+)
+
+// isLocalSource reports whether an install source is a filesystem path rather than a git URL
+func isLocalSource(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, "./") || strings.HasPrefix(source, "~")
+}
+
+// progressLogger adapts jumpboot progress callbacks to log lines, printing each
+// distinct message once
+func progressLogger(out io.Writer) jumpboot.ProgressCallback {
+	last := ""
+	return func(message string, current, total int64) {
+		if message == last {
+			return
+		}
+		last = message
+		fmt.Fprintln(out, message)
+	}
+}
+
+// Uninstall removes a tool's directory (or symlink, for local installs) and, if
+// removeEnv is set, its jumpboot environment unless another tool shares it. It
+// doesn't stop the tool; Executor.Uninstall does that first.
+func (m *Manager) Uninstall(name string, removeEnv bool) error {
+	m.mu.Lock()
+	tool, ok := m.tools[name]
+	if ok {
+		delete(m.tools, name)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return newError(ErrCodeToolNotFound, name, "", "tool not found: %s", name)
+	}
+
+	// RemoveAll removes a symlink itself, never the local source it points to
+	if err := os.RemoveAll(tool.Path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", tool.Path, err)
+	}
+
+	// The link is only a reference; the environment it points to may be shared
+	envLink := filepath.Join(m.envsDir(), envLinkName(name))
+	if target, err := os.Readlink(envLink); err == nil {
+		os.Remove(envLink)
+		if removeEnv {
+			m.releaseEnv(filepath.Base(target))
+		}
+	} else if removeEnv {
+		if err := os.RemoveAll(envLink); err != nil {
+			return fmt.Errorf("failed to remove environment: %w", err)
+		}
+	}
+	err := m.updateLock(func(lock *Lockfile) {
+		delete(lock.Tools, name)
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update %s: %v", m.cfg.LockPath(), err)
+	}
+	return nil
+}
+
+// pullLatest updates a shallow git checkout to the tip of ref, or of the remote's
+// default branch if ref is empty
+func pullLatest(dir, ref string, out io.Writer) error {
+	fmt.Fprintf(out, "Fetching latest %s...\n", filepath.Base(dir))
+	fetch := []string{"fetch", "--depth", "1", "--progress", "origin"}
+	if ref != "" {
+		fetch = append(fetch, ref)
+	}
+	return runGit(dir, out, fetch, []string{"reset", "--hard", "FETCH_HEAD"})
+}
+
+// cloneRef clones gitURL into dir and checks out ref. Tags and branches get a
+// shallow clone; a commit SHA is fetched on its own where the server allows it,
+// otherwise from a full clone.
+func cloneRef(gitURL, ref, dir string, out io.Writer) error {
+	if ref == "" {
+		fmt.Fprintf(out, "Cloning %s...\n", gitURL)
+		return runGit("", out, []string{"clone", "--depth", "1", "--progress", gitURL, dir})
+	}
+
+	fmt.Fprintf(out, "Cloning %s at %s...\n", gitURL, ref)
+	if runGit("", out, []string{"clone", "--depth", "1", "--progress", "--branch", ref, gitURL, dir}) == nil {
+		return nil
+	}
+
+	// Not a tag or branch; try it as a commit
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	err := runGit(dir, out,
+		[]string{"init", "--quiet"},
+		[]string{"remote", "add", "origin", gitURL},
+		[]string{"fetch", "--depth", "1", "--progress", "origin", ref},
+		[]string{"checkout", "--quiet", "--detach", "FETCH_HEAD"})
+	if err == nil {
+		return nil
+	}
+	fmt.Fprintf(out, "Server won't serve %s directly; fetching full history\n", ref)
+	if err := runGit(dir, out, []string{"fetch", "--progress", "origin"}); err != nil {
+		return err
+	}
+	if err := runGit(dir, out, []string{"checkout", "--quiet", "--detach", ref}); err != nil {
+		return fmt.Errorf("ref %s not found in %s", ref, gitURL)
+	}
+	return nil
+}
+
+// runGit runs git commands in dir (or the current directory if empty), stopping
+// at the first failure
+func runGit(dir string, out io.Writer, commands ...[]string) error {
+	for _, args := range commands {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s failed: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// gitOutput runs a git command in dir and returns its trimmed output
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Install installs a tool and makes it available immediately, with packages pinned
+// to a lockfile entry if pins is set. Reloads wait until the install finishes so
+// they never see a half-installed tool. Install, Uninstall and Upgrade report their
+// change to the OnReload hook.
+func (e *Executor) Install(source string, pins *LockedTool, out io.Writer) (*Tool, error) {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	tool, err := e.manager.InstallPinned(source, pins, out)
+	if err != nil {
+		return nil, err
+	}
+	e.notifyReload(&ReloadResult{Added: []string{tool.Name}})
+	return tool, nil
+}
+
+// Uninstall stops a tool if it's running and removes it. Calls to it wait until
+// it's gone rather than starting it again.
+func (e *Executor) Uninstall(name string, removeEnv bool) error {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	tool, ok := e.manager.Get(name)
+	if !ok {
+		return newError(ErrCodeToolNotFound, name, "", "tool not found: %s", name)
+	}
+	release := e.holdTool(name)
+	defer release()
+	if tool.Manifest.Runtime.Mode == "persistent" {
+		if _, err := e.stopIfRunning(tool); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", name, err)
+		}
+	}
+
+	e.mu.Lock()
+	e.resetRestarts(name)
+	e.mu.Unlock()
+
+	if err := e.manager.Uninstall(name, removeEnv); err != nil {
+		return err
+	}
+	e.notifyReload(&ReloadResult{Removed: []string{name}})
+	return nil
+}
+
+// Upgrade pulls the latest version of a git-installed tool (local installs are
+// re-read in place), then rebuilds its environment and reruns setup in staging
+// while the old version keeps serving. A running tool is then stopped, swapped and
+// started again; calls wait for the swap instead of starting the old version. If
+// the new version fails to start, the old one is put back and restarted.
+func (e *Executor) Upgrade(name string, out io.Writer) (*Tool, error) {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	st, err := e.manager.stageUpgrade(name, out)
+	if err != nil {
+		return nil, err
+	}
+
+	release := e.holdTool(name)
+	defer release()
+	running, err := e.stopIfRunning(st.upgrade)
+	if err != nil {
+		st.discard()
+		return nil, newError(ErrCodeInstallFailed, name, "", "failed to stop %s to swap in the new version: %v", name, err)
+	}
+	if running {
+		fmt.Fprintf(out, "Stopped %s to swap in the new version\n", name)
+	}
+
+	if err := st.commit(); err != nil {
+		st.discard()
+		if running {
+			if startErr := e.start(name, true); startErr != nil {
+				return nil, newError(ErrCodeInstallFailed, name, "", "%v; restarting the previous version also failed, so %s is stopped: %v", err, name, startErr)
+			}
+		}
+		return nil, newError(ErrCodeInstallFailed, name, "", "%v", err)
+	}
+	tool := st.tool
+
+	if running {
+		fmt.Fprintf(out, "Starting %s v%s\n", name, tool.Manifest.Version)
+		if err := e.start(name, true); err != nil {
+			fmt.Fprintf(out, "New version failed to start (%v); restoring the previous version\n", err)
+			st.discard()
+			if startErr := e.start(name, true); startErr != nil {
+				fmt.Fprintf(out, "Previous version failed to start too (%v); %s is stopped\n", startErr, name)
+				return nil, newError(ErrCodeInstallFailed, name, "", "upgraded version failed to start: %v; restarting the previous version also failed, so %s is stopped: %v", err, name, startErr)
+			}
+			return nil, newError(ErrCodeInstallFailed, name, "", "upgraded version failed to start: %v", err)
+		}
+	}
+
+	st.finish(out)
+	e.notifyReload(&ReloadResult{Updated: []string{name}})
+	fmt.Fprintf(out, "Upgraded %s to v%s\n", name, tool.Manifest.Version)
+	return tool, nil
+}
+
+// stopIfRunning stops a persistent tool, reporting whether it was running
+func (e *Executor) stopIfRunning(tool *Tool) (bool, error) {
+	err := e.stopTool(tool)
+	if ce, ok := err.(*CallError); ok && ce.Code == ErrCodeNotRunning {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,228 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/richinsley/jumpboot"
+	"gopkg.in/yaml.v3"
+)
+
+// lockHeader starts every lockfile written by jb-serve
+const lockHeader = "# Written by jb-serve on every install, upgrade and uninstall.\n" +
+	"# Reproduce these tools on another machine with: jb-serve install --from-lock <file>\n"
+
+// LockedTool records where an installed tool came from and what it resolved to.
+type LockedTool struct {
+	Source      string    `yaml:"source" json:"source"`                     // Git URL or local path, without @ref
+	Ref         string    `yaml:"ref,omitempty" json:"ref,omitempty"`       // Requested tag, branch or commit
+	Commit      string    `yaml:"commit,omitempty" json:"commit,omitempty"` // Resolved commit SHA
+	Version     string    `yaml:"version" json:"version"`                   // Manifest version
+	Python      string    `yaml:"python,omitempty" json:"python,omitempty"` // Resolved Python version
+	InstalledAt time.Time `yaml:"installed_at" json:"installed_at"`
+	Pip         []string  `yaml:"pip,omitempty" json:"pip,omitempty"`     // pip freeze, name==version
+	Conda       []string  `yaml:"conda,omitempty" json:"conda,omitempty"` // name=version=build
+}
+
+// PinnedSource is the source to install this exact commit from: url@commit for git
+// installs, or the recorded path for local ones.
+func (t *LockedTool) PinnedSource() string {
+	if t.Commit == "" || isLocalSource(t.Source) {
+		return t.Source
+	}
+	return t.Source + "@" + t.Commit
+}
+
+// Lockfile is the provenance of every installed tool, keyed by tool name.
+type Lockfile struct {
+	Tools map[string]*LockedTool `yaml:"tools" json:"tools"`
+}
+
+// ReadLockfile loads a lockfile. A missing file is an empty lockfile.
+func ReadLockfile(path string) (*Lockfile, error) {
+	lock := &Lockfile{Tools: make(map[string]*LockedTool)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lock, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+	if lock.Tools == nil {
+		lock.Tools = make(map[string]*LockedTool)
+	}
+	return lock, nil
+}
+
+// Write saves the lockfile, replacing the old one atomically.
+func (l *Lockfile) Write(path string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append([]byte(lockHeader), data...), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Names returns the locked tool names in sorted order.
+func (l *Lockfile) Names() []string {
+	names := make([]string, 0, len(l.Tools))
+	for name := range l.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitRef separates an optional @ref suffix from a git source:
+// github.com/org/tool@v1.2 gives ("github.com/org/tool", "v1.2"). The user part of
+// git@host:org/tool and https://user@host/... is not mistaken for a ref.
+func splitRef(source string) (string, string) {
+	i := strings.LastIndex(source, "@")
+	if i <= 0 || i == len(source)-1 {
+		return source, ""
+	}
+	base, ref := source[:i], source[i+1:]
+	if j := strings.Index(base, "://"); j >= 0 {
+		base = base[j+3:]
+	}
+	if !strings.ContainsAny(base, "/:") || strings.Contains(ref, ":") {
+		return source, ""
+	}
+	return source[:i], ref
+}
+
+// Lockfile returns the provenance of every installed tool.
+func (m *Manager) Lockfile() (*Lockfile, error) {
+	m.lockMu.Lock()
+	defer m.lockMu.Unlock()
+	return ReadLockfile(m.cfg.LockPath())
+}
+
+// Locked returns the lockfile entry for a tool, or nil if it has none
+func (m *Manager) Locked(name string) *LockedTool {
+	lock, err := m.Lockfile()
+	if err != nil {
+		return nil
+	}
+	return lock.Tools[name]
+}
+
+// updateLock applies fn to the lockfile and writes it back
+func (m *Manager) updateLock(fn func(lock *Lockfile)) error {
+	m.lockMu.Lock()
+	defer m.lockMu.Unlock()
+
+	lock, err := ReadLockfile(m.cfg.LockPath())
+	if err != nil {
+		return err
+	}
+	fn(lock)
+	return lock.Write(m.cfg.LockPath())
+}
+
+// recordLock writes a tool's provenance after an install or upgrade. Failing to
+// resolve package versions is reported to out but doesn't fail the install.
+func (m *Manager) recordLock(tool *Tool, source, ref string, out io.Writer) {
+	entry := &LockedTool{
+		Source:      source,
+		Ref:         ref,
+		Commit:      gitCommit(tool.Path),
+		Version:     tool.Manifest.Version,
+		InstalledAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if tool.Env != nil {
+		entry.Python = tool.Env.PythonVersion.String()
+		pip, conda, err := freezeEnv(tool.Env)
+		if err != nil {
+			fmt.Fprintf(out, "Warning: couldn't resolve package versions for the lockfile: %v\n", err)
+		}
+		entry.Pip, entry.Conda = pip, conda
+	}
+
+	err := m.updateLock(func(lock *Lockfile) {
+		lock.Tools[tool.Name] = entry
+	})
+	if err != nil {
+		fmt.Fprintf(out, "Warning: failed to update %s: %v\n", m.cfg.LockPath(), err)
+	}
+}
+
+// gitCommit returns the checked-out commit of a git working tree, or "" if the
+// directory isn't one
+func gitCommit(dir string) string {
+	commit, _ := gitOutput(dir, "rev-parse", "HEAD")
+	return commit
+}
+
+// freezeEnv lists the exact pip and conda packages installed in an environment
+func freezeEnv(env *jumpboot.PythonEnvironment) (pip, conda []string, err error) {
+	tmp, err := os.CreateTemp("", "jb-serve-freeze-*.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := env.FreezeToFile(tmp.Name()); err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	var spec jumpboot.EnvironmentSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, nil, err
+	}
+
+	for _, pkg := range spec.PipPackages {
+		// Editable installs point at paths on this machine
+		if !strings.HasPrefix(pkg, "-e ") {
+			pip = append(pip, pkg)
+		}
+	}
+	return pip, spec.CondaPackages, nil
+}
+
+// pinnedConda returns the locked spec for each conda package the manifest asks
+// for, falling back to the manifest's own spec for packages the lock doesn't have
+func pinnedConda(requested, locked []string) []string {
+	byName := make(map[string]string, len(locked))
+	for _, spec := range locked {
+		byName[condaName(spec)] = spec
+	}
+
+	pinned := make([]string, len(requested))
+	for i, spec := range requested {
+		if lockedSpec, ok := byName[condaName(spec)]; ok {
+			pinned[i] = lockedSpec
+		} else {
+			pinned[i] = spec
+		}
+	}
+	return pinned
+}
+
+// condaName returns the package name from a conda match spec like numpy>=1.26
+func condaName(spec string) string {
+	if i := strings.IndexAny(spec, "=<>!~ "); i >= 0 {
+		spec = spec[:i]
+	}
+	return strings.ToLower(spec)
+}
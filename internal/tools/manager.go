@@ -3,10 +3,11 @@ package tools
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/calobozan/jb-serve/internal/config"
 	"github.com/richinsley/jumpboot"
@@ -22,22 +23,42 @@ type Tool struct {
 	Status         string                      `json:"status"`           // "stopped", "running"
 	HealthStatus   string                      `json:"health_status"`    // "healthy", "unhealthy", "unknown"
 	HealthFailures int                         `json:"health_failures"`  // Consecutive health check failures
+	RestartCount   int                         `json:"restart_count"`    // Supervisor restarts since last manual start
+	LastExitReason string                      `json:"last_exit_reason,omitempty"`
 	PID            int                         `json:"pid,omitempty"`
 	Port           int                         `json:"port,omitempty"`
+
+	manifestSum   string // Digest of jumpboot.yaml when last loaded
+	entrypointSum string // Digest of the entrypoint script when last loaded
 }
 
 // Manager handles tool lifecycle using jumpboot
 type Manager struct {
 	cfg   *config.Config
 	tools map[string]*Tool
+	mu    sync.RWMutex
+
+	lockMu sync.Mutex // Serializes lockfile updates
+
+	envMu    sync.Mutex
+	envLocks map[string]*sync.Mutex // Serializes building and deleting each shared environment
+
+	redactor *Redactor // Masks secret values in logs
 }
 
 // NewManager creates a new tool manager
 func NewManager(cfg *config.Config) *Manager {
-	return &Manager{
-		cfg:   cfg,
-		tools: make(map[string]*Tool),
+	m := &Manager{
+		cfg:      cfg,
+		tools:    make(map[string]*Tool),
+		envLocks: make(map[string]*sync.Mutex),
+		redactor: &Redactor{},
+	}
+	// Know the secret values before anything is logged
+	if _, err := m.secrets(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
+	return m
 }
 
 // LoadAll scans the tools directory and loads all manifests
@@ -50,7 +71,14 @@ func (m *Manager) LoadAll() error {
 		return err
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	for _, entry := range entries {
+		// Skip staging and other hidden directories
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
 		toolPath := filepath.Join(m.cfg.ToolsDir, entry.Name())
 
 		// Follow symlinks
@@ -65,12 +93,14 @@ func (m *Manager) LoadAll() error {
 			continue
 		}
 
-		m.tools[manifest.Name] = &Tool{
+		tool := &Tool{
 			Name:     manifest.Name,
 			Path:     toolPath,
 			Manifest: manifest,
 			Status:   "stopped",
 		}
+		tool.manifestSum, tool.entrypointSum = toolDigests(toolPath, manifest)
+		m.tools[manifest.Name] = tool
 	}
 
 	return nil
@@ -90,111 +120,68 @@ func (m *Manager) loadManifest(toolPath string) (*config.Manifest, error) {
 	}
 
 	manifest.ApplyDefaults()
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
 	return &manifest, nil
 }
 
-// Install installs a tool from git URL or local path
+// Install installs a tool from git URL or local path, printing progress to stdout
 func (m *Manager) Install(source string) (*Tool, error) {
-	var toolPath string
-	var err error
-
-	// Determine if source is local or git
-	if strings.HasPrefix(source, "/") || strings.HasPrefix(source, "./") || strings.HasPrefix(source, "~") {
-		toolPath, err = m.installLocal(source)
-	} else {
-		toolPath, err = m.installGit(source)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	// Load manifest
-	manifest, err := m.loadManifest(toolPath)
-	if err != nil {
-		os.RemoveAll(toolPath)
-		return nil, fmt.Errorf("invalid manifest: %w", err)
-	}
-
-	// Check if already installed
-	if existing, ok := m.tools[manifest.Name]; ok {
-		os.RemoveAll(toolPath)
-		return nil, fmt.Errorf("tool %s already installed at %s", manifest.Name, existing.Path)
-	}
-
-	// Create jumpboot environment
-	fmt.Printf("Creating Python %s environment for %s...\n", manifest.Runtime.Python, manifest.Name)
-	env, err := m.createEnvironment(manifest)
-	if err != nil {
-		os.RemoveAll(toolPath)
-		return nil, fmt.Errorf("failed to create environment: %w", err)
-	}
-
-	// Install packages if environment is new
-	if env.IsNew {
-		if err := m.installPackages(env, manifest, toolPath); err != nil {
-			return nil, fmt.Errorf("failed to install packages: %w", err)
-		}
-	}
-
-	tool := &Tool{
-		Name:     manifest.Name,
-		Path:     toolPath,
-		Manifest: manifest,
-		Env:      env,
-		Status:   "stopped",
-	}
-
-	// Run setup if defined (downloads models, etc.)
-	if manifest.Setup != nil {
-		fmt.Printf("Running setup for %s (this may take a while for model downloads)...\n", manifest.Name)
-		if err := m.runSetup(tool); err != nil {
-			os.RemoveAll(toolPath)
-			return nil, fmt.Errorf("setup failed: %w", err)
-		}
-		fmt.Printf("Setup complete for %s\n", manifest.Name)
-	}
-
-	m.tools[manifest.Name] = tool
+	return m.InstallWithLog(source, os.Stdout)
+}
 
-	fmt.Printf("Installed %s v%s\n", manifest.Name, manifest.Version)
-	return tool, nil
+// InstallWithLog installs a tool from git URL (optionally pinned with @ref) or
+// local path, writing progress (git, micromamba, pip and setup output) to out
+func (m *Manager) InstallWithLog(source string, out io.Writer) (*Tool, error) {
+	return m.InstallPinned(source, nil, out)
 }
 
-// createEnvironment creates a jumpboot environment for a tool
-func (m *Manager) createEnvironment(manifest *config.Manifest) (*jumpboot.PythonEnvironment, error) {
-	envName := fmt.Sprintf("tool-%s", manifest.Name)
-	
-	// Use jumpboot to create isolated Python environment
-	env, err := jumpboot.CreateEnvironmentMamba(
-		envName,
-		m.cfg.EnvsDir,
-		manifest.Runtime.Python,
-		"conda-forge",
-		nil, // progress callback
-	)
+// InstallPinned installs a tool like InstallWithLog, with its pip and conda
+// packages pinned to the versions recorded in a lockfile entry. nil pins installs
+// the manifest's packages as usual. The tool is built in a staging directory and
+// environment and only moved into place once packages and setup succeed; a failed
+// install leaves nothing behind.
+func (m *Manager) InstallPinned(source string, pins *LockedTool, out io.Writer) (*Tool, error) {
+	st, err := m.stageInstall(source, pins, out)
 	if err != nil {
 		return nil, err
 	}
+	if err := st.commit(); err != nil {
+		st.discard()
+		return nil, err
+	}
+	st.finish(out)
 
-	return env, nil
+	fmt.Fprintf(out, "Installed %s v%s\n", st.tool.Name, st.tool.Manifest.Version)
+	return st.tool, nil
 }
 
-// installPackages installs pip/conda packages into the environment
-func (m *Manager) installPackages(env *jumpboot.PythonEnvironment, manifest *config.Manifest, toolPath string) error {
-	// Install conda packages first (one at a time via micromamba)
+// installPackages installs pip/conda packages into the environment from src. With
+// pins, conda packages use their locked builds and the locked pip freeze replaces
+// the manifest's pip packages and requirements.
+func (m *Manager) installPackages(env *jumpboot.PythonEnvironment, manifest *config.Manifest, toolPath string, pins *LockedTool, src *packageSources, out io.Writer) error {
+	// Install conda packages first
 	if len(manifest.Runtime.CondaPackages) > 0 {
-		fmt.Printf("Installing conda packages: %v\n", manifest.Runtime.CondaPackages)
-		for _, pkg := range manifest.Runtime.CondaPackages {
-			if err := env.MicromambaInstallPackage(pkg, "conda-forge"); err != nil {
-				return err
-			}
+		condaPackages := manifest.Runtime.CondaPackages
+		if pins != nil {
+			condaPackages = pinnedConda(condaPackages, pins.Conda)
+		}
+		fmt.Fprintf(out, "Installing conda packages: %v\n", condaPackages)
+		if err := m.condaInstall(env, condaPackages, src, out); err != nil {
+			return err
 		}
 	}
 
+	if pins != nil && len(pins.Pip) > 0 {
+		fmt.Fprintf(out, "Installing %d pinned pip packages from the lockfile\n", len(pins.Pip))
+		return src.pipInstall(env, out, pins.Pip...)
+	}
+
 	// Install pip packages
 	if len(manifest.Runtime.Packages) > 0 {
-		fmt.Printf("Installing pip packages: %v\n", manifest.Runtime.Packages)
-		if err := env.PipInstallPackages(manifest.Runtime.Packages, "", "", false, nil); err != nil {
+		fmt.Fprintf(out, "Installing pip packages: %v\n", manifest.Runtime.Packages)
+		if err := src.pipInstall(env, out, manifest.Runtime.Packages...); err != nil {
 			return err
 		}
 	}
@@ -203,8 +190,8 @@ func (m *Manager) installPackages(env *jumpboot.PythonEnvironment, manifest *con
 	if manifest.Runtime.Requirements != "" {
 		reqPath := filepath.Join(toolPath, manifest.Runtime.Requirements)
 		if _, err := os.Stat(reqPath); err == nil {
-			fmt.Printf("Installing from %s\n", manifest.Runtime.Requirements)
-			if err := env.PipInstallRequirements(reqPath, nil); err != nil {
+			fmt.Fprintf(out, "Installing from %s\n", manifest.Runtime.Requirements)
+			if err := src.pipInstall(env, out, "-r", reqPath); err != nil {
 				return err
 			}
 		}
@@ -232,7 +219,11 @@ func (m *Manager) runSetupRepl(tool *Tool, method string, timeout int) error {
 	entrypoint := filepath.Join(tool.Path, tool.Manifest.Runtime.Entrypoint)
 
 	// Create a REPL process
-	repl, err := tool.Env.NewREPLPythonProcess(nil, nil, nil, nil)
+	pyEnv, env, err := m.processEnv(tool)
+	if err != nil {
+		return err
+	}
+	repl, err := pyEnv.NewREPLPythonProcess(nil, env, nil, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create REPL: %w", err)
 	}
@@ -293,7 +284,11 @@ func (m *Manager) runSetupMsgpack(tool *Tool, method string, timeout int) error
 	}
 
 	// Create queue process
-	queue, err := tool.Env.NewQueueProcess(program, nil, nil, nil)
+	pyEnv, env, err := m.processEnv(tool)
+	if err != nil {
+		return err
+	}
+	queue, err := pyEnv.NewQueueProcess(program, nil, env, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create queue process: %w", err)
 	}
@@ -308,89 +303,30 @@ func (m *Manager) runSetupMsgpack(tool *Tool, method string, timeout int) error
 	return nil
 }
 
-// installLocal symlinks a local tool
-func (m *Manager) installLocal(source string) (string, error) {
-	// Expand ~
-	if strings.HasPrefix(source, "~") {
-		home, _ := os.UserHomeDir()
-		source = filepath.Join(home, source[1:])
-	}
-
-	absSource, err := filepath.Abs(source)
-	if err != nil {
-		return "", err
-	}
-
-	// Check manifest exists
-	if _, err := os.Stat(filepath.Join(absSource, "jumpboot.yaml")); err != nil {
-		return "", fmt.Errorf("no jumpboot.yaml found at %s", source)
-	}
-
-	// Load manifest to get name
-	manifest, err := m.loadManifest(absSource)
-	if err != nil {
-		return "", err
+// gitURL turns a bare source like github.com/org/tool into a clonable URL
+func gitURL(source string) string {
+	if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "git@") {
+		return source
 	}
-
-	toolPath := filepath.Join(m.cfg.ToolsDir, manifest.Name)
-	os.RemoveAll(toolPath)
-
-	if err := os.Symlink(absSource, toolPath); err != nil {
-		return "", fmt.Errorf("failed to create symlink: %w", err)
+	url := "https://" + source
+	if !strings.Contains(url, ".git") {
+		url += ".git"
 	}
-
-	return toolPath, nil
-}
-
-// installGit clones a tool from git
-func (m *Manager) installGit(source string) (string, error) {
-	gitURL := source
-	if !strings.HasPrefix(gitURL, "https://") && !strings.HasPrefix(gitURL, "git@") {
-		gitURL = "https://" + source
-		if !strings.Contains(gitURL, ".git") {
-			gitURL += ".git"
-		}
-	}
-
-	tempDir, err := os.MkdirTemp("", "jb-serve-install-")
-	if err != nil {
-		return "", err
-	}
-
-	fmt.Printf("Cloning %s...\n", gitURL)
-	cmd := exec.Command("git", "clone", "--depth", "1", gitURL, tempDir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		os.RemoveAll(tempDir)
-		return "", fmt.Errorf("git clone failed: %w", err)
-	}
-
-	manifest, err := m.loadManifest(tempDir)
-	if err != nil {
-		os.RemoveAll(tempDir)
-		return "", err
-	}
-
-	toolPath := filepath.Join(m.cfg.ToolsDir, manifest.Name)
-	os.RemoveAll(toolPath)
-
-	if err := os.Rename(tempDir, toolPath); err != nil {
-		os.RemoveAll(tempDir)
-		return "", fmt.Errorf("failed to move tool: %w", err)
-	}
-
-	return toolPath, nil
+	return url
 }
 
 // Get returns a tool by name
 func (m *Manager) Get(name string) (*Tool, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	t, ok := m.tools[name]
 	return t, ok
 }
 
 // List returns all installed tools
 func (m *Manager) List() []*Tool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	tools := make([]*Tool, 0, len(m.tools))
 	for _, t := range m.tools {
 		tools = append(tools, t)
@@ -412,17 +348,24 @@ type ToolInfo struct {
 	Mode         string                   `json:"mode"`
 	Status       string                   `json:"status"`
 	HealthStatus string                   `json:"health_status,omitempty"`
+	RestartCount int                      `json:"restart_count,omitempty"`
+	LastExit     string                   `json:"last_exit_reason,omitempty"`
+	Source       string                   `json:"source,omitempty"` // From the lockfile
+	Commit       string                   `json:"commit,omitempty"`
+	NeedsRebuild bool                     `json:"needs_rebuild,omitempty"` // Dependencies changed since the environment was built
+	Env          map[string]string        `json:"env,omitempty"`           // Secret values are redacted
+	Sandbox      *config.Sandbox          `json:"sandbox,omitempty"`       // Policy the tool's processes run under, nil if unsandboxed
 	Methods      map[string]config.Method `json:"methods"`
 }
 
 // Info returns detailed info about a tool
 func (m *Manager) Info(name string) (*ToolInfo, error) {
-	tool, ok := m.tools[name]
+	tool, ok := m.Get(name)
 	if !ok {
 		return nil, fmt.Errorf("tool not found: %s", name)
 	}
 
-	return &ToolInfo{
+	info := &ToolInfo{
 		Name:         tool.Manifest.Name,
 		Version:      tool.Manifest.Version,
 		Description:  tool.Manifest.Description,
@@ -430,8 +373,18 @@ func (m *Manager) Info(name string) (*ToolInfo, error) {
 		Mode:         tool.Manifest.Runtime.Mode,
 		Status:       tool.Status,
 		HealthStatus: tool.HealthStatus,
+		RestartCount: tool.RestartCount,
+		LastExit:     m.redactor.Redact(tool.LastExitReason),
+		NeedsRebuild: m.NeedsRebuild(tool),
+		Env:          m.redactedEnv(tool),
+		Sandbox:      m.sandboxPolicy(tool),
 		Methods:      tool.Manifest.RPC.Methods,
-	}, nil
+	}
+	if entry := m.Locked(name); entry != nil {
+		info.Source = entry.Source
+		info.Commit = entry.Commit
+	}
+	return info, nil
 }
 
 // EnsureEnvironment loads or creates the jumpboot environment for a tool
@@ -440,17 +393,33 @@ func (m *Manager) EnsureEnvironment(tool *Tool) error {
 		return nil
 	}
 
-	env, err := m.createEnvironment(tool.Manifest)
-	if err != nil {
-		return err
-	}
+	// No environment yet (or its target was pruned): find or build the shared one
+	envLink := filepath.Join(m.envsDir(), envLinkName(tool.Name))
+	if _, err := os.Stat(envLink); err != nil {
+		spec := envSpec(tool.Manifest, tool.Path, nil)
 
-	if env.IsNew {
-		if err := m.installPackages(env, tool.Manifest, tool.Path); err != nil {
+		// Link it before unlocking, so a prune never sees it unused
+		unlock := m.lockEnv(sharedEnvName(spec))
+		defer unlock()
+		env, _, err := m.ensureSharedEnvLocked(spec, tool.Manifest, tool.Path, nil, m.packageSources(), os.Stdout)
+		if err != nil {
 			return err
 		}
+		os.Remove(envLink)
+		if err := os.Symlink(sharedEnvName(spec), envLink); err != nil {
+			return fmt.Errorf("failed to link environment: %w", err)
+		}
+		tool.Env = env
+		return nil
 	}
 
+	if m.NeedsRebuild(tool) {
+		fmt.Fprintf(os.Stderr, "Warning: dependencies of %s changed since its environment was built; run: jb-serve env rebuild %s\n", tool.Name, tool.Name)
+	}
+	env, err := m.createEnvironment(envLinkName(tool.Name), tool.Manifest, os.Stdout)
+	if err != nil {
+		return err
+	}
 	tool.Env = env
 	return nil
 }
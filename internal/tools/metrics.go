@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/metrics"
+)
+
+// executorMetrics instruments calls and the tool lifecycle
+type executorMetrics struct {
+	registry *metrics.Registry
+
+	calls         *metrics.CounterVec
+	callDuration  *metrics.HistogramVec
+	inFlight      *metrics.GaugeVec
+	healthChecks  *metrics.CounterVec
+	startDuration *metrics.HistogramVec
+	stopDuration  *metrics.HistogramVec
+}
+
+// lifecycleBuckets cover process startup, which includes model loading
+var lifecycleBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+func newExecutorMetrics(e *Executor) *executorMetrics {
+	r := metrics.NewRegistry()
+	m := &executorMetrics{
+		registry: r,
+		calls: r.Counter("jb_calls_total",
+			"Method calls by outcome; status is \"ok\" or the error code.", "tool", "method", "status"),
+		callDuration: r.Histogram("jb_call_duration_seconds",
+			"Method call latency, including time spent queued for a worker.", nil, "tool", "method"),
+		inFlight: r.Gauge("jb_calls_in_flight",
+			"Method calls currently executing or queued.", "tool"),
+		healthChecks: r.Counter("jb_health_checks_total",
+			"Health check outcomes; result is \"pass\" or \"fail\".", "tool", "result"),
+		startDuration: r.Histogram("jb_tool_start_duration_seconds",
+			"Time to start a persistent tool's workers.", lifecycleBuckets, "tool"),
+		stopDuration: r.Histogram("jb_tool_stop_duration_seconds",
+			"Time to stop a persistent tool's workers.", lifecycleBuckets, "tool"),
+	}
+
+	r.GaugeFunc("jb_tool_status",
+		"Set to 1 for each tool's current status and health.", []string{"tool", "status", "health"},
+		func(emit metrics.Emit) {
+			for _, tool := range e.manager.List() {
+				emit(1, tool.Name, tool.Status, tool.HealthStatus)
+			}
+		})
+	r.GaugeFunc("jb_tool_restarts",
+		"Supervisor restarts since the tool was last started manually.", []string{"tool"},
+		func(emit metrics.Emit) {
+			for _, tool := range e.manager.List() {
+				emit(float64(tool.RestartCount), tool.Name)
+			}
+		})
+	r.GaugeFunc("jb_tool_workers",
+		"Live worker processes per running tool.", []string{"tool"},
+		func(emit metrics.Emit) {
+			for name, inst := range e.runningInstances() {
+				emit(float64(inst.live()), name)
+			}
+		})
+	r.GaugeFunc("jb_tool_queue_depth",
+		"Calls waiting for a free worker.", []string{"tool"},
+		func(emit metrics.Emit) {
+			for name, inst := range e.runningInstances() {
+				_, queued := inst.stats()
+				emit(float64(queued), name)
+			}
+		})
+
+	return m
+}
+
+// runningInstances returns a copy of the running instances by tool name
+func (e *Executor) runningInstances() map[string]*instance {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make(map[string]*instance, len(e.instances))
+	for name, inst := range e.instances {
+		out[name] = inst
+	}
+	return out
+}
+
+// observeCall records a finished call
+func (m *executorMetrics) observeCall(toolName, methodName string, started time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = AsCallError(err, toolName, methodName).Code
+	}
+	m.calls.Inc(toolName, methodName, status)
+	m.callDuration.Observe(time.Since(started).Seconds(), toolName, methodName)
+}
+
+// Metrics returns the executor's call and tool lifecycle metrics.
+func (e *Executor) Metrics() *metrics.Registry {
+	return e.metrics.registry
+}
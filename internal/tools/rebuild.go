@@ -0,0 +1,152 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/calobozan/jb-serve/internal/config"
+)
+
+// NeedsRebuild reports whether a tool's manifest asks for different dependencies
+// than its environment was built with. Environments without a recorded
+// fingerprint, and tools with no environment yet, are not flagged.
+func (m *Manager) NeedsRebuild(tool *Tool) bool {
+	envName, ok := m.linkedEnv(tool.Name)
+	if !ok {
+		return false
+	}
+	meta, err := readEnvMeta(filepath.Join(m.envsDir(), envName))
+	if err != nil || meta.Fingerprint == "" {
+		return false
+	}
+	return meta.Fingerprint != envSpec(tool.Manifest, tool.Path, nil).Fingerprint()
+}
+
+// stageRebuild builds the environment for a tool's current manifest and reruns
+// setup. The tool directory itself is left alone.
+func (m *Manager) stageRebuild(live *Tool, manifest *config.Manifest, out io.Writer) (*stagedInstall, error) {
+	source, ref := live.Path, ""
+	if entry := m.Locked(live.Name); entry != nil {
+		source, ref = entry.Source, entry.Ref
+	}
+
+	st := m.newStage(source, ref)
+	st.upgrade = live
+	st.tool = &Tool{Name: live.Name, Path: live.Path, Manifest: manifest}
+	fmt.Fprintf(out, "Rebuilding Python %s environment for %s...\n", manifest.Runtime.Python, live.Name)
+	if err := st.build(nil, out); err != nil {
+		st.discard()
+		return nil, err
+	}
+	return st, nil
+}
+
+// dropEnv deletes a shared environment so it can be built again, and forgets it in
+// every tool using it so their next start doesn't use the deleted one
+func (m *Manager) dropEnv(envName string) error {
+	users := m.envUsers()[envName]
+	m.mu.Lock()
+	for _, name := range users {
+		if tool, ok := m.tools[name]; ok {
+			tool.Env = nil
+		}
+	}
+	m.mu.Unlock()
+
+	unlock := m.lockEnv(envName)
+	defer unlock()
+	return os.RemoveAll(filepath.Join(m.envsDir(), envName))
+}
+
+// Rebuild recreates a tool's environment from its current manifest and reruns
+// setup. If the dependencies changed, the new environment is built while the tool
+// keeps serving and swapped in as in Upgrade. If they didn't, the environment is
+// deleted and rebuilt in place, which needs every other tool sharing it stopped.
+// A running tool is restarted either way.
+func (e *Executor) Rebuild(name string, out io.Writer) (*Tool, error) {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	m := e.manager
+	live, ok := m.Get(name)
+	if !ok {
+		return nil, newError(ErrCodeToolNotFound, name, "", "tool not found: %s", name)
+	}
+	manifest, err := m.loadManifest(live.Path)
+	if err != nil {
+		return nil, newError(ErrCodeInstallFailed, name, "", "invalid manifest: %v", err)
+	}
+
+	envName := sharedEnvName(envSpec(manifest, live.Path, nil))
+	current, _ := m.linkedEnv(name)
+	inPlace := current == envName
+	users := m.envUsers()[envName]
+
+	e.mu.RLock()
+	_, running := e.instances[name]
+	var sharing []string
+	for _, user := range users {
+		if _, ok := e.instances[user]; ok && user != name {
+			sharing = append(sharing, user)
+		}
+	}
+	e.mu.RUnlock()
+
+	if inPlace {
+		if len(sharing) > 0 {
+			return nil, newError(ErrCodeAlreadyRunning, name, "", "environment %s is shared with running tools (%s); stop them first", envName, strings.Join(sharing, ", "))
+		}
+		fmt.Fprintf(out, "Dependencies of %s are unchanged; recreating %s\n", name, envName)
+		if running {
+			fmt.Fprintf(out, "Stopping %s\n", name)
+			e.stopTool(live)
+		}
+		if err := m.dropEnv(envName); err != nil {
+			return nil, newError(ErrCodeInstallFailed, name, "", "failed to remove %s: %v", envName, err)
+		}
+	}
+
+	st, err := m.stageRebuild(live, manifest, out)
+	if err != nil {
+		if inPlace {
+			log.Printf("Rebuild of %s failed; its environment will be rebuilt on next start", name)
+		}
+		return nil, err
+	}
+
+	if running && !inPlace {
+		fmt.Fprintf(out, "Stopping %s to swap in the new environment\n", name)
+		e.stopTool(live)
+	}
+
+	if err := st.commit(); err != nil {
+		st.discard()
+		if running && !inPlace {
+			e.start(name, true)
+		}
+		return nil, newError(ErrCodeInstallFailed, name, "", "%v", err)
+	}
+
+	if running {
+		fmt.Fprintf(out, "Starting %s\n", name)
+		if err := e.start(name, true); err != nil {
+			if inPlace {
+				st.finish(out)
+				return nil, newError(ErrCodeInstallFailed, name, "", "rebuilt environment failed to start: %v", err)
+			}
+			fmt.Fprintf(out, "Rebuilt environment failed to start (%v); restoring the previous one\n", err)
+			st.discard()
+			e.start(name, true)
+			return nil, newError(ErrCodeInstallFailed, name, "", "rebuilt environment failed to start: %v", err)
+		}
+	}
+
+	st.finish(out)
+	e.notifyReload(&ReloadResult{Updated: []string{name}})
+	fmt.Fprintf(out, "Rebuilt environment for %s\n", name)
+	return live, nil
+}
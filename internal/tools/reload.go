@@ -0,0 +1,409 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/config"
+)
+
+// reloadDrainTimeout is how long a reload waits for in-flight calls before
+// restarting a tool's workers anyway
+const reloadDrainTimeout = 60 * time.Second
+
+// ReloadResult summarizes what a rescan of the tools directory changed.
+type ReloadResult struct {
+	Added     []string          `json:"added"`
+	Updated   []string          `json:"updated"` // jumpboot.yaml or entrypoint changed
+	Removed   []string          `json:"removed"`
+	Restarted []string          `json:"restarted"` // Running tools restarted to pick up changes
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// Changed reports whether the reload added, updated or removed anything.
+func (r *ReloadResult) Changed() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed) > 0
+}
+
+// rescanResult is the manager's side of a reload
+type rescanResult struct {
+	added   []*Tool
+	updated []*Tool
+	removed []*Tool
+	errors  map[string]string // Directory name -> problem
+}
+
+// toolDigests fingerprints a tool's manifest and entrypoint so reloads can tell
+// whether either changed. Missing files give an empty digest.
+func toolDigests(toolPath string, manifest *config.Manifest) (manifestSum, entrypointSum string) {
+	return fileDigest(filepath.Join(toolPath, "jumpboot.yaml")),
+		fileDigest(filepath.Join(toolPath, manifest.Runtime.Entrypoint))
+}
+
+func fileDigest(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// rescan re-reads every manifest in the tools directory and brings the tool table
+// up to date. A tool whose manifest no longer parses keeps its last good version.
+// A changed tool is replaced by a new *Tool rather than edited in place, since
+// calls in progress read the old one's Path and Manifest without the lock.
+func (m *Manager) rescan() (*rescanResult, error) {
+	entries, err := os.ReadDir(m.cfg.ToolsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	type scanned struct {
+		path          string
+		manifest      *config.Manifest
+		manifestSum   string
+		entrypointSum string
+	}
+
+	res := &rescanResult{errors: make(map[string]string)}
+	found := make(map[string]scanned)
+	broken := make(map[string]bool) // Paths whose manifest failed to load
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		toolPath := filepath.Join(m.cfg.ToolsDir, entry.Name())
+
+		// Follow symlinks
+		info, err := os.Stat(toolPath)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		manifest, err := m.loadManifest(toolPath)
+		if err != nil {
+			res.errors[entry.Name()] = err.Error()
+			broken[toolPath] = true
+			continue
+		}
+		if other, dup := found[manifest.Name]; dup {
+			res.errors[entry.Name()] = fmt.Sprintf("tool %s is already provided by %s", manifest.Name, other.path)
+			continue
+		}
+
+		manifestSum, entrypointSum := toolDigests(toolPath, manifest)
+		found[manifest.Name] = scanned{toolPath, manifest, manifestSum, entrypointSum}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, tool := range m.tools {
+		sc, ok := found[name]
+		if !ok {
+			if broken[tool.Path] {
+				continue
+			}
+			delete(m.tools, name)
+			res.removed = append(res.removed, tool)
+			continue
+		}
+		if sc.path != tool.Path || sc.manifestSum != tool.manifestSum || sc.entrypointSum != tool.entrypointSum {
+			updated := *tool
+			updated.Path = sc.path
+			updated.Manifest = sc.manifest
+			updated.manifestSum = sc.manifestSum
+			updated.entrypointSum = sc.entrypointSum
+			m.tools[name] = &updated
+			res.updated = append(res.updated, &updated)
+		}
+	}
+
+	for name, sc := range found {
+		if _, ok := m.tools[name]; ok {
+			continue
+		}
+		tool := &Tool{
+			Name:          name,
+			Path:          sc.path,
+			Manifest:      sc.manifest,
+			Status:        "stopped",
+			manifestSum:   sc.manifestSum,
+			entrypointSum: sc.entrypointSum,
+		}
+		m.tools[name] = tool
+		res.added = append(res.added, tool)
+	}
+
+	return res, nil
+}
+
+// signature summarizes the modification times of every manifest and known
+// entrypoint under the tools directory. It changes whenever a reload might.
+func (m *Manager) signature() string {
+	entries, err := os.ReadDir(m.cfg.ToolsDir)
+	if err != nil {
+		return ""
+	}
+
+	entrypoints := make(map[string]string) // Tool path -> entrypoint
+	for _, tool := range m.List() {
+		entrypoints[tool.Path] = filepath.Join(tool.Path, tool.Manifest.Runtime.Entrypoint)
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		toolPath := filepath.Join(m.cfg.ToolsDir, entry.Name())
+		b.WriteString(entry.Name())
+		for _, path := range []string{filepath.Join(toolPath, "jumpboot.yaml"), entrypoints[toolPath]} {
+			if path == "" {
+				continue
+			}
+			if info, err := os.Stat(path); err == nil {
+				fmt.Fprintf(&b, "|%d:%d", info.ModTime().UnixNano(), info.Size())
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// OnReload registers a function called after each reload that changed the tool set.
+func (e *Executor) OnReload(fn func(*ReloadResult)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onReload = fn
+}
+
+// Reload rescans the tools directory. New tools become available, removed tools are
+// stopped, and running persistent tools whose manifest or entrypoint changed are
+// restarted once their in-flight calls finish.
+func (e *Executor) Reload() (*ReloadResult, error) {
+	e.reloadMu.Lock()
+	changes, err := e.manager.rescan()
+	if err != nil {
+		e.reloadMu.Unlock()
+		return nil, fmt.Errorf("failed to scan tools: %w", err)
+	}
+
+	result := &ReloadResult{
+		Added:     []string{},
+		Updated:   []string{},
+		Removed:   []string{},
+		Restarted: []string{},
+		Errors:    changes.errors,
+	}
+	for _, tool := range changes.added {
+		result.Added = append(result.Added, tool.Name)
+	}
+
+	for _, tool := range changes.removed {
+		result.Removed = append(result.Removed, tool.Name)
+		if err := e.stopTool(tool); err == nil {
+			log.Printf("Stopped %s: removed from %s", tool.Name, e.manager.cfg.ToolsDir)
+		}
+	}
+
+	var restarts []*reloadRestart
+	for _, tool := range changes.updated {
+		result.Updated = append(result.Updated, tool.Name)
+		if r := e.beginReload(tool); r != nil {
+			restarts = append(restarts, r)
+		}
+	}
+	e.reloadMu.Unlock()
+
+	// Drain and restart outside reloadMu, so installs aren't held up for the
+	// drain, and all tools at once, so their drains overlap
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, r := range restarts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := e.finishReload(r)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Errors[r.tool.Name] = err.Error()
+				return
+			}
+			result.Restarted = append(result.Restarted, r.tool.Name)
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Updated)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Restarted)
+
+	for dir, problem := range result.Errors {
+		log.Printf("Reload: %s: %s", dir, problem)
+	}
+	if result.Changed() {
+		log.Printf("Reloaded tools: %d added, %d updated, %d removed, %d restarted",
+			len(result.Added), len(result.Updated), len(result.Removed), len(result.Restarted))
+		e.notifyReload(result)
+	}
+	return result, nil
+}
+
+// notifyReload passes a change to the tool set to the OnReload hook
+func (e *Executor) notifyReload(result *ReloadResult) {
+	e.mu.RLock()
+	hook := e.onReload
+	e.mu.RUnlock()
+	if hook != nil {
+		hook(result)
+	}
+}
+
+// reloadRestart is a running tool being restarted to pick up a changed manifest
+// or entrypoint
+type reloadRestart struct {
+	tool *Tool
+	inst *instance     // The instance running the old version
+	done chan struct{} // Closed when the restart finishes
+}
+
+// beginReload takes a running persistent tool's instance out of service so it can
+// be restarted with finishReload. New calls wait for the restart instead of
+// failing. Returns nil if the tool wasn't running.
+func (e *Executor) beginReload(tool *Tool) *reloadRestart {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// A start in progress is running the old version too
+	e.waitStartLocked(tool.Name)
+	inst, ok := e.instances[tool.Name]
+	if !ok {
+		return nil
+	}
+	done := make(chan struct{})
+	e.reloading[tool.Name] = done
+	if cancel, ok := e.healthCancels[tool.Name]; ok {
+		cancel()
+		delete(e.healthCancels, tool.Name)
+	}
+	delete(e.instances, tool.Name)
+	tool.Status = "restarting"
+	inst.retire()
+	return &reloadRestart{tool: tool, inst: inst, done: done}
+}
+
+// finishReload gives the old instance's in-flight calls up to reloadDrainTimeout
+// to finish, then stops it and starts the tool again
+func (e *Executor) finishReload(r *reloadRestart) error {
+	tool := r.tool
+	defer func() {
+		e.mu.Lock()
+		delete(e.reloading, tool.Name)
+		e.mu.Unlock()
+		close(r.done)
+	}()
+
+	log.Printf("Restarting %s to apply changes", tool.Name)
+	if !r.inst.drain(reloadDrainTimeout) {
+		log.Printf("Restarting %s with calls still running after %v", tool.Name, reloadDrainTimeout)
+	}
+
+	started := time.Now()
+	for _, w := range r.inst.snapshot() {
+		w.close()
+	}
+	e.metrics.stopDuration.Observe(time.Since(started).Seconds(), tool.Name)
+	e.resources.release(tool.Name)
+
+	if err := e.start(tool.Name, true); err != nil {
+		tool.Status = "stopped"
+		tool.HealthStatus = ""
+		tool.LastExitReason = fmt.Sprintf("restart after reload failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// holdTool makes calls to a tool wait, and Start wait to start it, until the
+// returned function is called. Upgrades and uninstalls hold a tool while they
+// change it, so nothing starts the old version in the meantime.
+func (e *Executor) holdTool(toolName string) (release func()) {
+	e.mu.Lock()
+	for {
+		e.waitStartLocked(toolName)
+		wait, ok := e.reloading[toolName]
+		if !ok {
+			break
+		}
+		e.mu.Unlock()
+		<-wait
+		e.mu.Lock()
+	}
+	done := make(chan struct{})
+	e.reloading[toolName] = done
+	e.mu.Unlock()
+
+	return func() {
+		e.mu.Lock()
+		delete(e.reloading, toolName)
+		e.mu.Unlock()
+		close(done)
+	}
+}
+
+// reloadWait returns a channel closed when the tool's reload restart (or upgrade)
+// finishes, or nil if it isn't being restarted
+func (e *Executor) reloadWait(toolName string) chan struct{} {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.reloading[toolName]
+}
+
+// WatchTools polls the tools directory every interval and reloads when a tool is
+// added or removed or its jumpboot.yaml or entrypoint changes. A change is applied
+// once the directory has been stable for one interval, so editors that write files
+// in several steps trigger a single reload. Call the returned function to stop.
+func (e *Executor) WatchTools(interval time.Duration) (stop func()) {
+	stopCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := e.manager.signature()
+		pending := false
+		for {
+			select {
+			case <-ticker.C:
+				sig := e.manager.signature()
+				if sig != last {
+					last = sig
+					pending = true
+					continue
+				}
+				if pending {
+					pending = false
+					if _, err := e.Reload(); err != nil {
+						log.Printf("Reload failed: %v", err)
+					}
+					// A reload can register new entrypoints to watch
+					last = e.manager.signature()
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return func() { close(stopCh) }
+}
@@ -0,0 +1,306 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/config"
+)
+
+// DefaultResourceQueueTimeout bounds how long a queued start waits for resources
+const DefaultResourceQueueTimeout = 600 * time.Second
+
+// unlimited marks a resource the budget doesn't limit
+const unlimited = -1
+
+// ResourceAmounts is a quantity of host resources. In a budget, and what's free of
+// it, -1 means unlimited.
+type ResourceAmounts struct {
+	GPUs   int `json:"gpus"`
+	VRAMGB int `json:"vram_gb"`
+	RAMGB  int `json:"ram_gb"`
+}
+
+func (a ResourceAmounts) isZero() bool {
+	return a.GPUs == 0 && a.VRAMGB == 0 && a.RAMGB == 0
+}
+
+func (a ResourceAmounts) add(b ResourceAmounts) ResourceAmounts {
+	return ResourceAmounts{GPUs: a.GPUs + b.GPUs, VRAMGB: a.VRAMGB + b.VRAMGB, RAMGB: a.RAMGB + b.RAMGB}
+}
+
+// ToolResources is what one running tool holds
+type ToolResources struct {
+	Name string `json:"name"`
+	ResourceAmounts
+}
+
+// ResourceUsage is a snapshot of the host budget, served on /v1/resources
+type ResourceUsage struct {
+	Enforced  bool             `json:"enforced"`
+	Policy    string           `json:"policy,omitempty"`
+	EvictIdle bool             `json:"evict_idle,omitempty"`
+	Budget    *ResourceAmounts `json:"budget,omitempty"`
+	Used      ResourceAmounts  `json:"used"`
+	Free      *ResourceAmounts `json:"free,omitempty"`
+	Queued    int              `json:"queued"` // Starts waiting for resources
+	Tools     []ToolResources  `json:"tools"`
+}
+
+// demand returns what a tool needs while running. Every worker loads its own copy
+// of the model, so requirements scale with the worker count.
+func demand(tool *Tool) ResourceAmounts {
+	res := tool.Manifest.Resources
+	workers := tool.Manifest.Runtime.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	need := ResourceAmounts{VRAMGB: res.VRAMGB * workers, RAMGB: res.RAMGB * workers}
+	if res.GPU {
+		need.GPUs = workers
+	}
+	return need
+}
+
+// resourceLedger tracks what running persistent tools hold against the host budget
+type resourceLedger struct {
+	budget *config.ResourceBudget // nil = track usage but never refuse
+
+	mu      sync.Mutex
+	held    map[string]ResourceAmounts
+	queued  int
+	changed chan struct{} // Closed and replaced whenever resources are released
+}
+
+func newResourceLedger(budget *config.ResourceBudget) *resourceLedger {
+	return &resourceLedger{
+		budget:  budget,
+		held:    make(map[string]ResourceAmounts),
+		changed: make(chan struct{}),
+	}
+}
+
+// total returns the budget as amounts, with unlimited for limits it leaves out
+func (l *resourceLedger) total() ResourceAmounts {
+	return ResourceAmounts{GPUs: limit(l.budget.GPUs), VRAMGB: limit(l.budget.VRAMGB), RAMGB: limit(l.budget.RAMGB)}
+}
+
+// limit returns a budget limit, or unlimited if it isn't set
+func limit(v *int) int {
+	if v == nil {
+		return unlimited
+	}
+	return *v
+}
+
+// shortBy returns how much of one resource is missing to fit need on top of used
+func shortBy(used, need, total int) int {
+	if total == unlimited {
+		return 0
+	}
+	return max(0, used+need-total)
+}
+
+// remaining returns what's left of a resource, which may be unlimited
+func remaining(used, total int) int {
+	if total == unlimited {
+		return unlimited
+	}
+	return total - used
+}
+
+// policy returns the budget's policy for starts that don't fit
+func (l *resourceLedger) policy() string {
+	if l.budget == nil || l.budget.Policy == "" {
+		return "refuse"
+	}
+	return l.budget.Policy
+}
+
+// usedLocked sums what running tools hold
+func (l *resourceLedger) usedLocked() ResourceAmounts {
+	var used ResourceAmounts
+	for _, a := range l.held {
+		used = used.add(a)
+	}
+	return used
+}
+
+// shortLocked returns how much of each resource is missing to fit need (all zero if it fits)
+func (l *resourceLedger) shortLocked(need ResourceAmounts) ResourceAmounts {
+	if l.budget == nil {
+		return ResourceAmounts{}
+	}
+	return l.shortfall(l.usedLocked(), need)
+}
+
+// shortfall returns how much of each resource is missing to fit need on top of used
+func (l *resourceLedger) shortfall(used, need ResourceAmounts) ResourceAmounts {
+	total := l.total()
+	return ResourceAmounts{
+		GPUs:   shortBy(used.GPUs, need.GPUs, total.GPUs),
+		VRAMGB: shortBy(used.VRAMGB, need.VRAMGB, total.VRAMGB),
+		RAMGB:  shortBy(used.RAMGB, need.RAMGB, total.RAMGB),
+	}
+}
+
+// exceedsBudget reports whether need can never fit, even on an empty host
+func (l *resourceLedger) exceedsBudget(need ResourceAmounts) bool {
+	if l.budget == nil {
+		return false
+	}
+	return !l.shortfall(ResourceAmounts{}, need).isZero()
+}
+
+// tryReserve reserves need for a tool if it fits. Otherwise it returns the shortfall
+// and a channel that is closed the next time anything is released.
+func (l *resourceLedger) tryReserve(name string, need ResourceAmounts) (bool, ResourceAmounts, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	short := l.shortLocked(need)
+	if !short.isZero() {
+		return false, short, l.changed
+	}
+	if !need.isZero() {
+		l.held[name] = need
+	}
+	return true, short, nil
+}
+
+// release frees whatever a tool holds and wakes queued starts
+func (l *resourceLedger) release(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.held[name]; !ok {
+		return
+	}
+	delete(l.held, name)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// usage returns a snapshot for reporting
+func (l *resourceLedger) usage() ResourceUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := ResourceUsage{
+		Enforced: l.budget != nil,
+		Used:     l.usedLocked(),
+		Queued:   l.queued,
+		Tools:    make([]ToolResources, 0, len(l.held)),
+	}
+	if l.budget != nil {
+		total := l.total()
+		free := ResourceAmounts{
+			GPUs:   remaining(u.Used.GPUs, total.GPUs),
+			VRAMGB: remaining(u.Used.VRAMGB, total.VRAMGB),
+			RAMGB:  remaining(u.Used.RAMGB, total.RAMGB),
+		}
+		u.Policy = l.policy()
+		u.EvictIdle = l.budget.EvictIdle
+		u.Budget = &total
+		u.Free = &free
+	}
+	for name, a := range l.held {
+		u.Tools = append(u.Tools, ToolResources{Name: name, ResourceAmounts: a})
+	}
+	sort.Slice(u.Tools, func(i, j int) bool { return u.Tools[i].Name < u.Tools[j].Name })
+	return u
+}
+
+// describeShort formats a shortfall for error messages
+func describeShort(short ResourceAmounts) string {
+	var parts []string
+	if short.GPUs > 0 {
+		parts = append(parts, fmt.Sprintf("%d GPU(s)", short.GPUs))
+	}
+	if short.VRAMGB > 0 {
+		parts = append(parts, fmt.Sprintf("%d GB VRAM", short.VRAMGB))
+	}
+	if short.RAMGB > 0 {
+		parts = append(parts, fmt.Sprintf("%d GB RAM", short.RAMGB))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Resources returns current resource usage against the host budget
+func (e *Executor) Resources() ResourceUsage {
+	return e.resources.usage()
+}
+
+// waitForResources makes room for a start that didn't fit, by evicting an idle tool or
+// waiting for a release, depending on the budget policy. Returns nil when the caller
+// should try to reserve again.
+func (e *Executor) waitForResources(tool *Tool, short ResourceAmounts, changed <-chan struct{}, deadline time.Time) error {
+	if e.resources.budget.EvictIdle && e.evictIdle(tool.Name, short) {
+		return nil
+	}
+
+	if e.resources.policy() != "queue" {
+		return newError(ErrCodeInsufficientResources, tool.Name, "", "not enough resources to start %s: short %s", tool.Name, describeShort(short))
+	}
+
+	e.resources.mu.Lock()
+	e.resources.queued++
+	e.resources.mu.Unlock()
+	defer func() {
+		e.resources.mu.Lock()
+		e.resources.queued--
+		e.resources.mu.Unlock()
+	}()
+
+	log.Printf("Start of %s queued: short %s", tool.Name, describeShort(short))
+	select {
+	case <-changed:
+		return nil
+	case <-time.After(time.Until(deadline)):
+		return newError(ErrCodeInsufficientResources, tool.Name, "", "timed out waiting for resources to start %s: short %s", tool.Name, describeShort(short))
+	}
+}
+
+// evictIdle stops the least-recently-used idle persistent tool holding a resource in
+// short supply. Returns false if there is nothing to evict.
+func (e *Executor) evictIdle(exclude string, short ResourceAmounts) bool {
+	e.resources.mu.Lock()
+	held := make(map[string]ResourceAmounts, len(e.resources.held))
+	for name, a := range e.resources.held {
+		held[name] = a
+	}
+	e.resources.mu.Unlock()
+
+	e.mu.RLock()
+	var victim *instance
+	var victimName string
+	var oldest time.Time
+	for name, inst := range e.instances {
+		if name == exclude {
+			continue
+		}
+		a := held[name]
+		helps := (short.GPUs > 0 && a.GPUs > 0) || (short.VRAMGB > 0 && a.VRAMGB > 0) || (short.RAMGB > 0 && a.RAMGB > 0)
+		if !helps {
+			continue
+		}
+		since, idle := inst.idleSince()
+		if idle && (victim == nil || since.Before(oldest)) {
+			victim, victimName, oldest = inst, name, since
+		}
+	}
+	e.mu.RUnlock()
+
+	if victim == nil {
+		return false
+	}
+	tool, ok := e.manager.Get(victimName)
+	if !ok {
+		return false
+	}
+	return e.unloadIfIdle(tool, victim, 0, fmt.Sprintf("evicted to make room for %s", exclude))
+}
@@ -0,0 +1,298 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/richinsley/jumpboot"
+)
+
+// sandboxSystemDirs are bound read-only into every sandbox so the interpreter and
+// the shared libraries it loads are available. Missing ones are skipped.
+var sandboxSystemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc", "/opt"}
+
+// sandboxHostVars are passed into every sandbox from the environment the wrapper
+// is started with. The rest of it is cleared, so a tool gets only these, HOME,
+// TMPDIR and its own variables.
+var sandboxHostVars = []string{"PATH", "LANG", "JB_SERVE_URL"}
+
+// envVarName matches the variable names the wrapper script can pass on
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sandboxPolicy returns the sandbox a tool's processes run in, or nil if they run
+// unsandboxed. The host config can force one on tools that don't ask for it.
+func (m *Manager) sandboxPolicy(tool *Tool) *config.Sandbox {
+	policy := tool.Manifest.Sandbox
+	if policy == nil {
+		if m.cfg.Sandbox == nil || !m.cfg.Sandbox.Enforce {
+			return nil
+		}
+		policy = &config.Sandbox{}
+	}
+	if m.cfg.Sandbox != nil && m.cfg.Sandbox.NoNetwork && policy.Network {
+		restricted := *policy
+		restricted.Network = false
+		policy = &restricted
+	}
+	return policy
+}
+
+// processEnv returns the Python environment to start a tool's processes from and
+// the variables to give them. For a sandboxed tool it's a copy of the tool's
+// environment whose interpreter is a wrapper that starts Python under bubblewrap.
+func (m *Manager) processEnv(tool *Tool) (*jumpboot.PythonEnvironment, map[string]string, error) {
+	vars, err := m.ToolEnv(tool)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy := m.sandboxPolicy(tool)
+	if policy == nil {
+		return tool.Env, vars, nil
+	}
+
+	wrapper, err := m.writeSandboxWrapper(tool, policy, vars)
+	if err != nil {
+		return nil, nil, newError(ErrCodeUnavailable, tool.Name, "", "sandbox: %v", err)
+	}
+	sandboxed := *tool.Env
+	sandboxed.PythonPath = wrapper
+	return &sandboxed, vars, nil
+}
+
+// sandboxDir holds a tool's wrapper script and its private home directory
+func (m *Manager) sandboxDir(tool *Tool) string {
+	return filepath.Join(m.cfg.RunDir, "sandbox", tool.Name)
+}
+
+// writeSandboxWrapper writes the script that applies a tool's rlimits and execs
+// Python under bubblewrap. It's rewritten on every start so policy changes apply.
+// The script sits outside the home directory the tool can write to. It names the
+// tool's variables but not their values, which it takes from its own environment,
+// so secrets aren't written to disk.
+func (m *Manager) writeSandboxWrapper(tool *Tool, policy *config.Sandbox, vars map[string]string) (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("sandboxing needs Linux")
+	}
+	bwrap, err := m.bwrapPath()
+	if err != nil {
+		return "", err
+	}
+
+	dir := m.sandboxDir(tool)
+	home := filepath.Join(dir, "home")
+	tmp := filepath.Join(home, "tmp")
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return "", err
+	}
+	// Bound even while empty, so uploads saved after the tool starts are visible
+	if err := os.MkdirAll(m.toolUploadDir(tool), 0755); err != nil {
+		return "", err
+	}
+
+	// HOME and TMPDIR are always the sandbox's own
+	skip := map[string]bool{"HOME": true, "TMPDIR": true}
+	for _, name := range sandboxHostVars {
+		skip[name] = true
+	}
+	var own []string
+	for name := range vars {
+		if !envVarName.MatchString(name) {
+			return "", fmt.Errorf("environment variable name %q can't be passed into the sandbox", name)
+		}
+		if !skip[name] {
+			own = append(own, name)
+		}
+	}
+	sort.Strings(own)
+	names := append(append([]string{}, sandboxHostVars...), own...)
+
+	args, err := m.bwrapArgs(tool, policy, home, tmp)
+	if err != nil {
+		return "", err
+	}
+
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n# Written by jb-serve; rewritten each time the tool starts.\n")
+	if policy.MemoryMB > 0 {
+		fmt.Fprintf(&script, "ulimit -v %d || exit 1\n", policy.MemoryMB*1024)
+	}
+	if policy.CPUSeconds > 0 {
+		fmt.Fprintf(&script, "ulimit -t %d || exit 1\n", policy.CPUSeconds)
+	}
+	if policy.OpenFiles > 0 {
+		fmt.Fprintf(&script, "ulimit -n %d || exit 1\n", policy.OpenFiles)
+	}
+	// bwrap clears the environment, so put a --setenv for each of these variables
+	// that's set ahead of the interpreter and its arguments
+	script.WriteString("set -- -- " + shellQuote(tool.Env.PythonPath) + ` "$@"` + "\n")
+	for _, name := range names {
+		fmt.Fprintf(&script, "[ -z \"${%s+set}\" ] || set -- --setenv %s \"$%s\" \"$@\"\n", name, name, name)
+	}
+	script.WriteString("exec " + shellQuote(bwrap))
+	for _, arg := range args {
+		// One option and its operands per line
+		if strings.HasPrefix(arg, "--") {
+			script.WriteString(" \\\n ")
+		}
+		script.WriteString(" " + shellQuote(arg))
+	}
+	script.WriteString(` "$@"` + "\n")
+
+	path := dir + ".sh"
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(script.String()), 0700); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return path, nil
+}
+
+// bwrapPath finds bubblewrap, preferring the configured path
+func (m *Manager) bwrapPath() (string, error) {
+	if m.cfg.Sandbox != nil && m.cfg.Sandbox.Bwrap != "" {
+		return expandHome(m.cfg.Sandbox.Bwrap), nil
+	}
+	path, err := exec.LookPath("bwrap")
+	if err != nil {
+		return "", fmt.Errorf("bubblewrap (bwrap) not found; install it or set sandbox.bwrap in %s", m.cfg.ConfigPath())
+	}
+	return path, nil
+}
+
+// bwrapArgs builds the bubblewrap options. The wrapper script adds the variables
+// to pass on and the real interpreter, which is started as its real path so the
+// tool sees the same paths as the host.
+func (m *Manager) bwrapArgs(tool *Tool, policy *config.Sandbox, home, tmp string) ([]string, error) {
+	args := []string{"--unshare-all", "--die-with-parent", "--new-session", "--clearenv"}
+	if policy.Network {
+		args = append(args, "--share-net")
+	}
+	for _, dir := range sandboxSystemDirs {
+		args = append(args, "--ro-bind-try", dir, dir)
+	}
+	// /tmp is the tool's own tmp directory, so files it leaves there can be served
+	args = append(args, "--proc", "/proc", "--dev", "/dev", "--bind", tmp, "/tmp")
+
+	// The environment is bound at its real path and, for shared environments, at
+	// the tool's link to it
+	envPath := tool.Env.EnvPath
+	realEnv, err := filepath.EvalSymlinks(envPath)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "--ro-bind", realEnv, realEnv)
+	if realEnv != envPath {
+		args = append(args, "--ro-bind", realEnv, envPath)
+	}
+
+	args = append(args, "--ro-bind", tool.Path, tool.Path)
+	// Only the tool's own uploads, not those passed to other tools
+	uploads := m.toolUploadDir(tool)
+	args = append(args, "--ro-bind", uploads, uploads)
+	args = append(args, "--bind", home, home)
+
+	for _, spec := range policy.Paths {
+		path, writable, err := m.sandboxPath(spec)
+		if err != nil {
+			return nil, err
+		}
+		if writable {
+			args = append(args, "--bind", path, path)
+		} else {
+			args = append(args, "--ro-bind", path, path)
+		}
+	}
+
+	args = append(args,
+		"--setenv", "HOME", home,
+		"--setenv", "TMPDIR", tmp,
+		"--chdir", tool.Path,
+	)
+	return args, nil
+}
+
+// toolUploadDir is where the server saves files uploaded for a tool's calls; see
+// files.Manager.ToolUploadDir
+func (m *Manager) toolUploadDir(tool *Tool) string {
+	return filepath.Join(m.cfg.BaseDir(), "uploads", tool.Name)
+}
+
+// sandboxPath parses an extra path from a manifest: path or path:rw. Paths that
+// overlap jb-serve's own directories are refused, since they hold the config, the
+// secrets file and other tools.
+func (m *Manager) sandboxPath(spec string) (string, bool, error) {
+	path, writable := spec, false
+	if strings.HasSuffix(spec, ":rw") {
+		path, writable = strings.TrimSuffix(spec, ":rw"), true
+	} else {
+		path = strings.TrimSuffix(spec, ":ro")
+	}
+	path = filepath.Clean(os.ExpandEnv(expandHome(path)))
+	if !filepath.IsAbs(path) {
+		return "", false, fmt.Errorf("sandbox path %q must be absolute", spec)
+	}
+
+	for _, dir := range []string{m.cfg.BaseDir(), m.cfg.ToolsDir, m.cfg.EnvsDir, m.cfg.RunDir} {
+		if within(dir, path) || within(path, dir) {
+			return "", false, fmt.Errorf("sandbox path %q overlaps jb-serve directory %s", spec, dir)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", false, fmt.Errorf("sandbox path %q: %w", spec, err)
+	}
+	return path, writable, nil
+}
+
+// OutputPath returns the host path of a file a tool returned as an output. A
+// sandboxed tool's outputs must be under its home directory, its /tmp or a
+// writable path from its manifest; anything else is refused, so a tool can't
+// have jb-serve serve files it couldn't write itself.
+func (m *Manager) OutputPath(toolName, path string) (string, error) {
+	tool, ok := m.Get(toolName)
+	if !ok {
+		return "", fmt.Errorf("tool not found: %s", toolName)
+	}
+	policy := m.sandboxPolicy(tool)
+	if policy == nil {
+		return path, nil
+	}
+
+	home := filepath.Join(m.sandboxDir(tool), "home")
+	path = filepath.Clean(path)
+	if within("/tmp", path) {
+		rel, _ := filepath.Rel("/tmp", path)
+		path = filepath.Join(home, "tmp", rel)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	roots := []string{home}
+	for _, spec := range policy.Paths {
+		if root, writable, err := m.sandboxPath(spec); err == nil && writable {
+			roots = append(roots, root)
+		}
+	}
+	for _, root := range roots {
+		if real, err := filepath.EvalSymlinks(root); err == nil && within(real, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%s is outside the sandbox of %s", path, toolName)
+}
+
+// shellQuote quotes a word for /bin/sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
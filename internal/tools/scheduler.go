@@ -0,0 +1,285 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/richinsley/jumpboot"
+)
+
+// errRetired is returned by acquire once the instance has been stopped
+var errRetired = errors.New("instance retired")
+
+// worker is one Python process serving a persistent tool
+type worker struct {
+	repl     *jumpboot.REPLPythonProcess // REPL transport
+	queue    *jumpboot.QueueProcess      // MessagePack transport
+	inflight int                         // Calls currently routed to this worker
+	dead     bool                        // Exited or terminated, awaiting replacement
+	stopping atomic.Bool                 // Set when we shut the process down on purpose
+}
+
+// process returns the underlying Python process
+func (w *worker) process() *jumpboot.PythonProcess {
+	if w.queue != nil {
+		return w.queue.PythonProcess
+	}
+	return w.repl.PythonProcess
+}
+
+// close shuts down the worker's Python process
+func (w *worker) close() {
+	w.stopping.Store(true)
+	if w.queue != nil {
+		w.queue.Close()
+		return
+	}
+	w.repl.Close()
+}
+
+// instance is a running persistent tool: a pool of workers behind a FIFO call scheduler.
+// Each running call has a live worker to itself, since a Python process serves one
+// call at a time. At most maxConcurrency calls run at once, fewer while workers are
+// dead; up to maxQueue more wait in arrival order.
+type instance struct {
+	workers        []*worker
+	maxConcurrency int
+	maxQueue       int // 0 = unlimited
+
+	mu       sync.Mutex
+	active   int       // Calls holding a slot
+	waiters  []*waiter // Queued calls, oldest first
+	lastUsed time.Time // When the last call finished (or the tool started)
+	retired  bool      // Stopped; no new calls are accepted
+}
+
+// waiter is a queued call. ready is closed when it's handed a worker, or with no
+// worker when the instance is retired.
+type waiter struct {
+	ready  chan struct{}
+	worker *worker
+}
+
+// newInstance creates a scheduler over the given workers using the manifest's runtime limits
+func newInstance(workers []*worker, runtime config.Runtime) *instance {
+	maxConcurrency := runtime.MaxConcurrency
+	if maxConcurrency <= 0 || maxConcurrency > len(workers) {
+		maxConcurrency = len(workers)
+	}
+	return &instance{
+		workers:        workers,
+		maxConcurrency: maxConcurrency,
+		maxQueue:       runtime.MaxQueue,
+		lastUsed:       time.Now(),
+	}
+}
+
+// acquire waits in FIFO order for a call slot and an idle worker, and returns the worker.
+// Returns a queue_full error immediately if the wait queue is at its limit.
+func (in *instance) acquire(ctx context.Context, toolName, methodName string) (*worker, error) {
+	in.mu.Lock()
+	if in.retired {
+		in.mu.Unlock()
+		return nil, errRetired
+	}
+	if len(in.waiters) == 0 && in.active < in.capacityLocked() {
+		if w := in.pickLocked(); w != nil {
+			in.active++
+			in.mu.Unlock()
+			return w, nil
+		}
+	}
+	if in.maxQueue > 0 && len(in.waiters) >= in.maxQueue {
+		in.mu.Unlock()
+		return nil, newError(ErrCodeQueueFull, toolName, methodName, "tool %s has %d calls queued, try again later", toolName, in.maxQueue)
+	}
+	wt := &waiter{ready: make(chan struct{})}
+	in.waiters = append(in.waiters, wt)
+	in.mu.Unlock()
+
+	select {
+	case <-wt.ready:
+		if wt.worker == nil {
+			return nil, errRetired
+		}
+		return wt.worker, nil
+	case <-ctx.Done():
+		in.mu.Lock()
+		for i, c := range in.waiters {
+			if c == wt {
+				in.waiters = append(in.waiters[:i], in.waiters[i+1:]...)
+				in.mu.Unlock()
+				return nil, contextError(ctx)
+			}
+		}
+		in.mu.Unlock()
+		// A worker was handed to us just as we gave up - pass it on
+		if wt.worker != nil {
+			in.release(wt.worker)
+		}
+		return nil, contextError(ctx)
+	}
+}
+
+// release returns a worker and its slot after a call finishes
+func (in *instance) release(w *worker) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	w.inflight--
+	in.active--
+	in.lastUsed = time.Now()
+	in.dispatchLocked()
+}
+
+// dispatchLocked hands idle workers to queued calls, oldest first, while slots are free
+func (in *instance) dispatchLocked() {
+	for len(in.waiters) > 0 && !in.retired && in.active < in.capacityLocked() {
+		w := in.pickLocked()
+		if w == nil {
+			return
+		}
+		next := in.waiters[0]
+		in.waiters = in.waiters[1:]
+		next.worker = w
+		in.active++
+		close(next.ready)
+	}
+}
+
+// capacityLocked returns how many calls may run at once: maxConcurrency, or the
+// number of live workers if fewer
+func (in *instance) capacityLocked() int {
+	live := 0
+	for _, w := range in.workers {
+		if !w.dead {
+			live++
+		}
+	}
+	return min(live, in.maxConcurrency)
+}
+
+// pickLocked claims a live worker with no call running, or returns nil if there's none
+func (in *instance) pickLocked() *worker {
+	for _, w := range in.workers {
+		if !w.dead && w.inflight == 0 {
+			w.inflight++
+			return w
+		}
+	}
+	return nil
+}
+
+// replaceWorker swaps a dead worker for a fresh one. Returns false if old is no longer in the pool.
+func (in *instance) replaceWorker(old, fresh *worker) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for i, w := range in.workers {
+		if w == old {
+			in.workers[i] = fresh
+			in.dispatchLocked()
+			return true
+		}
+	}
+	return false
+}
+
+// markDead takes a worker out of rotation
+func (in *instance) markDead(w *worker) {
+	in.mu.Lock()
+	w.dead = true
+	in.mu.Unlock()
+}
+
+// retire stops the instance from accepting new calls. Queued calls are turned away.
+func (in *instance) retire() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.retired = true
+	for _, wt := range in.waiters {
+		close(wt.ready)
+	}
+	in.waiters = nil
+}
+
+// drain waits up to timeout for running calls to finish. Returns false on timeout.
+func (in *instance) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		in.mu.Lock()
+		active := in.active
+		in.mu.Unlock()
+		if active == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// idleSince returns when the instance went idle, or false if calls are running or queued
+func (in *instance) idleSince() (time.Time, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.retired || in.active > 0 || len(in.waiters) > 0 {
+		return time.Time{}, false
+	}
+	return in.lastUsed, true
+}
+
+// retireIfIdle retires the instance if no call has run for at least d.
+// Returns false if calls are running or queued, or the instance was used recently.
+func (in *instance) retireIfIdle(d time.Duration) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.retired || in.active > 0 || len(in.waiters) > 0 || time.Since(in.lastUsed) < d {
+		return false
+	}
+	in.retired = true
+	return true
+}
+
+// capacity returns how many calls may run at once right now
+func (in *instance) capacity() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.capacityLocked()
+}
+
+// live returns the number of workers still in rotation
+func (in *instance) live() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	n := 0
+	for _, w := range in.workers {
+		if !w.dead {
+			n++
+		}
+	}
+	return n
+}
+
+// snapshot returns the current workers
+func (in *instance) snapshot() []*worker {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return append([]*worker(nil), in.workers...)
+}
+
+// stats returns the number of running and queued calls
+func (in *instance) stats() (running, queued int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.active, len(in.waiters)
+}
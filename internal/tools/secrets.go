@@ -0,0 +1,182 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secret values in tool info and logs
+const redactedValue = "<redacted>"
+
+// minSecretLen keeps very short values from masking unrelated text in logs
+const minSecretLen = 4
+
+// Secrets maps tool names to the secret environment variables they get.
+type Secrets map[string]map[string]string
+
+// LoadSecrets reads a secrets file. A missing file has no secrets; one that group
+// or others can read is refused.
+func LoadSecrets(path string) (Secrets, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Secrets{}, nil
+		}
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("secrets file %s is readable by other users; run: chmod 600 %s", path, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secrets := Secrets{}
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", path, err)
+	}
+	return secrets, nil
+}
+
+// secrets loads the secrets file, which is re-read for every process so edits
+// apply without a restart, and updates the redactor with its values
+func (m *Manager) secrets() (Secrets, error) {
+	secrets, err := LoadSecrets(m.cfg.SecretsPath())
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, vars := range secrets {
+		for _, value := range vars {
+			values = append(values, value)
+		}
+	}
+	m.redactor.Set(values)
+	return secrets, nil
+}
+
+// ToolEnv returns the environment variables for a tool's processes: the manifest's
+// runtime.env, then the config's tool_env for the tool, then its secrets, each
+// overriding the last.
+func (m *Manager) ToolEnv(tool *Tool) (map[string]string, error) {
+	secrets, err := m.secrets()
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string)
+	for key, value := range tool.Manifest.Runtime.Env {
+		env[key] = expandToolVar(value, tool)
+	}
+	for key, value := range m.cfg.ToolEnv[tool.Name] {
+		env[key] = expandToolVar(value, tool)
+	}
+	for key, value := range secrets[tool.Name] {
+		env[key] = value
+	}
+	return env, nil
+}
+
+// expandableVars are the server environment variables ${VAR} may name. Tool info
+// shows the expanded environment, so anything else, which could be a credential
+// of the server's, is left unexpanded; secrets belong in the secrets file.
+var expandableVars = map[string]bool{
+	"HOME":   true,
+	"USER":   true,
+	"PATH":   true,
+	"TMPDIR": true,
+	"LANG":   true,
+}
+
+// expandToolVar expands ${JB_TOOL_DIR} to the tool's directory and the
+// expandableVars from the server's environment
+func expandToolVar(value string, tool *Tool) string {
+	return os.Expand(value, func(name string) string {
+		if name == "JB_TOOL_DIR" {
+			return tool.Path
+		}
+		if expandableVars[name] {
+			return os.Getenv(name)
+		}
+		return "${" + name + "}"
+	})
+}
+
+// redactedEnv is a tool's environment with secret values hidden, for tool info
+func (m *Manager) redactedEnv(tool *Tool) map[string]string {
+	env, err := m.ToolEnv(tool)
+	if err != nil || len(env) == 0 {
+		return nil
+	}
+	secrets, _ := m.secrets()
+	for key := range secrets[tool.Name] {
+		env[key] = redactedValue
+	}
+	return env
+}
+
+// Redactor masks known secret values in text.
+type Redactor struct {
+	mu       sync.RWMutex
+	replacer *strings.Replacer
+}
+
+// Set replaces the values to mask.
+func (r *Redactor) Set(values []string) {
+	// Longest first, so a secret containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var pairs []string
+	for _, value := range values {
+		if len(value) >= minSecretLen {
+			pairs = append(pairs, value, redactedValue)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(pairs) == 0 {
+		r.replacer = nil
+		return
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with every secret value masked.
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// Writer wraps w so everything written through it is redacted. Each write is
+// redacted on its own, which suits the log package's one write per line.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactingWriter{r: r, w: w}
+}
+
+type redactingWriter struct {
+	r *Redactor
+	w io.Writer
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.r.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Redactor returns the redactor holding the current secret values.
+func (m *Manager) Redactor() *Redactor {
+	return m.redactor
+}
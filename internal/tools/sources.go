@@ -0,0 +1,147 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/richinsley/jumpboot"
+)
+
+// jumpboot's package helpers take a single conda channel and no pip --find-links,
+// so pip and micromamba are run directly with the configured package sources.
+
+// packageSources is where one install gets its packages: the configured mirrors,
+// plus a bundle's wheels when installing from a bundle
+type packageSources struct {
+	config.PackageSources
+	findLinks []string // Wheel directories pip installs from
+	noIndex   bool     // pip only installs from findLinks
+}
+
+// packageSources returns the configured package sources
+func (m *Manager) packageSources() *packageSources {
+	src := &packageSources{}
+	if m.cfg.Packages != nil {
+		src.PackageSources = *m.cfg.Packages
+	}
+	if src.Wheelhouse != "" {
+		src.findLinks = append(src.findLinks, src.Wheelhouse)
+	}
+	src.noIndex = src.Offline
+	return src
+}
+
+// offline reports whether installs must not use the network
+func (m *Manager) offline() bool {
+	return m.cfg.Packages != nil && m.cfg.Packages.Offline
+}
+
+// withWheels returns a copy of the sources that installs pip packages only from dir
+func (s *packageSources) withWheels(dir string) *packageSources {
+	src := *s
+	src.findLinks = append([]string{dir}, s.findLinks...)
+	src.noIndex = true
+	return &src
+}
+
+// pipArgs are the index and wheelhouse flags for pip install and pip download
+func (s *packageSources) pipArgs() []string {
+	var args []string
+	if s.noIndex {
+		args = append(args, "--no-index")
+	} else {
+		if s.PipIndexURL != "" {
+			args = append(args, "--index-url", s.PipIndexURL)
+		}
+		for _, url := range s.PipExtraIndexURLs {
+			args = append(args, "--extra-index-url", url)
+		}
+	}
+	for _, dir := range s.findLinks {
+		args = append(args, "--find-links", dir)
+	}
+	return args
+}
+
+// condaArgs are the channel flags for micromamba
+func (s *packageSources) condaArgs() []string {
+	args := []string{"--override-channels"}
+	for _, channel := range s.Channels() {
+		args = append(args, "-c", channel)
+	}
+	if s.Offline {
+		args = append(args, "--offline")
+	}
+	return args
+}
+
+// pipInstall runs pip install in an environment, e.g. pipInstall(env, out, "-r", path)
+func (s *packageSources) pipInstall(env *jumpboot.PythonEnvironment, out io.Writer, args ...string) error {
+	return runPip(env, out, "install", append([]string{"--no-warn-script-location"}, append(args, s.pipArgs()...)...)...)
+}
+
+// pipDownload fetches the packages in a requirements file into dir without
+// installing them
+func (s *packageSources) pipDownload(env *jumpboot.PythonEnvironment, requirements, dir string, out io.Writer) error {
+	return runPip(env, out, "download", append([]string{"--no-deps", "-d", dir, "-r", requirements}, s.pipArgs()...)...)
+}
+
+func runPip(env *jumpboot.PythonEnvironment, out io.Writer, command string, args ...string) error {
+	cmd := exec.Command(env.PipPath, append([]string{command, "--disable-pip-version-check", "--progress-bar", "off"}, args...)...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pip %s failed: %w", command, err)
+	}
+	return nil
+}
+
+// condaInstall installs conda packages into an environment in one transaction
+func (m *Manager) condaInstall(env *jumpboot.PythonEnvironment, packages []string, src *packageSources, out io.Writer) error {
+	args := append([]string{"install", "--no-rc", "-y", "--prefix", env.EnvPath}, src.condaArgs()...)
+	return m.runMicromamba(env.MicromambaPath, out, append(args, packages...)...)
+}
+
+// micromambaCreate creates an environment with python from the configured channels.
+// CreateEnvironmentMamba can then open it without going to the network.
+func (m *Manager) micromambaCreate(envName, python string, out io.Writer) error {
+	src := m.packageSources()
+	bin, err := m.micromambaPath(src.Offline, out)
+	if err != nil {
+		return err
+	}
+	if python == "" {
+		python = "3.10" // jumpboot's default
+	}
+	args := append([]string{"--root-prefix", m.cfg.EnvsDir, "create", "--no-rc", "-y", "-n", envName, "python=" + python}, src.condaArgs()...)
+	return m.runMicromamba(bin, out, args...)
+}
+
+func (m *Manager) runMicromamba(bin string, out io.Writer, args ...string) error {
+	cmd := exec.Command(bin, args...)
+	cmd.Env = append(os.Environ(), "MAMBA_ROOT_PREFIX="+m.cfg.EnvsDir)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("micromamba failed: %w", err)
+	}
+	return nil
+}
+
+// micromambaPath returns the micromamba binary jumpboot uses, downloading it unless
+// offline
+func (m *Manager) micromambaPath(offline bool, out io.Writer) (string, error) {
+	binDir := filepath.Join(m.cfg.EnvsDir, "bin")
+	bin := filepath.Join(binDir, "micromamba")
+	if _, err := os.Stat(bin); err == nil {
+		return bin, nil
+	}
+	if offline {
+		return "", fmt.Errorf("micromamba not found at %s; copy the binary there to install offline", bin)
+	}
+	return jumpboot.ExpectMicromamba(binDir, progressLogger(out))
+}
@@ -0,0 +1,433 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/calobozan/jb-serve/internal/config"
+	"github.com/google/uuid"
+	"github.com/richinsley/jumpboot"
+)
+
+// stagingDirName holds tools being built, inside the tools directory so the final
+// move is a rename on the same filesystem
+const stagingDirName = ".staging"
+
+// A tool's environment lives at envs/tool-<name>, a symlink to a shared
+// environment envs/env-<hash> keyed by its dependency spec (see envs.go). An
+// install or upgrade whose spec changed builds the new environment beside the
+// live one and swaps the link when it succeeds. (Tools installed before staging
+// have a plain directory there instead.)
+
+// envLinkName is the stable name of a tool's environment
+func envLinkName(toolName string) string {
+	return fmt.Sprintf("tool-%s", toolName)
+}
+
+// stagedInstall is a tool built beside the live version. Nothing the live
+// version uses is touched until commit, and commit can be undone until finish.
+type stagedInstall struct {
+	m      *Manager
+	id     string
+	tool   *Tool  // The tool as built, pointing at the staged directory and env
+	source string // Source to record in the lockfile
+	ref    string
+
+	stagedDir string // Staged checkout; "" for local installs, which use the source in place
+	bundleDir string // Unpacked bundle, for installs from a bundle
+	wheels    string // The bundle's wheels; pip installs only from here
+	linkDir   string // Local install: directory the tool's symlink should point at
+	envName   string // Environment the tool will use, e.g. env-3f9c0a1b2d4e5f60
+	newEnv    bool   // envName was built by this install rather than shared
+	upgrade   *Tool  // The live tool being upgraded, nil for installs
+
+	committed bool
+	oldDir    string // Where commit moved the live tool directory
+	oldEnv    string // Where commit moved the live environment link or directory
+}
+
+func (m *Manager) newStage(source, ref string) *stagedInstall {
+	return &stagedInstall{m: m, id: uuid.NewString()[:8], source: source, ref: ref}
+}
+
+func (m *Manager) stagingDir() string {
+	return filepath.Join(m.cfg.ToolsDir, stagingDirName)
+}
+
+func (m *Manager) envsDir() string {
+	return filepath.Join(m.cfg.EnvsDir, "envs")
+}
+
+// stageInstall fetches a tool into staging and builds its environment, packages
+// and setup. Nothing under the tools directory changes until commit.
+func (m *Manager) stageInstall(source string, pins *LockedTool, out io.Writer) (*stagedInstall, error) {
+	var st *stagedInstall
+	var toolDir string
+
+	if isLocalSource(source) && isBundle(source) {
+		st = m.newStage("", "")
+		var err error
+		if pins, err = st.unpackBundle(source, pins); err != nil {
+			st.discard()
+			return nil, newError(ErrCodeInstallFailed, "", "", "%v", err)
+		}
+		toolDir = st.stagedDir
+	} else if isLocalSource(source) {
+		dir, err := localSourceDir(source)
+		if err != nil {
+			return nil, err
+		}
+		st = m.newStage(dir, "")
+		st.linkDir = dir
+		toolDir = dir
+	} else {
+		if m.offline() {
+			return nil, newError(ErrCodeInstallFailed, "", "", "offline mode: install from a local path or bundle instead of %s", source)
+		}
+		url, ref := splitRef(source)
+		st = m.newStage(gitURL(url), ref)
+		if err := os.MkdirAll(m.stagingDir(), 0755); err != nil {
+			return nil, err
+		}
+		st.stagedDir = filepath.Join(m.stagingDir(), st.id)
+		if err := cloneRef(st.source, st.ref, st.stagedDir, out); err != nil {
+			st.discard()
+			return nil, newError(ErrCodeInstallFailed, "", "", "%v", err)
+		}
+		toolDir = st.stagedDir
+	}
+
+	manifest, err := m.loadManifest(toolDir)
+	if err != nil {
+		st.discard()
+		return nil, newError(ErrCodeInstallFailed, "", "", "invalid manifest: %v", err)
+	}
+	if existing, ok := m.Get(manifest.Name); ok {
+		st.discard()
+		return nil, newError(ErrCodeAlreadyInstalled, manifest.Name, "", "tool %s already installed at %s", manifest.Name, existing.Path)
+	}
+
+	st.tool = &Tool{Name: manifest.Name, Path: toolDir, Manifest: manifest, Status: "stopped"}
+	fmt.Fprintf(out, "Preparing Python %s environment for %s...\n", manifest.Runtime.Python, manifest.Name)
+	if err := st.build(pins, out); err != nil {
+		st.discard()
+		return nil, err
+	}
+	return st, nil
+}
+
+// stageUpgrade fetches the latest version of an installed tool into staging and
+// builds it. The live version keeps running until commit.
+func (m *Manager) stageUpgrade(name string, out io.Writer) (*stagedInstall, error) {
+	live, ok := m.Get(name)
+	if !ok {
+		return nil, newError(ErrCodeToolNotFound, name, "", "tool not found: %s", name)
+	}
+
+	// Keep the source and ref it was installed with
+	source, ref := live.Path, ""
+	if entry := m.Locked(name); entry != nil {
+		source, ref = entry.Source, entry.Ref
+	}
+
+	var st *stagedInstall
+	var toolDir string
+	if info, err := os.Lstat(live.Path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(live.Path)
+		if err != nil {
+			return nil, newError(ErrCodeInstallFailed, name, "", "%s: %v", live.Path, err)
+		}
+		fmt.Fprintf(out, "%s is installed from %s; reinstalling from its current contents\n", name, target)
+		st = m.newStage(target, "")
+		toolDir = target
+	} else if _, err := os.Stat(filepath.Join(live.Path, ".git")); err == nil {
+		if m.offline() {
+			return nil, newError(ErrCodeInstallFailed, name, "", "offline mode: can't fetch updates for %s; uninstall it and install a newer bundle", name)
+		}
+		remote, err := gitOutput(live.Path, "remote", "get-url", "origin")
+		if err != nil {
+			return nil, newError(ErrCodeInstallFailed, name, "", "can't find the origin of %s: %v", live.Path, err)
+		}
+		if source == live.Path {
+			source = remote
+		}
+		st = m.newStage(source, ref)
+		if err := os.MkdirAll(m.stagingDir(), 0755); err != nil {
+			return nil, err
+		}
+		st.stagedDir = filepath.Join(m.stagingDir(), st.id)
+		if err := stageCheckout(live.Path, remote, ref, st.stagedDir, out); err != nil {
+			st.discard()
+			return nil, newError(ErrCodeInstallFailed, name, "", "%v", err)
+		}
+		toolDir = st.stagedDir
+	} else {
+		return nil, newError(ErrCodeInstallFailed, name, "", "%s is neither a git checkout nor a local install", live.Path)
+	}
+	st.upgrade = live
+
+	manifest, err := m.loadManifest(toolDir)
+	if err != nil {
+		st.discard()
+		return nil, newError(ErrCodeInstallFailed, name, "", "invalid manifest: %v", err)
+	}
+	if manifest.Name != name {
+		st.discard()
+		return nil, newError(ErrCodeInstallFailed, name, "", "manifest now names the tool %s; uninstall and reinstall instead", manifest.Name)
+	}
+
+	st.tool = &Tool{Name: name, Path: toolDir, Manifest: manifest}
+	fmt.Fprintf(out, "Building Python %s environment for %s v%s...\n", manifest.Runtime.Python, name, manifest.Version)
+	if err := st.build(nil, out); err != nil {
+		st.discard()
+		return nil, err
+	}
+	return st, nil
+}
+
+// stageCheckout copies the live checkout into dir and moves it to the tip of ref
+// on the remote
+func stageCheckout(liveDir, remote, ref, dir string, out io.Writer) error {
+	// A local clone shares objects with the live checkout, so this is cheap
+	if err := runGit("", out, []string{"clone", "--quiet", "--no-checkout", liveDir, dir}); err != nil {
+		return err
+	}
+	if err := runGit(dir, out, []string{"remote", "set-url", "origin", remote}); err != nil {
+		return err
+	}
+	return pullLatest(dir, ref, out)
+}
+
+// build finds or creates the environment for the tool's spec, installing packages
+// into a new one, and runs setup
+func (st *stagedInstall) build(pins *LockedTool, out io.Writer) error {
+	tool := st.tool
+	spec := envSpec(tool.Manifest, tool.Path, pins)
+	st.envName = sharedEnvName(spec)
+
+	src := st.m.packageSources()
+	if st.wheels != "" {
+		src = src.withWheels(st.wheels)
+	}
+	env, built, err := st.m.ensureSharedEnv(spec, tool.Manifest, tool.Path, pins, src, out)
+	if err != nil {
+		return newError(ErrCodeInstallFailed, tool.Name, "", "%v", err)
+	}
+	tool.Env = env
+	st.newEnv = built
+
+	if tool.Manifest.Setup != nil {
+		fmt.Fprintf(out, "Running setup for %s (this may take a while for model downloads)...\n", tool.Name)
+		if err := st.m.runSetup(tool); err != nil {
+			return newError(ErrCodeInstallFailed, tool.Name, "", "setup failed: %v", err)
+		}
+		fmt.Fprintf(out, "Setup complete for %s\n", tool.Name)
+	}
+	return nil
+}
+
+// commit swaps the staged tool directory and environment into place and updates
+// the tool table. The previous version is set aside until finish or rollback.
+func (st *stagedInstall) commit() error {
+	m := st.m
+	name := st.tool.Name
+	toolPath := filepath.Join(m.cfg.ToolsDir, name)
+	envLink := filepath.Join(m.envsDir(), envLinkName(name))
+
+	if st.upgrade == nil {
+		if existing, ok := m.Get(name); ok {
+			return newError(ErrCodeAlreadyInstalled, name, "", "tool %s already installed at %s", name, existing.Path)
+		}
+	}
+	if err := os.MkdirAll(m.stagingDir(), 0755); err != nil {
+		return err
+	}
+
+	// Tool directory: move the staged checkout in, or point the symlink at the source
+	switch {
+	case st.stagedDir != "":
+		if _, err := os.Lstat(toolPath); err == nil {
+			st.oldDir = filepath.Join(m.stagingDir(), name+".old-"+st.id)
+			if err := os.Rename(toolPath, st.oldDir); err != nil {
+				st.oldDir = ""
+				return fmt.Errorf("failed to set aside %s: %w", toolPath, err)
+			}
+		}
+		if err := os.Rename(st.stagedDir, toolPath); err != nil {
+			st.restoreDir(toolPath)
+			return fmt.Errorf("failed to move %s into place: %w", name, err)
+		}
+	case st.linkDir != "":
+		if err := replaceSymlink(st.linkDir, toolPath, m.stagingDir()); err != nil {
+			return fmt.Errorf("failed to link %s: %w", name, err)
+		}
+	}
+
+	// Environment: point the stable name at the staged environment
+	if _, err := os.Lstat(envLink); err == nil {
+		st.oldEnv = filepath.Join(m.envsDir(), envLinkName(name)+".old-"+st.id)
+		if err := os.Rename(envLink, st.oldEnv); err != nil {
+			st.oldEnv = ""
+			st.restoreDir(toolPath)
+			return fmt.Errorf("failed to set aside environment: %w", err)
+		}
+	}
+	if err := os.Symlink(st.envName, envLink); err != nil {
+		st.restoreEnv(envLink)
+		st.restoreDir(toolPath)
+		return fmt.Errorf("failed to link environment: %w", err)
+	}
+
+	// An upgrade installs a new *Tool rather than editing the live one, since calls
+	// in progress read its Path, Manifest and Env without the lock (as in rescan)
+	manifestSum, entrypointSum := toolDigests(toolPath, st.tool.Manifest)
+	m.mu.Lock()
+	if st.upgrade != nil {
+		updated := *st.upgrade
+		updated.Manifest = st.tool.Manifest
+		updated.Env = st.tool.Env
+		updated.manifestSum, updated.entrypointSum = manifestSum, entrypointSum
+		st.tool = &updated
+		m.tools[name] = st.tool
+	} else {
+		st.tool.Path = toolPath
+		st.tool.manifestSum, st.tool.entrypointSum = manifestSum, entrypointSum
+		m.tools[name] = st.tool
+	}
+	m.mu.Unlock()
+
+	st.committed = true
+	return nil
+}
+
+// rollback undoes a commit, putting the previous version back in place
+func (st *stagedInstall) rollback() {
+	if !st.committed {
+		return
+	}
+	m := st.m
+	name := st.tool.Name
+	toolPath := filepath.Join(m.cfg.ToolsDir, name)
+	envLink := filepath.Join(m.envsDir(), envLinkName(name))
+
+	m.mu.Lock()
+	if st.upgrade != nil {
+		m.tools[name] = st.upgrade
+	} else {
+		delete(m.tools, name)
+	}
+	m.mu.Unlock()
+
+	os.Remove(envLink)
+	st.restoreEnv(envLink)
+
+	switch {
+	case st.stagedDir != "":
+		os.Rename(toolPath, st.stagedDir)
+		st.restoreDir(toolPath)
+	case st.linkDir != "" && st.upgrade == nil:
+		os.Remove(toolPath)
+	}
+	st.committed = false
+}
+
+// restoreDir moves a set-aside tool directory back
+func (st *stagedInstall) restoreDir(toolPath string) {
+	if st.oldDir != "" {
+		os.Rename(st.oldDir, toolPath)
+		st.oldDir = ""
+	}
+}
+
+// restoreEnv moves a set-aside environment link or directory back
+func (st *stagedInstall) restoreEnv(envLink string) {
+	if st.oldEnv != "" {
+		os.Rename(st.oldEnv, envLink)
+		st.oldEnv = ""
+	}
+}
+
+// finish deletes the previous version after a successful commit and records the
+// install in the lockfile
+func (st *stagedInstall) finish(out io.Writer) {
+	if st.oldDir != "" {
+		os.RemoveAll(st.oldDir)
+	}
+	if st.bundleDir != "" {
+		os.RemoveAll(st.bundleDir)
+	}
+	if st.oldEnv != "" {
+		if target, err := os.Readlink(st.oldEnv); err == nil {
+			os.Remove(st.oldEnv)
+			st.m.releaseEnv(filepath.Base(target))
+		} else {
+			os.RemoveAll(st.oldEnv)
+		}
+	}
+	st.m.recordLock(st.tool, st.source, st.ref, out)
+}
+
+// discard deletes everything staged, after a failed build or a rollback
+func (st *stagedInstall) discard() {
+	st.rollback()
+	if st.stagedDir != "" {
+		os.RemoveAll(st.stagedDir)
+	}
+	if st.bundleDir != "" {
+		os.RemoveAll(st.bundleDir)
+	}
+	if st.newEnv {
+		st.m.releaseEnv(st.envName)
+	}
+}
+
+// replaceSymlink points link at target, replacing any existing link in one rename
+func replaceSymlink(target, link, tmpDir string) error {
+	tmp := filepath.Join(tmpDir, filepath.Base(link)+".link-"+uuid.NewString()[:8])
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// localSourceDir resolves a local install source to an absolute directory with a
+// manifest
+func localSourceDir(source string) (string, error) {
+	dir, err := filepath.Abs(expandHome(source))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jumpboot.yaml")); err != nil {
+		return "", newError(ErrCodeInstallFailed, "", "", "no jumpboot.yaml found at %s", source)
+	}
+	return dir, nil
+}
+
+// createEnvironment creates (or opens) a jumpboot environment
+func (m *Manager) createEnvironment(envName string, manifest *config.Manifest, out io.Writer) (*jumpboot.PythonEnvironment, error) {
+	if m.cfg.Packages == nil {
+		return jumpboot.CreateEnvironmentMamba(
+			envName,
+			m.cfg.EnvsDir,
+			manifest.Runtime.Python,
+			config.DefaultCondaChannel,
+			progressLogger(out),
+		)
+	}
+
+	// With custom channels or offline, create it here so jumpboot only opens it
+	envDir := filepath.Join(m.envsDir(), envName)
+	if _, err := os.Lstat(envDir); os.IsNotExist(err) {
+		if err := m.micromambaCreate(envName, manifest.Runtime.Python, out); err != nil {
+			os.RemoveAll(envDir)
+			return nil, err
+		}
+	}
+	return jumpboot.CreateEnvironmentMamba(envName, m.cfg.EnvsDir, manifest.Runtime.Python, "", progressLogger(out))
+}
@@ -0,0 +1,220 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/richinsley/jumpboot"
+)
+
+// StreamEvent is one incremental event from a streaming call
+type StreamEvent struct {
+	Type string      // "chunk", "progress" or "log"
+	Data interface{} // Event payload as sent by the tool
+}
+
+// Streaming protocol. jb-service exposes these alongside __jb_call__:
+//
+//	__jb_stream__(method, params)  -> {"ok": true, "result": {"stream": id}}
+//	__jb_stream_next__(id)         -> one event: {"chunk": ..., "done": false},
+//	                                  {"progress": {...}}, {"log": {...}}, or the final
+//	                                  {"ok": true, "result": ..., "done": true}
+//	__jb_stream_close__(id)        -> releases an unfinished stream
+//
+// Over msgpack the same names are queue commands taking {"method", "params"} and
+// {"stream": id}. Services without them are called normally and stream only the result.
+// The jb-service SDK doesn't provide them yet, so for now that's every tool using it.
+
+// streamCloseTimeout bounds releasing an abandoned stream. The call's own context
+// may already be done, and running the close under it would kill the worker.
+const streamCloseTimeout = 5 * time.Second
+
+// doStreamCall executes a method over REPL, pulling events until the final result
+func (e *Executor) doStreamCall(ctx context.Context, repl *jumpboot.REPLPythonProcess, methodName string, params map[string]interface{}, abort func(), emit func(StreamEvent) error) (interface{}, error) {
+	executeCtx := func(ctx context.Context, code string) (string, error) {
+		out, err := runWithContext(ctx, abort, func() (interface{}, error) {
+			return repl.Execute(code, true)
+		})
+		if err != nil {
+			var ce *CallError
+			if errors.As(err, &ce) {
+				return "", ce
+			}
+			return "", fmt.Errorf("call failed: %w", err)
+		}
+		return out.(string), nil
+	}
+	execute := func(code string) (string, error) {
+		return executeCtx(ctx, code)
+	}
+
+	// Older services can't stream; fall back to a single result
+	check, err := execute(`"yes" if callable(globals().get("__jb_stream__")) else "no"`)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(check, "yes") {
+		return e.doCall(ctx, repl, methodName, params, abort)
+	}
+
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	out, err := execute(fmt.Sprintf(`__jb_stream__(%q, %s)`, methodName, string(paramsJSON)))
+	if err != nil {
+		return nil, err
+	}
+	opened, err := e.parseResponse(out)
+	if err != nil {
+		return nil, err
+	}
+	id, err := streamID(opened)
+	if err != nil {
+		return nil, err
+	}
+	idJSON, err := json.Marshal(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream id: %w", err)
+	}
+
+	for {
+		out, err := execute(fmt.Sprintf(`__jb_stream_next__(%s)`, idJSON))
+		if err != nil {
+			return nil, err
+		}
+		resp, ok := decodeResponse(out)
+		if !ok {
+			return nil, fmt.Errorf("invalid stream event: %s", strings.TrimSpace(out))
+		}
+		done, result, err := relayEvent(resp, emit)
+		if err != nil {
+			if !done {
+				// The caller went away; free the generator so the worker stays usable.
+				// A close that doesn't finish in time kills the worker instead.
+				closeCtx, cancel := context.WithTimeout(context.Background(), streamCloseTimeout)
+				executeCtx(closeCtx, fmt.Sprintf(`__jb_stream_close__(%s)`, idJSON))
+				cancel()
+			}
+			return nil, err
+		}
+		if done {
+			return result, nil
+		}
+	}
+}
+
+// doQueueStreamCall executes a method over MessagePack, pulling events until the final result
+func (e *Executor) doQueueStreamCall(ctx context.Context, queue *jumpboot.QueueProcess, methodName string, params map[string]interface{}, abort func(), emit func(StreamEvent) error) (interface{}, error) {
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+
+	sendCtx := func(ctx context.Context, command string, data interface{}) (map[string]interface{}, error) {
+		out, err := runWithContext(ctx, abort, func() (interface{}, error) {
+			return queue.SendCommand(command, data, deadlineSeconds(ctx), true)
+		})
+		if err != nil {
+			var ce *CallError
+			if errors.As(err, &ce) {
+				return nil, ce
+			}
+			if strings.Contains(err.Error(), "timeout") {
+				return nil, &CallError{Code: ErrCodeTimeout, Message: err.Error()}
+			}
+			return nil, fmt.Errorf("queue call failed: %w", err)
+		}
+		return out.(map[string]interface{}), nil
+	}
+	send := func(command string, data interface{}) (map[string]interface{}, error) {
+		return sendCtx(ctx, command, data)
+	}
+
+	opened, err := send("__jb_stream__", map[string]interface{}{"method": methodName, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	if errMsg, ok := opened["error"].(string); ok {
+		// Older services can't stream; fall back to a single result
+		if strings.HasPrefix(errMsg, "Unknown command") {
+			return e.doQueueCall(ctx, queue, methodName, params, abort)
+		}
+		traceback, _ := opened["traceback"].(string)
+		return nil, pythonError("", errMsg, traceback)
+	}
+	id, err := streamID(opened["result"])
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		response, err := send("__jb_stream_next__", map[string]interface{}{"stream": id})
+		if err != nil {
+			return nil, err
+		}
+		if errMsg, ok := response["error"].(string); ok {
+			traceback, _ := response["traceback"].(string)
+			return nil, pythonError("", errMsg, traceback)
+		}
+
+		// Round-trip through JSON to read the event as a CallResponse
+		var resp CallResponse
+		data, err := json.Marshal(response["result"])
+		if err == nil {
+			err = json.Unmarshal(data, &resp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid stream event: %w", err)
+		}
+
+		done, result, err := relayEvent(&resp, emit)
+		if err != nil {
+			if !done {
+				closeCtx, cancel := context.WithTimeout(context.Background(), streamCloseTimeout)
+				sendCtx(closeCtx, "__jb_stream_close__", map[string]interface{}{"stream": id})
+				cancel()
+			}
+			return nil, err
+		}
+		if done {
+			return result, nil
+		}
+	}
+}
+
+// relayEvent passes one stream event to emit. Returns done with the final result
+// (or the tool's error) once the stream ends.
+func relayEvent(resp *CallResponse, emit func(StreamEvent) error) (bool, interface{}, error) {
+	switch {
+	case resp.Log != nil:
+		return false, nil, emit(StreamEvent{Type: "log", Data: resp.Log})
+	case resp.Progress != nil && !resp.Done:
+		return false, nil, emit(StreamEvent{Type: "progress", Data: resp.Progress})
+	case !resp.OK && (resp.Error != nil || resp.Done):
+		if resp.Error != nil {
+			return true, nil, pythonError(resp.Error.Type, resp.Error.Message, resp.Error.Traceback)
+		}
+		return true, nil, pythonError("", "call failed with unknown error", "")
+	case resp.Done:
+		return true, resp.Result, nil
+	default:
+		return false, nil, emit(StreamEvent{Type: "chunk", Data: resp.Chunk})
+	}
+}
+
+// streamID extracts the stream handle from a __jb_stream__ result
+func streamID(result interface{}) (interface{}, error) {
+	opened, ok := result.(map[string]interface{})
+	if !ok || opened["stream"] == nil {
+		return nil, fmt.Errorf("service did not open a stream")
+	}
+	return opened["stream"], nil
+}
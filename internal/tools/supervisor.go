@@ -0,0 +1,199 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// restartState tracks a tool's recent restarts for backoff and crash-loop detection
+type restartState struct {
+	history []time.Time   // Restart times within the window
+	backoff time.Duration // Delay before the next restart
+}
+
+// restartPolicy returns the tool's restart policy, "never" if unset
+func restartPolicy(tool *Tool) string {
+	if tool.Manifest.Restart == nil {
+		return "never"
+	}
+	return tool.Manifest.Restart.Policy
+}
+
+// shouldRestart decides whether a failure with the given exit error warrants a restart
+func shouldRestart(tool *Tool, exitErr error) bool {
+	switch restartPolicy(tool) {
+	case "always":
+		return true
+	case "on-failure":
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// nextRestart records a restart attempt and returns the backoff delay.
+// Returns false once the tool exceeds max_restarts within the window (a crash loop).
+func (e *Executor) nextRestart(tool *Tool) (time.Duration, bool) {
+	cfg := tool.Manifest.Restart
+	window := time.Duration(cfg.Window) * time.Second
+	initial := time.Duration(cfg.Backoff) * time.Second
+	maxBackoff := time.Duration(cfg.MaxBackoff) * time.Second
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state, ok := e.restarts[tool.Name]
+	if !ok {
+		state = &restartState{backoff: initial}
+		e.restarts[tool.Name] = state
+	}
+
+	// Forget restarts that fell out of the window
+	now := time.Now()
+	recent := state.history[:0]
+	for _, t := range state.history {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	state.history = recent
+	if len(recent) == 0 {
+		state.backoff = initial
+	}
+
+	if len(recent) >= cfg.MaxRestarts {
+		return 0, false
+	}
+
+	delay := state.backoff
+	state.backoff *= 2
+	if state.backoff > maxBackoff {
+		state.backoff = maxBackoff
+	}
+	state.history = append(state.history, now)
+	tool.RestartCount++
+	return delay, true
+}
+
+// resetRestarts clears restart history, e.g. after a manual start
+func (e *Executor) resetRestarts(toolName string) {
+	delete(e.restarts, toolName)
+}
+
+// watchWorker waits for a worker's process to exit and hands unexpected exits to the supervisor
+func (e *Executor) watchWorker(tool *Tool, inst *instance, w *worker) {
+	err := w.process().Cmd.Wait()
+	if w.stopping.Load() {
+		return
+	}
+
+	reason := "exited with status 0"
+	if err != nil {
+		reason = fmt.Sprintf("exited: %v", err)
+	}
+	log.Printf("Worker for %s %s", tool.Name, reason)
+	e.handleWorkerExit(tool, inst, w, reason, err)
+}
+
+// handleWorkerExit replaces a dead worker according to the restart policy.
+// If no restart is allowed and no live workers remain, the tool is torn down.
+func (e *Executor) handleWorkerExit(tool *Tool, inst *instance, w *worker, reason string, exitErr error) {
+	inst.markDead(w)
+	tool.LastExitReason = reason
+
+	if !shouldRestart(tool, exitErr) {
+		if inst.live() == 0 {
+			e.teardown(tool, inst, "crashed")
+		}
+		return
+	}
+
+	delay, ok := e.nextRestart(tool)
+	if !ok {
+		log.Printf("%s is crash-looping (%d restarts in %ds), giving up", tool.Name, tool.Manifest.Restart.MaxRestarts, tool.Manifest.Restart.Window)
+		e.teardown(tool, inst, "crash-loop")
+		return
+	}
+
+	go func() {
+		log.Printf("Restarting worker for %s in %v", tool.Name, delay)
+		time.Sleep(delay)
+		if !e.isCurrent(tool.Name, inst) {
+			return
+		}
+
+		fresh, err := e.spawnWorker(tool)
+		if err != nil {
+			e.handleWorkerExit(tool, inst, w, fmt.Sprintf("restart failed: %v", err), err)
+			return
+		}
+		if !e.isCurrent(tool.Name, inst) || !inst.replaceWorker(w, fresh) {
+			fresh.close()
+			return
+		}
+		go e.watchWorker(tool, inst, fresh)
+	}()
+}
+
+// handleUnhealthy restarts a tool whose health checks crossed the failure threshold
+func (e *Executor) handleUnhealthy(tool *Tool, inst *instance) {
+	if restartPolicy(tool) == "never" {
+		return
+	}
+	tool.LastExitReason = fmt.Sprintf("unhealthy after %d failed health checks", tool.HealthFailures)
+
+	delay, ok := e.nextRestart(tool)
+	if !ok {
+		log.Printf("%s is crash-looping (%d restarts in %ds), giving up", tool.Name, tool.Manifest.Restart.MaxRestarts, tool.Manifest.Restart.Window)
+		e.teardown(tool, inst, "crash-loop")
+		return
+	}
+
+	go func() {
+		log.Printf("Restarting %s in %v (unhealthy)", tool.Name, delay)
+		tool.Status = "restarting"
+		time.Sleep(delay)
+		if !e.isCurrent(tool.Name, inst) {
+			return
+		}
+		if err := e.Stop(tool.Name); err != nil {
+			log.Printf("Failed to stop %s for restart: %v", tool.Name, err)
+		}
+		if err := e.start(tool.Name, false); err != nil {
+			log.Printf("Failed to restart %s: %v", tool.Name, err)
+			tool.Status = "crashed"
+			tool.LastExitReason = fmt.Sprintf("restart failed: %v", err)
+		}
+	}()
+}
+
+// isCurrent reports whether inst is still the running instance for a tool
+func (e *Executor) isCurrent(toolName string, inst *instance) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.instances[toolName] == inst
+}
+
+// teardown stops a failed instance and leaves the tool in the given status
+func (e *Executor) teardown(tool *Tool, inst *instance, status string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.instances[tool.Name] != inst {
+		return
+	}
+	if cancel, ok := e.healthCancels[tool.Name]; ok {
+		cancel()
+		delete(e.healthCancels, tool.Name)
+	}
+	inst.retire()
+	for _, w := range inst.snapshot() {
+		w.close()
+	}
+	delete(e.instances, tool.Name)
+	e.resources.release(tool.Name)
+	tool.Status = status
+	tool.HealthStatus = ""
+	log.Printf("%s is %s: %s", tool.Name, status, tool.LastExitReason)
+}